@@ -1,6 +1,7 @@
 package cryptobot
 
 import (
+	"fmt"
 	"math/big"
 	"sort"
 	"time"
@@ -20,6 +21,15 @@ type Transfer struct {
 	BlockHeight uint64
 	Timestamp   uint64
 	TxHash      string
+	// Index is the position of the transfer in the transaction,
+	// such as the input/output index for UTXO-based blockchains
+	Index uint
+}
+
+// ID returns the identity of the transfer which is unique
+// for the same transaction, index and the type of the transfer
+func (bc Transfer) ID() string {
+	return fmt.Sprintf("%s:%d:%d", bc.TxHash, bc.Index, bc.Type)
 }
 
 // Value returns the normalized value depending on the tpye of the balance change
@@ -39,6 +49,10 @@ func (bc Transfer) Value() *big.Int {
 type AccountMovements struct {
 	Address   string
 	Transfers []*Transfer
+	// ScannedHeight is the height of the last block which the changes were looked for in,
+	// or zero if unknown. Providers scanning a bounded range of blocks at a time set it,
+	// so that the next check goes on from there even if nothing is found in the range
+	ScannedHeight uint64
 }
 
 // NewAccountMovements creates a new instance of AccountMovement
@@ -57,8 +71,22 @@ func (am *AccountMovements) Sort() *AccountMovements {
 	return am
 }
 
-// Receive adds a transfer as received to the list of changes at the given block height
-func (am *AccountMovements) Receive(blockHeight uint64, timestamp uint64, txHash string, amount *big.Int, address string) {
+// Since returns a copy of the changes at or after the given block height.
+// The copy shares the transfers but can be sorted independently
+func (am *AccountMovements) Since(blockHeight uint64) *AccountMovements {
+	c := NewAccountMovements(am.Address)
+	c.ScannedHeight = am.ScannedHeight
+	for _, t := range am.Transfers {
+		if t.BlockHeight >= blockHeight {
+			c.Transfers = append(c.Transfers, t)
+		}
+	}
+	return c
+}
+
+// Receive adds a transfer as received to the list of changes at the given block height.
+// index is the position of the transfer in the transaction, e.g. output index
+func (am *AccountMovements) Receive(blockHeight uint64, timestamp uint64, txHash string, index uint, amount *big.Int, address string) {
 	am.Transfers = append(
 		am.Transfers,
 		&Transfer{
@@ -67,13 +95,15 @@ func (am *AccountMovements) Receive(blockHeight uint64, timestamp uint64, txHash
 			BlockHeight: blockHeight,
 			Timestamp:   timestamp,
 			TxHash:      txHash,
+			Index:       index,
 			Type:        Received,
 		},
 	)
 }
 
-// Spend adds a transfer as spent to the list of changes at the given block height
-func (am *AccountMovements) Spend(blockHeight uint64, timestamp uint64, txHash string, amount *big.Int, address string) {
+// Spend adds a transfer as spent to the list of changes at the given block height.
+// index is the position of the transfer in the transaction, e.g. input index
+func (am *AccountMovements) Spend(blockHeight uint64, timestamp uint64, txHash string, index uint, amount *big.Int, address string) {
 	am.Transfers = append(
 		am.Transfers,
 		&Transfer{
@@ -82,6 +112,7 @@ func (am *AccountMovements) Spend(blockHeight uint64, timestamp uint64, txHash s
 			BlockHeight: blockHeight,
 			Timestamp:   timestamp,
 			TxHash:      txHash,
+			Index:       index,
 			Type:        Spent,
 		},
 	)
@@ -3,259 +3,385 @@ package application
 import (
 	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/scheduler"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
 )
 
 var (
-	errInexistentCurrency = errors.New("inexistent currency")
+	errInexistentCurrency     = errors.New("inexistent currency")
+	errNoVerificationProvider = errors.New("no verification provider")
+	errNoScanCursorRepository = errors.New("repository does not keep scan cursors")
 )
 
+// MaxConflictRetries is the number of times a command is run again when
+// the subscriptions it saves have been modified concurrently
+const MaxConflictRetries = 3
+
 // SubscriptionApplication exposes application services for subscription entity
 type SubscriptionApplication struct {
-	r domain.SubscriptionRepository
+	r  domain.SubscriptionRepository
+	cr *services.CurrencyRegistry
 }
 
 // NewSubscriptionApplication factory function
-func NewSubscriptionApplication(repo domain.SubscriptionRepository) *SubscriptionApplication {
+func NewSubscriptionApplication(repo domain.SubscriptionRepository, registry *services.CurrencyRegistry) *SubscriptionApplication {
 	return &SubscriptionApplication{
-		r: repo,
+		r:  repo,
+		cr: registry,
 	}
 }
 
+// AvailableCurrencies returns the currencies which can be subscribed for
+func (sa *SubscriptionApplication) AvailableCurrencies() []domain.Currency {
+	return sa.cr.Currencies()
+}
+
 // Subscribe creates a new subscription
 func (sa *SubscriptionApplication) Subscribe(userID string, currencySymbol string, account string) error {
-	if err := sa.r.Begin(); err != nil {
-		return err
-	}
-
-	s, err := sa.subscribe(userID, currencySymbol, account)
-	if err != nil {
-		return sa.returnError(err)
-	}
-
-	if err := sa.r.Save(s); err != nil {
-		return sa.returnError(err)
-	}
-
-	sa.r.Success()
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := sa.subscribe(r, userID, currencySymbol, account)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return r.Save(s)
+	})
 }
 
 // Unsubscribe removes the given subscription
 func (sa *SubscriptionApplication) Unsubscribe(subscriptionID string) error {
-	if err := sa.r.Begin(); err != nil {
-		return err
-	}
-
-	s, err := sa.r.Get(subscriptionID)
-	if err != nil {
-		return sa.returnError(err)
-	}
-
-	if err := sa.r.Remove(s); err != nil {
-		return sa.returnError(err)
-	}
-
-	sa.r.Success()
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subscriptionID)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return r.Remove(s)
+	})
 }
 
 // UnsubscribeAllForUser removes all subscription belogs to the given user
 func (sa *SubscriptionApplication) UnsubscribeAllForUser(userID string) error {
-	if err := sa.r.Begin(); err != nil {
-		return err
-	}
-
-	subs, err := sa.r.GetAllForUser(userID)
-	if err != nil {
-		return sa.returnError(err)
-	}
-
-	for _, s := range subs {
-		if err := sa.r.Remove(s); err != nil {
-			return sa.returnError(err)
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		subs, err := r.GetAllForUser(userID)
+		if err != nil {
+			return err
 		}
-	}
 
-	sa.r.Success()
+		for _, s := range subs {
+			if err := r.Remove(s); err != nil {
+				return err
+			}
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // AddAmountFilter adds a new amount filter
 func (sa *SubscriptionApplication) AddAmountFilter(subsID string, amount string, must bool) error {
-	if err := sa.r.Begin(); err != nil {
-		return err
-	}
-
-	s, err := sa.r.Get(subsID)
-	if err != nil {
-		return sa.returnError(err)
-	}
-
-	f, err := domain.NewAmountFilter(amount, must)
-	if err != nil {
-		return sa.returnError(err)
-	}
-	s.AddFilter(f)
-
-	if err := sa.r.Save(s); err != nil {
-		return sa.returnError(err)
-	}
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subsID)
+		if err != nil {
+			return err
+		}
 
-	sa.r.Success()
+		f, err := domain.NewAmountFilter(amount, must)
+		if err != nil {
+			return err
+		}
+		s.AddFilter(f)
 
-	return nil
+		return r.Save(s)
+	})
 }
 
 // AddAddressOnFilter adds a new address-off filter
 func (sa *SubscriptionApplication) AddAddressOnFilter(subsID string, address string, must bool) error {
-	if err := sa.r.Begin(); err != nil {
-		return err
-	}
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subsID)
+		if err != nil {
+			return err
+		}
 
-	s, err := sa.r.Get(subsID)
-	if err != nil {
-		return sa.returnError(err)
-	}
+		f, err := domain.NewAddressOnFilter(address, must)
+		if err != nil {
+			return err
+		}
+		s.AddFilter(f)
 
-	f, err := domain.NewAddressOnFilter(address, must)
-	if err != nil {
-		return sa.returnError(err)
-	}
-	s.AddFilter(f)
+		return r.Save(s)
+	})
+}
 
-	if err := sa.r.Save(s); err != nil {
-		return sa.returnError(err)
-	}
+// AddAddressOffFilter adds a new address-on filter
+func (sa *SubscriptionApplication) AddAddressOffFilter(subsID string, address string, must bool) error {
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subsID)
+		if err != nil {
+			return err
+		}
+
+		f, err := domain.NewAddressOffFilter(address, must)
+		if err != nil {
+			return err
+		}
+		s.AddFilter(f)
 
-	sa.r.Success()
+		return r.Save(s)
+	})
+}
 
-	return nil
+// RemoveFilters removes all the filters for the given subscription
+func (sa *SubscriptionApplication) RemoveFilters(subsID string) error {
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subsID)
+		if err != nil {
+			return err
+		}
+		s.RemoveFilters()
+
+		return r.Save(s)
+	})
 }
 
-// AddAddressOffFilter adds a new address-on filter
-func (sa *SubscriptionApplication) AddAddressOffFilter(subsID string, address string, must bool) error {
-	if err := sa.r.Begin(); err != nil {
+// EnableDigest switches the given subscription to digest mode which publishes a summary of
+// the movements on the given cron-style schedule evaluated in the given time zone
+func (sa *SubscriptionApplication) EnableDigest(subsID string, schedule string, timeZone string) error {
+	if _, err := scheduler.ParseCron(schedule); err != nil {
 		return err
 	}
 
-	s, err := sa.r.Get(subsID)
-	if err != nil {
-		return sa.returnError(err)
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		return err
 	}
 
-	f, err := domain.NewAddressOffFilter(address, must)
-	if err != nil {
-		return sa.returnError(err)
-	}
-	s.AddFilter(f)
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subsID)
+		if err != nil {
+			return err
+		}
 
-	if err := sa.r.Save(s); err != nil {
-		return sa.returnError(err)
-	}
+		if s == nil {
+			return fmt.Errorf("no subscription found for %s", subsID)
+		}
+		s.EnableDigest(schedule, timeZone, time.Now())
 
-	sa.r.Success()
+		return r.Save(s)
+	})
+}
 
-	return nil
+// DisableDigest switches the given subscription back to publishing every movement
+func (sa *SubscriptionApplication) DisableDigest(subsID string) error {
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		s, err := r.Get(subsID)
+		if err != nil {
+			return err
+		}
+
+		if s == nil {
+			return fmt.Errorf("no subscription found for %s", subsID)
+		}
+		s.DisableDigest()
+
+		return r.Save(s)
+	})
 }
 
-// RemoveFilters removes all the filters for the given subscription
-func (sa *SubscriptionApplication) RemoveFilters(subsID string) error {
-	if err := sa.r.Begin(); err != nil {
+// PublishDueDigests publishes the digest summaries of the subscriptions
+// for the given currency whose schedules are due at the given time.
+// Each digest is published in a unit of work of its own, so that a
+// conflict on one of them does not roll back the others
+func (sa *SubscriptionApplication) PublishDueDigests(currencySymbol string, now time.Time) error {
+	var subs []*domain.Subscription
+	err := sa.inUnitOfWork(func(r *unitOfWork) (err error) {
+		subs, err = r.GetAllWithDigest(currencySymbol)
 		return err
-	}
-
-	s, err := sa.r.Get(subsID)
+	})
 	if err != nil {
-		return sa.returnError(err)
+		return err
 	}
-	s.RemoveFilters()
 
-	if err := sa.r.Save(s); err != nil {
-		return sa.returnError(err)
+	for _, s := range subs {
+		err := sa.inUnitOfWorkOn([]*domain.Subscription{s}, func(r *unitOfWork, subs []*domain.Subscription) error {
+			// The digest might have been published or disabled after a conflict
+			if len(subs) == 0 || subs[0].Digest() == nil {
+				return nil
+			}
+
+			d := subs[0].Digest()
+			due, err := scheduler.IsDue(d.Schedule(), d.TimeZone(), d.LastSentAt(), now)
+			if err != nil {
+				// Do not let a corrupted schedule block the others
+				log.Printf("cannot evaluate digest schedule of subscription(%s), %s", subs[0].ID(), err.Error())
+				return nil
+			}
+
+			if !due {
+				return nil
+			}
+
+			subs[0].PublishDigest(now)
+
+			return r.Save(subs[0])
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	sa.r.Success()
-
 	return nil
 }
 
 // GetSubscription returns the details of the given subscription
 func (sa *SubscriptionApplication) GetSubscription(id string) (*domain.Subscription, error) {
-	if err := sa.r.Begin(); err != nil {
-		return nil, err
-	}
-
-	s, err := sa.r.Get(id)
-	if err != nil {
-		return nil, sa.returnError(err)
-	}
-
-	sa.r.Success()
+	var s *domain.Subscription
+	err := sa.inUnitOfWork(func(r *unitOfWork) (err error) {
+		s, err = r.Get(id)
+		return err
+	})
 
-	return s, nil
+	return s, err
 }
 
 // GetSubscriptionsForUser returns the details of all subscriptions for the given user
 func (sa *SubscriptionApplication) GetSubscriptionsForUser(userID string) ([]*domain.Subscription, error) {
-	if err := sa.r.Begin(); err != nil {
-		return nil, err
+	var subs []*domain.Subscription
+	err := sa.inUnitOfWork(func(r *unitOfWork) (err error) {
+		subs, err = r.GetAllForUser(userID)
+		return err
+	})
+
+	return subs, err
+}
+
+// GetSubscriptionsForCurrency returns all subscriptions for a given currency that are updated before the given blockheight
+func (sa *SubscriptionApplication) GetSubscriptionsForCurrency(currencySymbol string, updatedBefore uint64) ([]*domain.Subscription, error) {
+	var subs []*domain.Subscription
+	err := sa.inUnitOfWork(func(r *unitOfWork) (err error) {
+		subs, err = r.GetAllForCurrency(currencySymbol, updatedBefore)
+		return err
+	})
+
+	return subs, err
+}
+
+// GetSubscriptionsForAccount returns all subscriptions for the given account of the given currency
+func (sa *SubscriptionApplication) GetSubscriptionsForAccount(currencySymbol string, account string) ([]*domain.Subscription, error) {
+	var subs []*domain.Subscription
+	err := sa.inUnitOfWork(func(r *unitOfWork) (err error) {
+		subs, err = r.GetAllForAccount(currencySymbol, account)
+		return err
+	})
+
+	return subs, err
+}
+
+// CheckAndApplyAccountMovements checks whether there is any movement
+// for the given account and if there is, applies them to the account.
+func (sa *SubscriptionApplication) CheckAndApplyAccountMovements(s *domain.Subscription) error {
+	if s == nil {
+		return fmt.Errorf("nil subscription")
+	}
+
+	return sa.CheckAndApplyAccountMovementsForAccount([]*domain.Subscription{s})
+}
+
+// CheckAndApplyAccountMovementsForAccount checks the movements of the account once for all
+// the given subscriptions to it, from the lowest block height among them, and applies them
+// to each subscription in the same unit of work. The subscriptions must be of the same currency and account.
+// The movements are checked before the unit of work begins, so that it does not wait for the currency service
+func (sa *SubscriptionApplication) CheckAndApplyAccountMovementsForAccount(subs []*domain.Subscription) error {
+	if len(subs) == 0 {
+		return nil
 	}
 
-	subs, err := sa.r.GetAllForUser(userID)
+	acm, err := sa.checkAccountMovements(subs)
 	if err != nil {
-		return nil, sa.returnError(err)
+		return err
 	}
 
-	sa.r.Success()
+	return sa.ApplyAccountMovements(subs, acm)
+}
 
-	return subs, nil
+// ApplyAccountMovements applies the given movements of an account, e.g. found by scanning
+// a block, to the given subscriptions to the account in the same unit of work
+func (sa *SubscriptionApplication) ApplyAccountMovements(subs []*domain.Subscription, acm *domain.AccountMovements) error {
+	return sa.inUnitOfWorkOn(subs, func(r *unitOfWork, subs []*domain.Subscription) error {
+		return sa.applyAccountMovements(r, subs, acm)
+	})
 }
 
-// GetSubscriptionsForCurrency returns all subscriptions for a given currency that are updated before the given blockheight
-func (sa *SubscriptionApplication) GetSubscriptionsForCurrency(currencySymbol string, updatedBefore uint64) ([]*domain.Subscription, error) {
-	if err := sa.r.Begin(); err != nil {
-		return nil, err
+// GetScanCursor returns the height of the last scanned block for the given currency
+// and false if the currency has not been scanned yet
+func (sa *SubscriptionApplication) GetScanCursor(currencySymbol string) (uint64, bool, error) {
+	if _, ok := sa.r.(domain.ScanCursorRepository); !ok {
+		return 0, false, errNoScanCursorRepository
 	}
 
-	subs, err := sa.r.GetAllForCurrency(currencySymbol, updatedBefore)
-	if err != nil {
-		return nil, sa.returnError(err)
+	var bh uint64
+	var exist bool
+	err := sa.inUnitOfWork(func(r *unitOfWork) (err error) {
+		bh, exist, err = r.SubscriptionRepository.(domain.ScanCursorRepository).GetScanCursor(currencySymbol)
+		return err
+	})
+
+	return bh, exist, err
+}
+
+// SaveScanCursor persists the height of the last scanned block for the given currency
+func (sa *SubscriptionApplication) SaveScanCursor(currencySymbol string, blockHeight uint64) error {
+	if _, ok := sa.r.(domain.ScanCursorRepository); !ok {
+		return errNoScanCursorRepository
 	}
 
-	sa.r.Success()
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		return r.SubscriptionRepository.(domain.ScanCursorRepository).SaveScanCursor(currencySymbol, blockHeight)
+	})
+}
 
-	return subs, nil
+// VerifiableCurrencies returns the currencies which have a verification provider
+func (sa *SubscriptionApplication) VerifiableCurrencies() []domain.Currency {
+	currencies := []domain.Currency{}
+	for _, c := range sa.cr.Currencies() {
+		if _, exist := sa.cr.VerificationService(c.Symbol); exist {
+			currencies = append(currencies, c)
+		}
+	}
+	return currencies
 }
 
-// CheckAndApplyAccountMovements checks whether there is any movement
-// for the given account and if there is, applies them to the account.
-func (sa *SubscriptionApplication) CheckAndApplyAccountMovements(s *domain.Subscription) error {
-	if err := sa.r.Begin(); err != nil {
-		return err
+// CrossCheckAccountMovements re-fetches the movements of the given subscription from the
+// verification provider of its currency and compares them against the applied ones
+func (sa *SubscriptionApplication) CrossCheckAccountMovements(s *domain.Subscription) (*domain.DivergenceReport, error) {
+	if s == nil {
+		return nil, fmt.Errorf("nil subscription")
 	}
 
-	if err := sa.checkAndApplyAccountMovements(s); err != nil {
-		return sa.returnError(err)
+	v, exist := sa.cr.VerificationService(s.Currency().Symbol)
+	if !exist {
+		return nil, errNoVerificationProvider
 	}
 
-	sa.r.Success()
+	acm, err := v.GetAccountMovements(s.Account(), s.StartingBlockHeight())
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	return s.CrossCheck(v.Name, acm)
 }
 
-func (sa *SubscriptionApplication) subscribe(userID string, currencySymbol string, account string) (*domain.Subscription, error) {
-	c, exist := services.CurrencyFactory[currencySymbol]
+func (sa *SubscriptionApplication) subscribe(r *unitOfWork, userID string, currencySymbol string, account string) (*domain.Subscription, error) {
+	c, exist := sa.cr.Currency(currencySymbol)
 	if !exist {
 		return nil, errInexistentCurrency
 	}
 
-	cs, exist := services.CurrencyServiceFactory[currencySymbol]
+	cs, exist := sa.cr.CurrencyService(currencySymbol)
 	if !exist {
 		return nil, errInexistentCurrency
 	}
@@ -266,7 +392,7 @@ func (sa *SubscriptionApplication) subscribe(userID string, currencySymbol strin
 	}
 
 	s, err := domain.NewSubscription(
-		sa.r.NextIdentity(userID),
+		r.NextIdentity(userID),
 		userID,
 		account,
 		c,
@@ -279,27 +405,142 @@ func (sa *SubscriptionApplication) subscribe(userID string, currencySymbol strin
 	return s, nil
 }
 
-func (sa *SubscriptionApplication) checkAndApplyAccountMovements(s *domain.Subscription) error {
-	if s == nil {
-		return fmt.Errorf("nil subscription")
+// Returns the movements of the account of the given subscriptions after the lowest block height among them
+func (sa *SubscriptionApplication) checkAccountMovements(subs []*domain.Subscription) (*domain.AccountMovements, error) {
+	currency, account := subs[0].Currency().Symbol, subs[0].Account()
+	since := subs[0].BlockHeight()
+	for _, s := range subs {
+		if s.Currency().Symbol != currency || s.Account() != account {
+			return nil, fmt.Errorf("subscription(%s) is not for %s account(%s)", s.ID(), currency, account)
+		}
+
+		if s.BlockHeight() < since {
+			since = s.BlockHeight()
+		}
 	}
 
-	cs, exist := services.CurrencyServiceFactory[s.Currency().Symbol]
+	cs, exist := sa.cr.CurrencyService(currency)
 	if !exist {
-		return fmt.Errorf("no currency service found for %s", s.Currency().Symbol)
+		return nil, fmt.Errorf("no currency service found for %s", currency)
 	}
 
-	acm, err := cs.GetAccountMovements(s.Account(), s.BlockHeight()+1)
-	if err != nil {
+	return cs.GetAccountMovements(account, since+1)
+}
+
+func (sa *SubscriptionApplication) applyAccountMovements(r *unitOfWork, subs []*domain.Subscription, acm *domain.AccountMovements) error {
+	// Each subscription only gets the movements after its own block height
+	// as if it had checked them by itself. So the movements are applied once
+	// even if the subscriptions are reloaded with a greater height after a conflict
+	for _, s := range subs {
+		m := acm.Since(s.BlockHeight() + 1)
+		// The movements might be of the account in another form, e.g. normalized
+		m.Address = s.Account()
+		s.ApplyMovements(m.Sort())
+
+		if err := r.Save(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unitOfWork is a unit of work in progress on a repository. It keeps the subscriptions saved
+// in it to publish their domain events once it succeeds, so that no event is published for the
+// changes which are rolled back, or published twice when the work is done again after a conflict
+type unitOfWork struct {
+	domain.SubscriptionRepository
+	saved []*domain.Subscription
+}
+
+// Save saves the given subscription and keeps it to publish its events
+func (u *unitOfWork) Save(s *domain.Subscription) error {
+	if err := u.SubscriptionRepository.Save(s); err != nil {
 		return err
 	}
 
-	s.ApplyMovements(acm.Sort())
+	u.saved = append(u.saved, s)
+
+	return nil
+}
 
-	return sa.r.Save(s)
+func (u *unitOfWork) publishEvents() {
+	for _, s := range u.saved {
+		s.PublishEvents()
+	}
 }
 
-func (sa *SubscriptionApplication) returnError(err error) error {
-	sa.r.Fail()
-	return err
+// Returns a repository of its own for a unit of work if the repository supports
+// concurrent units of work, so that the unit does not wait for the others
+func (sa *SubscriptionApplication) repository() domain.SubscriptionRepository {
+	if sr, ok := sa.r.(domain.SessionRepository); ok {
+		return sr.NewSession()
+	}
+	return sa.r
+}
+
+// Runs the given command in a unit of work. If a subscription saved by the command has been modified
+// concurrently, or the unit of work conflicts with another one when it is finalized, runs it again
+// from scratch in a new unit of work on the latest state
+func (sa *SubscriptionApplication) inUnitOfWork(command func(r *unitOfWork) error) error {
+	repo := sa.repository()
+
+	for retries := 0; ; retries++ {
+		if err := repo.Begin(); err != nil {
+			return err
+		}
+
+		r := &unitOfWork{SubscriptionRepository: repo}
+		err := command(r)
+		if err != nil {
+			r.Fail()
+		} else if err = r.Success(); err == nil {
+			r.publishEvents()
+			return nil
+		}
+
+		if !errors.Is(err, domain.ErrConcurrentModification) || retries == MaxConflictRetries {
+			return err
+		}
+
+		log.Printf("retrying the command after a conflict, %s", err.Error())
+	}
+}
+
+// Runs the given command on the given subscriptions like inUnitOfWork. Since the
+// given subscriptions are stale after a conflict, it runs again on the reloaded ones
+func (sa *SubscriptionApplication) inUnitOfWorkOn(
+	subs []*domain.Subscription,
+	command func(r *unitOfWork, subs []*domain.Subscription) error,
+) error {
+	stale := false
+	return sa.inUnitOfWork(func(r *unitOfWork) error {
+		if stale {
+			latest, err := reload(r, subs)
+			if err != nil {
+				return err
+			}
+			subs = latest
+		}
+		stale = true
+
+		return command(r, subs)
+	})
+}
+
+// Returns the latest state of the given subscriptions leaving out the removed ones
+func reload(r *unitOfWork, subs []*domain.Subscription) ([]*domain.Subscription, error) {
+	latest := make([]*domain.Subscription, 0, len(subs))
+	for _, s := range subs {
+		l, err := r.Get(s.ID())
+		if err != nil {
+			return nil, err
+		}
+
+		if l != nil {
+			latest = append(latest, l)
+		}
+	}
+
+	return latest, nil
 }
@@ -0,0 +1,239 @@
+package application_test
+
+import (
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/application"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/persistence/inmemory"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
+)
+
+var tst = domain.Currency{Symbol: "tst", Decimal: big.NewInt(1)}
+
+type stubCurrencyService struct {
+	mu        sync.Mutex
+	movements *domain.AccountMovements
+	since     []uint64
+}
+
+func (s *stubCurrencyService) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.since = append(s.since, sinceBlockHeight)
+	// Returns a copy as a provider would, so that the applications cannot affect each other
+	return s.movements.Since(sinceBlockHeight), nil
+}
+
+func (s *stubCurrencyService) GetLatestBlockHeight() (uint64, error) {
+	return 100, nil
+}
+
+type eventCounter struct {
+	events []interface{}
+}
+
+func (c *eventCounter) HandleEvent(e interface{}) {
+	c.events = append(c.events, e)
+}
+
+func (c *eventCounter) SubscribedToEventType() reflect.Type {
+	return reflect.TypeOf(new(domain.AllDomainEvents))
+}
+
+// recordingRepository records the number of events published by the time each unit of work
+// is finalized, and rolls back the given number of units of work as if they conflicted
+type recordingRepository struct {
+	*inmemory.SubscriptionRepository
+	counter            *eventCounter
+	conflicts          int
+	publishedAtSuccess []int
+}
+
+func (r *recordingRepository) Success() error {
+	r.publishedAtSuccess = append(r.publishedAtSuccess, len(r.counter.events))
+	if r.conflicts > 0 {
+		r.conflicts--
+		r.SubscriptionRepository.Fail()
+		return domain.ErrConcurrentModification
+	}
+	return r.SubscriptionRepository.Success()
+}
+
+func newApplication(t *testing.T, r domain.SubscriptionRepository, cs domain.CurrencyService) *application.SubscriptionApplication {
+	cr, err := services.NewCurrencyRegistry([]services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{Type: services.EsploraProvider, URL: "http://localhost"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cr.Register(tst, cs); err != nil {
+		t.Fatal(err)
+	}
+
+	return application.NewSubscriptionApplication(r, cr)
+}
+
+func newSubscription(t *testing.T, r domain.SubscriptionRepository, id string, account string, blockHeight uint64) *domain.Subscription {
+	s, err := domain.NewSubscription(id, "user-1", account, tst, blockHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestCheckAndApplyAccountMovementsForAccount(t *testing.T) {
+	acm := domain.NewAccountMovements("addr-1")
+	acm.Receive(5, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	acm.Receive(15, 1613721192, "txhash-test2", 0, big.NewInt(7), "addr-sender")
+	cs := &stubCurrencyService{movements: acm}
+
+	r := inmemory.NewSubscriptionRepository()
+	s1 := newSubscription(t, r, "sub-1", "addr-1", 10)
+	s2 := newSubscription(t, r, "sub-2", "addr-1", 0)
+	sa := newApplication(t, r, cs)
+	domain.DomainEventPublisherInstance().Reset()
+
+	if err := sa.CheckAndApplyAccountMovementsForAccount([]*domain.Subscription{s1, s2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fetched once from the lowest block height among the subscriptions
+	if len(cs.since) != 1 || cs.since[0] != 1 {
+		t.Fatalf("expected one fetch since block#%d but got %v", 1, cs.since)
+	}
+
+	// Each subscription only gets the movements after its own block height
+	s1, _ = r.Get("sub-1")
+	if s1.TotalReceived().Cmp(big.NewInt(7)) != 0 || s1.BlockHeight() != 15 {
+		t.Fatalf("expected %d received up to block#%d but got %s up to block#%d", 7, 15, s1.TotalReceived(), s1.BlockHeight())
+	}
+
+	s2, _ = r.Get("sub-2")
+	if s2.TotalReceived().Cmp(big.NewInt(12)) != 0 || s2.BlockHeight() != 15 {
+		t.Fatalf("expected %d received up to block#%d but got %s up to block#%d", 12, 15, s2.TotalReceived(), s2.BlockHeight())
+	}
+
+	// Nothing is applied twice
+	if err := sa.CheckAndApplyAccountMovementsForAccount([]*domain.Subscription{s1, s2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cs.since) != 2 || cs.since[1] != 16 {
+		t.Fatalf("expected the second fetch since block#%d but got %v", 16, cs.since)
+	}
+
+	if s2.TotalReceived().Cmp(big.NewInt(12)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 12, s2.TotalReceived())
+	}
+}
+
+func TestCheckAndApplyAccountMovementsForAccount_AnotherAccount(t *testing.T) {
+	cs := &stubCurrencyService{movements: domain.NewAccountMovements("addr-1")}
+
+	r := inmemory.NewSubscriptionRepository()
+	s1 := newSubscription(t, r, "sub-1", "addr-1", 10)
+	s2 := newSubscription(t, r, "sub-2", "addr-2", 10)
+	sa := newApplication(t, r, cs)
+
+	if err := sa.CheckAndApplyAccountMovementsForAccount([]*domain.Subscription{s1, s2}); err == nil {
+		t.Fatal("expected an error for the subscriptions to different accounts but got nothing")
+	}
+
+	if len(cs.since) != 0 {
+		t.Fatalf("expected no fetch but got %d", len(cs.since))
+	}
+}
+
+func TestApplyAccountMovements_ConcurrentModification(t *testing.T) {
+	acm := domain.NewAccountMovements("addr-1")
+	acm.Receive(15, 1613721192, "txhash-test1", 0, big.NewInt(7), "addr-sender")
+
+	counter := &eventCounter{}
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(counter)
+	defer domain.DomainEventPublisherInstance().Reset()
+
+	r := &recordingRepository{SubscriptionRepository: inmemory.NewSubscriptionRepository(), counter: counter}
+	s := newSubscription(t, r, "sub-1", "addr-1", 10)
+	sa := newApplication(t, r, &stubCurrencyService{})
+
+	// Loaded before another unit of work saves the subscription
+	stale, err := domain.DeepCopySubscription(s.ID(), s.UserID(), s.Account(), s.Currency(), nil,
+		big.NewInt(0), big.NewInt(0), s.BlockHeight(), s.StartingBlockHeight(), nil, nil, s.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sa.ApplyAccountMovements([]*domain.Subscription{stale}, acm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Applied once to the reloaded subscription
+	s, _ = r.Get("sub-1")
+	if s.TotalReceived().Cmp(big.NewInt(7)) != 0 || s.BlockHeight() != 15 {
+		t.Fatalf("expected %d received up to block#%d but got %s up to block#%d", 7, 15, s.TotalReceived(), s.BlockHeight())
+	}
+
+	// Published once, after the unit of work succeeded, and not for the rolled back attempt
+	if len(r.publishedAtSuccess) != 1 || r.publishedAtSuccess[0] != 0 {
+		t.Fatalf("expected no event published before the unit of work succeeded but got %v", r.publishedAtSuccess)
+	}
+
+	if len(counter.events) != 1 {
+		t.Fatalf("expected %d event but got %d", 1, len(counter.events))
+	}
+}
+
+func TestApplyAccountMovements_ConflictOnSuccess(t *testing.T) {
+	acm := domain.NewAccountMovements("addr-1")
+	acm.Receive(15, 1613721192, "txhash-test1", 0, big.NewInt(7), "addr-sender")
+
+	counter := &eventCounter{}
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(counter)
+	defer domain.DomainEventPublisherInstance().Reset()
+
+	r := &recordingRepository{SubscriptionRepository: inmemory.NewSubscriptionRepository(), counter: counter}
+	newSubscription(t, r, "sub-1", "addr-1", 10)
+	s, _ := r.Get("sub-1")
+	sa := newApplication(t, r, &stubCurrencyService{})
+	r.conflicts = 1
+
+	if err := sa.ApplyAccountMovements([]*domain.Subscription{s}, acm); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ = r.Get("sub-1")
+	if s.TotalReceived().Cmp(big.NewInt(7)) != 0 || s.BlockHeight() != 15 {
+		t.Fatalf("expected %d received up to block#%d but got %s up to block#%d", 7, 15, s.TotalReceived(), s.BlockHeight())
+	}
+
+	// Nothing is published for the unit of work which failed to be finalized
+	if len(r.publishedAtSuccess) != 2 || r.publishedAtSuccess[1] != 0 {
+		t.Fatalf("expected no event published before the unit of work succeeded but got %v", r.publishedAtSuccess)
+	}
+
+	if len(counter.events) != 1 {
+		t.Fatalf("expected %d event but got %d", 1, len(counter.events))
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook/blockbooktest"
+)
+
+// Runs a fake Blockbook serving a scripted chain state, so that blockbook providers can be
+// pointed at it for demos and local testing. The chain advances on a timer if an interval is
+// given and on every POST /fake/mine, e.g. curl -X POST http://localhost:9130/fake/mine
+func main() {
+	statePath := flag.String("state", "./cmd/fakeblockbook/example.state.json", "path of the chain state file")
+	addr := flag.String("addr", "localhost:9130", "address to listen on")
+	interval := flag.Duration("interval", 0, "time in-between blocks, e.g. 10s. Blocks are only mined on request if 0")
+	flag.Parse()
+
+	state, err := blockbooktest.LoadState(*statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := blockbooktest.NewServer(state)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *interval > 0 {
+		go func() {
+			for range time.Tick(*interval) {
+				log.Printf("mined block#%d", s.Mine())
+			}
+		}()
+	}
+
+	log.Printf("Starting fake blockbook on %s at block#%d", *addr, s.BestHeight())
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/application"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/bloom"
+)
+
+// False positive rate of the prefilter of the watched addresses
+const addressFilterFalsePositiveRate = 0.001
+
+// BlockObserver observes for account movements by walking each new block once and
+// matching its txs against the accounts of all subscriptions for the currency
+type BlockObserver struct {
+	sa                *application.SubscriptionApplication
+	p                 Publisher
+	isObserving       bool
+	observeInterval   time.Duration
+	blockHeightMargin uint64
+	currency          string
+	cs                domain.CurrencyService
+	bs                domain.BlockScanner
+	ds                *DigestScheduler
+}
+
+// NewBlockObserver creates a new instance of BlockObserver for the given currency and its currency
+// service. Returns an error if the currency service is not able to scan blocks
+func NewBlockObserver(
+	sa *application.SubscriptionApplication,
+	p Publisher,
+	currency string,
+	cs domain.CurrencyService,
+	opts ...*ObserverOptions,
+) (*BlockObserver, error) {
+	bs, ok := cs.(domain.BlockScanner)
+	if !ok {
+		return nil, fmt.Errorf("currency service of %s is not able to scan blocks", currency)
+	}
+
+	o := &BlockObserver{
+		currency:        currency,
+		cs:              cs,
+		bs:              bs,
+		sa:              sa,
+		p:               p,
+		observeInterval: observeInterval,
+	}
+
+	for _, opt := range opts {
+		// If no BlockHeightMargin is provided, it will set to 0 by default
+		o.blockHeightMargin = opt.BlockHeightMargin
+
+		if opt.ObserveInterval != 0 {
+			o.observeInterval = opt.ObserveInterval
+		}
+	}
+
+	return o, nil
+}
+
+// SetDigestScheduler sets the scheduler to publish digest summaries after every observal
+func (o *BlockObserver) SetDigestScheduler(ds *DigestScheduler) {
+	o.ds = ds
+}
+
+// Start starts observing for changes and blocks the current working thread
+func (o *BlockObserver) Start() {
+	log.Printf("Starting BlockObserver")
+
+	o.isObserving = true
+	for o.isObserving {
+		if err := o.observe(); err != nil {
+			log.Printf("error while observing: %s", err.Error())
+		}
+
+		if o.ds != nil {
+			if err := o.ds.Run(time.Now()); err != nil {
+				log.Printf("error while publishing digests: %s", err.Error())
+			}
+		}
+
+		time.Sleep(o.observeInterval)
+	}
+}
+
+// Stop stops observing after the block being scanned
+func (o *BlockObserver) Stop() {
+	o.isObserving = false
+}
+
+func (o *BlockObserver) observe() error {
+	domain.DomainEventPublisherInstance().
+		Subscribe(NewAccountAssetMovedEventSubscriber(o.p))
+	defer domain.DomainEventPublisherInstance().Reset()
+
+	bh, err := o.cs.GetLatestBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	if bh < o.blockHeightMargin {
+		return nil
+	}
+	target := bh - o.blockHeightMargin
+
+	subs, err := o.sa.GetSubscriptionsForCurrency(o.currency, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	cursor, exist, err := o.sa.GetScanCursor(o.currency)
+	if err != nil {
+		return err
+	}
+
+	// Starts from the lowest block height among the existing subscriptions, so that their movements
+	// are caught up with by scanning the blocks as well. Each subscription only gets the movements
+	// after its own block height
+	if !exist {
+		cursor = target
+		for _, s := range subs {
+			if s.BlockHeight() < cursor {
+				cursor = s.BlockHeight()
+			}
+		}
+
+		if err := o.sa.SaveScanCursor(o.currency, cursor); err != nil {
+			return err
+		}
+	}
+
+	idx := o.index(subs)
+	for h := cursor + 1; h <= target && o.isObserving; h++ {
+		if err := o.scan(idx, h); err != nil {
+			return fmt.Errorf("cannot scan block#%d, %s", h, err.Error())
+		}
+
+		if err := o.sa.SaveScanCursor(o.currency, h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *BlockObserver) scan(idx *addressIndex, blockHeight uint64) error {
+	acms, err := o.bs.GetBlockMovements(blockHeight, idx.contains)
+	if err != nil {
+		return err
+	}
+
+	for _, acm := range acms {
+		if err := o.sa.ApplyAccountMovements(idx.subscriptions[acm.Address], acm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Indexes the subscriptions by the normalized forms of their accounts
+func (o *BlockObserver) index(subs []*domain.Subscription) *addressIndex {
+	idx := &addressIndex{
+		filter:        bloom.NewFilter(len(subs), addressFilterFalsePositiveRate),
+		subscriptions: make(map[string][]*domain.Subscription),
+	}
+
+	for _, s := range subs {
+		address, err := o.bs.NormalizeAddress(s.Account())
+		if err != nil {
+			log.Printf("cannot watch the account of subscription(%s), %s", s.ID(), err.Error())
+			continue
+		}
+
+		idx.filter.Add(address)
+		idx.subscriptions[address] = append(idx.subscriptions[address], s)
+	}
+
+	return idx
+}
+
+// addressIndex keeps the subscriptions of the watched addresses. Most of the addresses in
+// a block are not watched, so they are ruled out by the filter before looking them up
+type addressIndex struct {
+	filter        *bloom.Filter
+	subscriptions map[string][]*domain.Subscription
+}
+
+func (idx *addressIndex) contains(address string) bool {
+	if !idx.filter.Test(address) {
+		return false
+	}
+
+	_, exist := idx.subscriptions[address]
+	return exist
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/application"
+)
+
+// AccountDigestReadyEventSubscriber implements domain.DomainEventSubscriber interface
+type AccountDigestReadyEventSubscriber struct {
+	p Publisher
+}
+
+// NewAccountDigestReadyEventSubscriber creates a new instance of subscriber for AccountDigestReady event
+func NewAccountDigestReadyEventSubscriber(p Publisher) *AccountDigestReadyEventSubscriber {
+	return &AccountDigestReadyEventSubscriber{
+		p: p,
+	}
+}
+
+// HandleEvent sends the digest summary to the owner of the subscription
+func (s *AccountDigestReadyEventSubscriber) HandleEvent(event interface{}) {
+	evt, b := event.(*domain.AccountDigestReadyEvent)
+	if !b {
+		log.Printf("unexpected event type, %+v\n", event)
+		return
+	}
+	s.p.PublishMessage(domain.UserIDFrom(evt.SubscriptionID()), evt)
+}
+
+// SubscribedToEventType returns type of AccountDigestReadyEvent to subscribe for it
+func (s *AccountDigestReadyEventSubscriber) SubscribedToEventType() reflect.Type {
+	return reflect.TypeOf(new(domain.AccountDigestReadyEvent))
+}
+
+// DigestScheduler publishes the summaries of the subscriptions
+// in digest mode when their schedules are due
+type DigestScheduler struct {
+	sa       *application.SubscriptionApplication
+	p        Publisher
+	currency string
+}
+
+// NewDigestScheduler creates a new instance of DigestScheduler for the given currency
+func NewDigestScheduler(sa *application.SubscriptionApplication, p Publisher, currency string) *DigestScheduler {
+	return &DigestScheduler{
+		sa:       sa,
+		p:        p,
+		currency: currency,
+	}
+}
+
+// Run publishes the digests which are due at the given time
+func (ds *DigestScheduler) Run(now time.Time) error {
+	domain.DomainEventPublisherInstance().
+		Subscribe(NewAccountDigestReadyEventSubscriber(ds.p))
+	defer domain.DomainEventPublisherInstance().Reset()
+
+	return ds.sa.PublishDueDigests(ds.currency, now)
+}
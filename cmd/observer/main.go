@@ -22,8 +22,10 @@ type Config struct {
 	} `yaml:"telebot"`
 	Observer struct {
 		Currency          string        `yaml:"currency"`
+		Mode              string        `yaml:"mode"`
 		BlockHeightMargin uint64        `yaml:"block-margin"`
 		Interval          time.Duration `yaml:"interval"`
+		ReconcileInterval time.Duration `yaml:"reconcile-interval"`
 		Parallelism       int           `yaml:"parallelism"`
 		ExitTimeout       time.Duration `yaml:"exit-timeout"`
 	} `yaml:"observer"`
@@ -32,6 +34,20 @@ type Config struct {
 		Name string `yaml:"name"`
 		URI  string `yaml:"uri"`
 	} `yaml:"database"`
+	Currencies []services.CurrencyConfig `yaml:"currencies"`
+}
+
+// Observer modes
+const (
+	PollingMode       = "polling"
+	BlockScanningMode = "block-scanning"
+)
+
+// Observer is either MovementObserver or BlockObserver
+type Observer interface {
+	Start()
+	Stop()
+	SetDigestScheduler(ds *DigestScheduler)
 }
 
 func readConfig(path string) (*Config, error) {
@@ -57,6 +73,16 @@ func main() {
 		log.Fatal(err)
 	}
 
+	registry, err := services.NewCurrencyRegistry(c.Currencies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cs, ok := registry.CurrencyService(c.Observer.Currency)
+	if !ok {
+		log.Fatalf("no service found for currency %s", c.Observer.Currency)
+	}
+
 	subsRepo := services.RepositoryServiceFactory[c.Database.Type]
 	if subsRepo == nil {
 		panic(fmt.Errorf("there is no repository implementation for the given database type(%s)", c.Database.Type))
@@ -67,19 +93,37 @@ func main() {
 	}
 	defer subsRepo.Disconnect()
 
-	o := NewMovementObserver(
-		application.NewSubscriptionApplication(subsRepo),
-		telegram.NewPublisher(c.Telebot.Token, telegram.MovementFormatter),
+	subsApp := application.NewSubscriptionApplication(subsRepo, registry)
+	publisher := telegram.NewPublisher(c.Telebot.Token, telegram.MovementFormatter)
+	opts := &ObserverOptions{
+		BlockHeightMargin: c.Observer.BlockHeightMargin,
+		ObserveInterval:   c.Observer.Interval * time.Second,
+		ReconcileInterval: c.Observer.ReconcileInterval * time.Second,
+		MaxParallelism:    c.Observer.Parallelism,
+		ExitTimeout:       c.Observer.ExitTimeout * time.Second,
+	}
+
+	var o Observer
+	switch c.Observer.Mode {
+	case "", PollingMode:
+		o = NewMovementObserver(subsApp, publisher, c.Observer.Currency, cs, opts)
+	case BlockScanningMode:
+		bo, err := NewBlockObserver(subsApp, publisher, c.Observer.Currency, cs, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		o = bo
+	default:
+		log.Fatalf("unknown observer mode %s", c.Observer.Mode)
+	}
+
+	o.SetDigestScheduler(NewDigestScheduler(
+		subsApp,
+		telegram.NewPublisher(c.Telebot.Token, telegram.DigestFormatter),
 		c.Observer.Currency,
-		&ObserverOptions{
-			BlockHeightMargin: c.Observer.BlockHeightMargin,
-			ObserveInterval:   c.Observer.Interval * time.Second,
-			MaxParallelism:    c.Observer.Parallelism,
-			ExitTimeout:       c.Observer.ExitTimeout * time.Second,
-		},
-	)
-
-	sig := make(chan os.Signal)
+	))
+
+	sig := make(chan os.Signal, 1)
 	// Check for interrupt and kill signals so that we stop observer gracefully
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
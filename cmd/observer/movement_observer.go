@@ -1,9 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"math"
 	"reflect"
+	"sync"
 	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
@@ -45,7 +46,14 @@ func (s *AccountAssetMovedEventSubscriber) SubscribedToEventType() reflect.Type
 	return reflect.TypeOf(new(domain.AccountAssetsMovedEvent))
 }
 
+// Currency services which cache the responses report their hit rates
+type cachedService interface {
+	Stats() services.CacheStats
+}
+
 const observeInterval = time.Second * 20
+const reconcileInterval = time.Minute * 10
+const statsInterval = time.Hour
 const exitTimeout = time.Second * 30
 const maxParallelism = 1000
 
@@ -58,6 +66,9 @@ type ObserverOptions struct {
 	BlockHeightMargin uint64
 	// Sleep time inbetween every observal
 	ObserveInterval time.Duration
+	// Interval of polling all the subscriptions while the movements are pushed by the currency
+	// service, to pick up the ones whose notifications were dropped or missed
+	ReconcileInterval time.Duration
 	// Maximum number of goroutines for one observal
 	MaxParallelism int
 	// Timeout when stopping the observer
@@ -71,22 +82,39 @@ type MovementObserver struct {
 	p                 Publisher
 	isObserving       bool
 	observeInterval   time.Duration
+	reconcileInterval time.Duration
+	polledAt          time.Time
+	statsLoggedAt     time.Time
 	maxParallelism    int
 	exitTimeout       time.Duration
 	blockHeightMargin uint64
 	currency          string
 	cs                domain.CurrencyService
+	n                 domain.MovementNotifier
+	streaming         bool
+	ds                *DigestScheduler
+	// Serializes the observals and the handling of notifications
+	// since they share the singleton domain event publisher
+	m sync.Mutex
 }
 
-// NewMovementObserver creates a new instance of MovementObserver. It will panic if no service can be found for the given currency
-func NewMovementObserver(sa *application.SubscriptionApplication, p Publisher, currency string, opts ...*ObserverOptions) *MovementObserver {
+// NewMovementObserver creates a new instance of MovementObserver for the given currency and its currency service
+func NewMovementObserver(
+	sa *application.SubscriptionApplication,
+	p Publisher,
+	currency string,
+	cs domain.CurrencyService,
+	opts ...*ObserverOptions,
+) *MovementObserver {
 	o := &MovementObserver{
-		currency:        currency,
-		sa:              sa,
-		p:               p,
-		observeInterval: observeInterval,
-		exitTimeout:     exitTimeout,
-		maxParallelism:  maxParallelism,
+		currency:          currency,
+		cs:                cs,
+		sa:                sa,
+		p:                 p,
+		observeInterval:   observeInterval,
+		reconcileInterval: reconcileInterval,
+		exitTimeout:       exitTimeout,
+		maxParallelism:    maxParallelism,
 	}
 
 	for _, opt := range opts {
@@ -96,6 +124,9 @@ func NewMovementObserver(sa *application.SubscriptionApplication, p Publisher, c
 		if opt.ObserveInterval != 0 {
 			o.observeInterval = opt.ObserveInterval
 		}
+		if opt.ReconcileInterval != 0 {
+			o.reconcileInterval = opt.ReconcileInterval
+		}
 		if opt.ExitTimeout != 0 {
 			o.exitTimeout = opt.ExitTimeout
 		}
@@ -104,27 +135,43 @@ func NewMovementObserver(sa *application.SubscriptionApplication, p Publisher, c
 		}
 	}
 
-	cs, ok := services.CurrencyServiceFactory[o.currency]
-	if !ok {
-		panic(fmt.Errorf("no service found for currency %s", o.currency))
+	// Currency services which can push notifications let the observer react to the movements immediately
+	if n, ok := cs.(domain.MovementNotifier); ok {
+		o.n = n
 	}
-	o.cs = cs
 
 	o.w = concurrency.NewWorker(o.maxParallelism, o.exitTimeout)
 
 	return o
 }
 
+// SetDigestScheduler sets the scheduler to publish digest summaries after every observal
+func (o *MovementObserver) SetDigestScheduler(ds *DigestScheduler) {
+	o.ds = ds
+}
+
 // Start starts observing for changes and blocks the current working thread
 func (o *MovementObserver) Start() {
 	log.Printf("Starting MovementObserver")
 
 	o.isObserving = true
+	if o.n != nil {
+		go o.listen()
+	}
+
 	for o.isObserving {
 		if err := o.observe(); err != nil {
 			log.Printf("error while observing: %s", err.Error())
 		}
 
+		// Digests are published in-between observals not to
+		// interfere with the event subscribers of the observal
+		if o.ds != nil {
+			if err := o.ds.Run(time.Now()); err != nil {
+				log.Printf("error while publishing digests: %s", err.Error())
+			}
+		}
+
 		time.Sleep(o.observeInterval)
 	}
 }
@@ -136,6 +183,18 @@ func (o *MovementObserver) Stop() {
 }
 
 func (o *MovementObserver) observe() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	// Movements are pushed by the currency service while it is connected. Polling is needed
+	// when it is down or has just come up, to catch up with the missed movements, and once
+	// in a reconcile interval while connected, since the notifications are not guaranteed
+	connected := o.n != nil && o.n.IsConnected()
+	if connected && o.streaming && time.Since(o.polledAt) < o.reconcileInterval {
+		return o.watch()
+	}
+	o.streaming = false
+
 	domain.DomainEventPublisherInstance().
 		Subscribe(NewAccountAssetMovedEventSubscriber(o.p))
 	defer domain.DomainEventPublisherInstance().Reset()
@@ -150,13 +209,13 @@ func (o *MovementObserver) observe() error {
 		return err
 	}
 
-	// And then check whether or not there is
-	// a change in movement for each in parallel
-	for _, s := range subs {
-		// Create a local copy of the slice's items to pass to the worker's runner function
-		cs := *s
+	// And then check whether or not there is a change in movement for each
+	// account in parallel. The subscriptions to the same account share the check
+	for _, group := range groupByAccount(subs) {
+		// Create a local copy of the loop variable to pass to the worker's runner function
+		g := group
 		if _, err := o.w.Run(func() {
-			if e := o.sa.CheckAndApplyAccountMovements(&cs); e != nil {
+			if e := o.sa.CheckAndApplyAccountMovementsForAccount(g); e != nil {
 				log.Printf("error while observing: %s", e.Error())
 			}
 		}); err != nil {
@@ -165,6 +224,80 @@ func (o *MovementObserver) observe() error {
 	}
 
 	o.w.WaitAll()
+	o.polledAt = time.Now()
+
+	// Cache stats are cumulative, so they are logged once in a while rather than every observal
+	if c, ok := o.cs.(cachedService); ok && time.Since(o.statsLoggedAt) >= statsInterval {
+		log.Printf("%s cache: %s", o.currency, c.Stats())
+		o.statsLoggedAt = time.Now()
+	}
+
+	if o.n == nil {
+		return nil
+	}
+
+	if err := o.watch(); err != nil {
+		return err
+	}
+	o.streaming = connected
 
 	return nil
 }
+
+// Watches the accounts of all subscriptions for the currency, including
+// the ones subscribed after the previous observal
+func (o *MovementObserver) watch() error {
+	subs, err := o.sa.GetSubscriptionsForCurrency(o.currency, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	accounts := make([]string, 0, len(subs))
+	for _, s := range subs {
+		accounts = append(accounts, s.Account())
+	}
+
+	return o.n.WatchAddresses(accounts)
+}
+
+// Observes the accounts notified by the currency service in-between observals
+func (o *MovementObserver) listen() {
+	for account := range o.n.Notifications() {
+		if err := o.observeAccount(account); err != nil {
+			log.Printf("error while observing %s: %s", account, err.Error())
+		}
+	}
+}
+
+func (o *MovementObserver) observeAccount(account string) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	domain.DomainEventPublisherInstance().
+		Subscribe(NewAccountAssetMovedEventSubscriber(o.p))
+	defer domain.DomainEventPublisherInstance().Reset()
+
+	subs, err := o.sa.GetSubscriptionsForAccount(o.currency, account)
+	if err != nil {
+		return err
+	}
+
+	return o.sa.CheckAndApplyAccountMovementsForAccount(subs)
+}
+
+// Groups the subscriptions by their accounts in the order of their first appearance
+func groupByAccount(subs []*domain.Subscription) [][]*domain.Subscription {
+	indices := make(map[string]int)
+	groups := make([][]*domain.Subscription, 0)
+	for _, s := range subs {
+		i, exist := indices[s.Account()]
+		if !exist {
+			i = len(groups)
+			indices[s.Account()] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], s)
+	}
+
+	return groups
+}
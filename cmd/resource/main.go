@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/psychoplasma/crypto-balance-bot/application"
@@ -13,18 +14,24 @@ import (
 )
 
 var subsApp *application.SubscriptionApplication
+var verifier *Verifier
 
 // Config is a configuration for telegram bot
 type Config struct {
 	Resource struct {
-		Host string `yaml:"host"`
-		Port int    `yaml:"port"`
+		Host         string `yaml:"host"`
+		Port         int    `yaml:"port"`
+		Verification struct {
+			Interval   time.Duration `yaml:"interval"`
+			SampleSize int           `yaml:"sample-size"`
+		} `yaml:"verification"`
 	} `yaml:"resource"`
 	Database struct {
 		Type string `yaml:"type"`
 		Name string `yaml:"name"`
 		URI  string `yaml:"uri"`
 	} `yaml:"database"`
+	Currencies []services.CurrencyConfig `yaml:"currencies"`
 }
 
 func main() {
@@ -33,6 +40,11 @@ func main() {
 		log.Fatal(err)
 	}
 
+	registry, err := services.NewCurrencyRegistry(c.Currencies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	subsRepo := services.RepositoryServiceFactory[c.Database.Type]
 	if subsRepo == nil {
 		panic(fmt.Errorf("there is no repository implementation for the given database type(%s)", c.Database.Type))
@@ -42,7 +54,12 @@ func main() {
 		panic(err)
 	}
 	defer subsRepo.Disconnect()
-	subsApp = application.NewSubscriptionApplication(subsRepo)
+	subsApp = application.NewSubscriptionApplication(subsRepo, registry)
+
+	if c.Resource.Verification.Interval > 0 && len(subsApp.VerifiableCurrencies()) > 0 {
+		verifier = NewVerifier(subsApp, c.Resource.Verification.Interval*time.Second, c.Resource.Verification.SampleSize)
+		go verifier.Start()
+	}
 
 	listenAndServe(c.Resource.Host, c.Resource.Port)
 }
@@ -55,6 +72,7 @@ func listenAndServe(host string, port int) {
 	router.HandleFunc("/assets", GetAvailableAssets).Methods("GET")
 	router.HandleFunc("/subscriptions/user/{userID}", GetSubscriptionsForUser).Methods("GET")
 	router.HandleFunc("/subscriptions/{id}", GetSubscription).Methods("GET")
+	router.HandleFunc("/verification/reports", GetDivergenceReports).Methods("GET")
 
 	log.Fatal(http.ListenAndServe(addr, router))
 }
@@ -7,7 +7,6 @@ import (
 
 	"github.com/gorilla/mux"
 	domain "github.com/psychoplasma/crypto-balance-bot"
-	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
 )
 
 // Subscription represents domain.Subscription for resource
@@ -54,8 +53,13 @@ func fromDomainSlice(subs []*domain.Subscription) []*Subscription {
 
 // GetAvailableAssets returns available assets that can be subscribed for
 func GetAvailableAssets(w http.ResponseWriter, r *http.Request) {
+	currencies := make(map[string]domain.Currency)
+	for _, c := range subsApp.AvailableCurrencies() {
+		currencies[c.Symbol] = c
+	}
+
 	w.Header().Set("content-type", "application/json")
-	if err := json.NewEncoder(w).Encode(services.CurrencyFactory); err != nil {
+	if err := json.NewEncoder(w).Encode(currencies); err != nil {
 		http.Error(w, fmt.Sprintf("cannot encode data to json, %s", err.Error()), http.StatusInternalServerError)
 	}
 }
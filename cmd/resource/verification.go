@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/application"
+)
+
+// Max. number of divergence reports kept
+const maxReports = 100
+
+// Number of subscriptions verified for each currency if no sample size is given
+const defaultSampleSize = 10
+
+// DivergenceReport represents domain.DivergenceReport for resource
+type DivergenceReport struct {
+	SubscriptionID        string      `json:"subscription_id"`
+	Account               string      `json:"account"`
+	Currency              string      `json:"currency"`
+	Provider              string      `json:"provider"`
+	BlockHeight           uint64      `json:"block_height"`
+	MissingTransfers      []*Transfer `json:"missing_transfers"`
+	UnexpectedTransfers   []string    `json:"unexpected_transfers"`
+	TotalReceived         string      `json:"total_received"`
+	TotalSpent            string      `json:"total_spent"`
+	ProviderTotalReceived string      `json:"provider_total_received"`
+	ProviderTotalSpent    string      `json:"provider_total_spent"`
+	DetectedAt            time.Time   `json:"detected_at"`
+}
+
+// Transfer represents domain.Transfer for resource
+type Transfer struct {
+	TxHash      string `json:"tx_hash"`
+	Index       uint   `json:"index"`
+	Type        string `json:"type"`
+	Amount      string `json:"amount"`
+	BlockHeight uint64 `json:"block_height"`
+}
+
+func fromDomainEvent(evt *domain.ProviderDivergenceDetectedEvent) *DivergenceReport {
+	r := evt.Report()
+	ts := make([]*Transfer, len(r.MissingTransfers))
+	for i, t := range r.MissingTransfers {
+		typ := "received"
+		if t.Type == domain.Spent {
+			typ = "spent"
+		}
+		ts[i] = &Transfer{
+			TxHash:      t.TxHash,
+			Index:       t.Index,
+			Type:        typ,
+			Amount:      t.Amount.String(),
+			BlockHeight: t.BlockHeight,
+		}
+	}
+
+	return &DivergenceReport{
+		SubscriptionID:        r.SubscriptionID,
+		Account:               r.Account,
+		Currency:              r.Currency.Symbol,
+		Provider:              r.Provider,
+		BlockHeight:           r.BlockHeight,
+		MissingTransfers:      ts,
+		UnexpectedTransfers:   r.UnexpectedTransfers,
+		TotalReceived:         r.TotalReceived.String(),
+		TotalSpent:            r.TotalSpent.String(),
+		ProviderTotalReceived: r.ProviderTotalReceived.String(),
+		ProviderTotalSpent:    r.ProviderTotalSpent.String(),
+		DetectedAt:            evt.OccurredOn(),
+	}
+}
+
+// ProviderDivergenceDetectedEventSubscriber implements domain.DomainEventSubscriber interface
+type ProviderDivergenceDetectedEventSubscriber struct {
+	v *Verifier
+}
+
+// HandleEvent keeps the divergence report to be served by the resource
+func (s *ProviderDivergenceDetectedEventSubscriber) HandleEvent(event interface{}) {
+	evt, b := event.(*domain.ProviderDivergenceDetectedEvent)
+	if !b {
+		log.Printf("unexpected event type, %+v\n", event)
+		return
+	}
+
+	r := evt.Report()
+	log.Printf("provider %s diverges for subscription(%s), %d missing and %d unexpected transfers",
+		r.Provider, r.SubscriptionID, len(r.MissingTransfers), len(r.UnexpectedTransfers))
+	s.v.addReport(evt)
+}
+
+// SubscribedToEventType returns type of ProviderDivergenceDetectedEvent to subscribe for it
+func (s *ProviderDivergenceDetectedEventSubscriber) SubscribedToEventType() reflect.Type {
+	return reflect.TypeOf(new(domain.ProviderDivergenceDetectedEvent))
+}
+
+// Verifier periodically cross-checks a random sample of the subscriptions
+// against the verification providers of their currencies
+type Verifier struct {
+	sa         *application.SubscriptionApplication
+	interval   time.Duration
+	sampleSize int
+
+	m       sync.Mutex
+	reports []*domain.ProviderDivergenceDetectedEvent
+}
+
+// NewVerifier creates a new instance of Verifier. The sample size defaults to defaultSampleSize if not positive
+func NewVerifier(sa *application.SubscriptionApplication, interval time.Duration, sampleSize int) *Verifier {
+	if sampleSize <= 0 {
+		sampleSize = defaultSampleSize
+	}
+
+	return &Verifier{
+		sa:         sa,
+		interval:   interval,
+		sampleSize: sampleSize,
+	}
+}
+
+// Start starts verifying periodically and blocks the current working thread
+func (v *Verifier) Start() {
+	for {
+		v.Run()
+		time.Sleep(v.interval)
+	}
+}
+
+// Run cross-checks a sample of the subscriptions for each verifiable currency
+func (v *Verifier) Run() {
+	domain.DomainEventPublisherInstance().
+		Subscribe(&ProviderDivergenceDetectedEventSubscriber{v: v})
+	defer domain.DomainEventPublisherInstance().Reset()
+
+	for _, c := range v.sa.VerifiableCurrencies() {
+		subs, err := v.sa.GetSubscriptionsForCurrency(c.Symbol, math.MaxInt64)
+		if err != nil {
+			log.Printf("error while verifying %s: %s", c.Symbol, err.Error())
+			continue
+		}
+
+		rand.Shuffle(len(subs), func(i, j int) { subs[i], subs[j] = subs[j], subs[i] })
+		if len(subs) > v.sampleSize {
+			subs = subs[:v.sampleSize]
+		}
+
+		for _, s := range subs {
+			if _, err := v.sa.CrossCheckAccountMovements(s); err != nil {
+				log.Printf("error while verifying subscription(%s): %s", s.ID(), err.Error())
+			}
+		}
+	}
+}
+
+// Reports returns the latest divergence reports, the most recent one first
+func (v *Verifier) Reports() []*DivergenceReport {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	reports := make([]*DivergenceReport, 0, len(v.reports))
+	for i := len(v.reports) - 1; i >= 0; i-- {
+		reports = append(reports, fromDomainEvent(v.reports[i]))
+	}
+
+	return reports
+}
+
+func (v *Verifier) addReport(evt *domain.ProviderDivergenceDetectedEvent) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	v.reports = append(v.reports, evt)
+	if len(v.reports) > maxReports {
+		v.reports = v.reports[len(v.reports)-maxReports:]
+	}
+}
+
+// GetDivergenceReports returns the latest divergence reports of the verification providers
+func GetDivergenceReports(w http.ResponseWriter, r *http.Request) {
+	reports := []*DivergenceReport{}
+	if verifier != nil {
+		reports = verifier.Reports()
+	}
+
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		http.Error(w, fmt.Sprintf("cannot encode data to json, %s", err.Error()), http.StatusInternalServerError)
+	}
+}
@@ -37,6 +37,18 @@ var commands = map[string]command{
 		Description:    "Deletes all subscriptions of the sender",
 		ParameterCount: 0,
 	},
+	"digest": {
+		Endpoint:       "/digest",
+		Usage:          "/digest <subscription ID> <time zone> <cron expression>",
+		Description:    "Publishes a summary of the movements on the given schedule instead of every movement, e.g. /digest <subscription ID> Europe/Berlin 0 9 * * *",
+		ParameterCount: 3,
+	},
+	"digest_off": {
+		Endpoint:       "/digest_off",
+		Usage:          "/digest_off <subscription ID>",
+		Description:    "Publishes every movement of the given subscription again",
+		ParameterCount: 1,
+	},
 	"my_subscriptions": {
 		Endpoint:       "/my_subscriptions",
 		Usage:          "/my_subscriptions",
@@ -120,6 +132,8 @@ func (b Bot) registerCommands() {
 	b.tb.Handle(commands["subscribe"].Endpoint, b.subscribeForMovementCMD)
 	b.tb.Handle(commands["unsubscribe"].Endpoint, b.unsubscribeCMD)
 	b.tb.Handle(commands["unsubscribe_all"].Endpoint, b.unsubscribeAllCMD)
+	b.tb.Handle(commands["digest"].Endpoint, b.digestCMD)
+	b.tb.Handle(commands["digest_off"].Endpoint, b.digestOffCMD)
 	b.tb.Handle(commands["my_subscriptions"].Endpoint, b.mySubscriptionsCMD)
 	b.tb.Handle(commands["available_assets"].Endpoint, b.availableAssetsCMD)
 	b.tb.Handle(commands["available_commands"].Endpoint, b.availableCommandsCMD)
@@ -193,6 +207,38 @@ func (b Bot) unsubscribeAllCMD(m *tb.Message) {
 	}
 }
 
+func (b Bot) digestCMD(m *tb.Message) {
+	params, err := commands["digest"].ValidateParameters(m.Payload)
+	if err != nil {
+		b.tb.Send(m.Sender, err.Error(), tb.ModeMarkdown)
+		return
+	}
+
+	schedule := strings.Join(params[2:], parameterSeparator)
+	if err := b.subsApp.EnableDigest(params[0], schedule, params[1]); err != nil {
+		b.tb.Send(m.Sender, fmt.Sprintf("failed to enable digest, %s", err.Error()))
+		return
+	}
+
+	b.tb.Send(
+		m.Sender,
+		fmt.Sprintf("digest enabled for `%s` on schedule `%s` in `%s`", params[0], schedule, params[1]),
+		tb.ModeMarkdown,
+	)
+}
+
+func (b Bot) digestOffCMD(m *tb.Message) {
+	params, err := commands["digest_off"].ValidateParameters(m.Payload)
+	if err != nil {
+		b.tb.Send(m.Sender, err.Error(), tb.ModeMarkdown)
+		return
+	}
+
+	if err := b.subsApp.DisableDigest(params[0]); err != nil {
+		b.tb.Send(m.Sender, fmt.Sprintf("failed to disable digest, %s", err.Error()))
+	}
+}
+
 func (b Bot) mySubscriptionsCMD(m *tb.Message) {
 	subs, err := b.subsApp.GetSubscriptionsForUser(m.Sender.Recipient())
 	if err != nil {
@@ -214,7 +260,12 @@ func (b Bot) mySubscriptionsCMD(m *tb.Message) {
 }
 
 func (b Bot) availableAssetsCMD(m *tb.Message) {
-	s := "Avialable Assets\n\n\n```\n Bitcoin[btc], Ethereum[eth]```"
+	symbols := []string{}
+	for _, c := range b.subsApp.AvailableCurrencies() {
+		symbols = append(symbols, c.Symbol)
+	}
+
+	s := fmt.Sprintf("Avialable Assets\n\n\n```\n %s```", strings.Join(symbols, ", "))
 	b.tb.Send(m.Sender, s, tb.ModeMarkdown)
 }
 
@@ -25,6 +25,7 @@ type Config struct {
 		Name string `yaml:"name"`
 		URI  string `yaml:"uri"`
 	} `yaml:"database"`
+	Currencies []services.CurrencyConfig `yaml:"currencies"`
 }
 
 func main() {
@@ -33,6 +34,11 @@ func main() {
 		log.Fatal(err)
 	}
 
+	registry, err := services.NewCurrencyRegistry(c.Currencies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	subsRepo := services.RepositoryServiceFactory[c.Database.Type]
 	if subsRepo == nil {
 		panic(fmt.Errorf("there is no repository implementation for the given database type(%s)", c.Database.Type))
@@ -42,11 +48,11 @@ func main() {
 		panic(err)
 	}
 	defer subsRepo.Disconnect()
-	var subsAppService = application.NewSubscriptionApplication(subsRepo)
+	var subsAppService = application.NewSubscriptionApplication(subsRepo, registry)
 
 	b := NewBot(c, subsAppService)
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	// Check for interrupt and kill signals so that we stop observer gracefully
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
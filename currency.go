@@ -16,3 +16,28 @@ type Currency struct {
 	Symbol  string   `json:"symbol"`
 	Decimal *big.Int `json:"decimal"`
 }
+
+// MovementNotifier is an optional extension of CurrencyService for the services which
+// are able to push notifications about the addresses with new movements, so that
+// they can be observed without polling
+type MovementNotifier interface {
+	// WatchAddresses subscribes for the notifications of the given addresses
+	// in addition to the ones already watched
+	WatchAddresses(addresses []string) error
+	// Notifications returns the channel of the addresses with new movements
+	Notifications() <-chan string
+	// IsConnected reports whether or not the notifications are being received
+	IsConnected() bool
+}
+
+// BlockScanner is an optional extension of CurrencyService for the services which are
+// able to fetch all txs in a block, so that the movements of all watched addresses are
+// observed by walking each block once rather than polling every address
+type BlockScanner interface {
+	// NormalizeAddress returns the form of the given address which the addresses in blocks are matched in
+	NormalizeAddress(address string) (string, error)
+	// GetBlockMovements fetches txs in the block at the given height and converts them to the account
+	// movements of the addresses which are watched. Addresses are given to watched in their normalized
+	// form and the returned account movements are of the normalized addresses
+	GetBlockMovements(blockHeight uint64, watched func(address string) bool) ([]*AccountMovements, error)
+}
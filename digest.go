@@ -0,0 +1,167 @@
+package cryptobot
+
+import (
+	"math/big"
+	"sort"
+	"time"
+)
+
+// DigestLargestTransfersLimit is the maximum number of
+// the largest transfers to be included in a digest summary
+const DigestLargestTransfersLimit = 3
+
+// Digest is a value object which buffers the filtered transfers
+// of a subscription to publish them as a single summary on a schedule
+type Digest struct {
+	schedule   string
+	timeZone   string
+	lastSentAt time.Time
+	transfers  []*Transfer
+}
+
+// NewDigest creates a new instance of Digest with
+// the given cron-style schedule in the given time zone
+func NewDigest(schedule string, timeZone string, since time.Time) *Digest {
+	return &Digest{
+		schedule:   schedule,
+		timeZone:   timeZone,
+		lastSentAt: since,
+		transfers:  make([]*Transfer, 0),
+	}
+}
+
+// DeepCopyDigest creates a copy
+func DeepCopyDigest(schedule string, timeZone string, lastSentAt time.Time, transfers []*Transfer) *Digest {
+	d := NewDigest(schedule, timeZone, lastSentAt)
+	d.transfers = append(d.transfers, transfers...)
+
+	return d
+}
+
+// Schedule returns the cron-style schedule expression
+func (d *Digest) Schedule() string {
+	return d.schedule
+}
+
+// TimeZone returns the IANA time zone name which the schedule is evaluated in
+func (d *Digest) TimeZone() string {
+	return d.timeZone
+}
+
+// LastSentAt returns the time at when the last summary is published
+func (d *Digest) LastSentAt() time.Time {
+	return d.lastSentAt
+}
+
+// Transfers returns the buffered transfers since the last summary
+func (d *Digest) Transfers() []*Transfer {
+	return d.transfers
+}
+
+func (d *Digest) buffer(ts []*Transfer) {
+	d.transfers = append(d.transfers, ts...)
+}
+
+func (d *Digest) flush(now time.Time) *DigestSummary {
+	ds := &DigestSummary{
+		From:             d.lastSentAt,
+		To:               now,
+		TotalReceived:    new(big.Int),
+		TotalSpent:       new(big.Int),
+		LargestTransfers: make([]*Transfer, 0),
+	}
+
+	for _, t := range d.transfers {
+		switch t.Type {
+		case Received:
+			ds.ReceivedCount++
+			ds.TotalReceived.Add(ds.TotalReceived, t.Amount)
+		case Spent:
+			ds.SpentCount++
+			ds.TotalSpent.Add(ds.TotalSpent, t.Amount)
+		}
+	}
+
+	largest := append([]*Transfer{}, d.transfers...)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Amount.Cmp(largest[j].Amount) > 0
+	})
+	if len(largest) > DigestLargestTransfersLimit {
+		largest = largest[:DigestLargestTransfersLimit]
+	}
+	ds.LargestTransfers = largest
+
+	d.lastSentAt = now
+	d.transfers = make([]*Transfer, 0)
+
+	return ds
+}
+
+// DigestSummary represents the summary of
+// the transfers buffered in a digest period
+type DigestSummary struct {
+	From             time.Time
+	To               time.Time
+	ReceivedCount    int
+	SpentCount       int
+	TotalReceived    *big.Int
+	TotalSpent       *big.Int
+	LargestTransfers []*Transfer
+}
+
+// TransferCount returns the total number of transfers in the summary
+func (ds *DigestSummary) TransferCount() int {
+	return ds.ReceivedCount + ds.SpentCount
+}
+
+// AccountDigestReadyEvent represents a domain event upon a digest summary
+type AccountDigestReadyEvent struct {
+	version    int
+	occurredOn time.Time
+	subsID     string
+	account    string
+	c          Currency
+	summary    *DigestSummary
+}
+
+// NewAccountDigestReadyEvent creates a new instance from DigestSummary
+func NewAccountDigestReadyEvent(subsID string, account string, c Currency, summary *DigestSummary) *AccountDigestReadyEvent {
+	return &AccountDigestReadyEvent{
+		version:    1,
+		occurredOn: time.Now(),
+		subsID:     subsID,
+		account:    account,
+		c:          c,
+		summary:    summary,
+	}
+}
+
+// Account returns Account property
+func (evt *AccountDigestReadyEvent) Account() string {
+	return evt.account
+}
+
+// Currency returns the currency property
+func (evt *AccountDigestReadyEvent) Currency() Currency {
+	return evt.c
+}
+
+// SubscriptionID returns the subsID property
+func (evt *AccountDigestReadyEvent) SubscriptionID() string {
+	return evt.subsID
+}
+
+// Summary returns summary property
+func (evt *AccountDigestReadyEvent) Summary() *DigestSummary {
+	return evt.summary
+}
+
+// OccurredOn returns event time
+func (evt *AccountDigestReadyEvent) OccurredOn() time.Time {
+	return evt.occurredOn
+}
+
+// EventVersion returns event version
+func (evt *AccountDigestReadyEvent) EventVersion() int {
+	return evt.version
+}
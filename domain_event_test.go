@@ -12,6 +12,7 @@ type MockEventSubscriber struct {
 	numOfHandledEvents int
 	eventHandled       bool
 	eventType          reflect.Type
+	lastEvent          interface{}
 }
 
 func NewMockEventSubscriber(eventType reflect.Type) *MockEventSubscriber {
@@ -24,6 +25,7 @@ func NewMockEventSubscriber(eventType reflect.Type) *MockEventSubscriber {
 func (mes *MockEventSubscriber) HandleEvent(e interface{}) {
 	mes.eventHandled = true
 	mes.numOfHandledEvents++
+	mes.lastEvent = e
 }
 
 func (mes *MockEventSubscriber) SubscribedToEventType() reflect.Type {
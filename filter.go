@@ -59,6 +59,21 @@ func NewFilter(t FilterType, c condition, must bool) *Filter {
 	}
 }
 
+// DeepCopyFilter creates a copy which shares no condition with the given filter
+func DeepCopyFilter(f *Filter) *Filter {
+	var c condition
+	switch fc := f.c.(type) {
+	case *amountCondition:
+		c = &amountCondition{Amount: new(big.Int).Set(fc.Amount)}
+	case *addressOnCondition:
+		c = &addressOnCondition{Address: fc.Address}
+	case *addressOffCondition:
+		c = &addressOffCondition{Address: fc.Address}
+	}
+
+	return NewFilter(f.t, c, f.isMust)
+}
+
 // CheckCondition checks whether or not the given conditions satisfy this filter
 func (f *Filter) CheckCondition(t *Transfer) bool {
 	return f.c.CheckAgainst(t)
@@ -206,3 +206,24 @@ func TestDeserializeCondition_WithErrornousCondition(t *testing.T) {
 		t.Fatalf("expected an error but got nothing")
 	}
 }
+
+func TestDeepCopyFilter(t *testing.T) {
+	f, err := domain.NewAmountFilter("5", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := domain.DeepCopyFilter(f)
+	if c == f || c.Type() != f.Type() || c.IsMust() != f.IsMust() {
+		t.Fatalf("expected a copy of %s but got %s", f.ToString(), c.ToString())
+	}
+
+	d, err := c.SerializeCondition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(d) != "{\"amount\":5}" {
+		t.Fatalf("expected \"%s\" but got \"%s\"", "{\"amount\":5}", string(d))
+	}
+}
@@ -6,9 +6,11 @@ require (
 	github.com/aws/aws-sdk-go v1.34.28 // indirect
 	github.com/gobuffalo/genny v0.1.1 // indirect
 	github.com/gobuffalo/gogen v0.1.1 // indirect
-	github.com/google/uuid v1.1.2
+	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/karrick/godirwalk v1.10.3 // indirect
+	github.com/lib/pq v1.9.0
 	github.com/pelletier/go-toml v1.7.0 // indirect
 	github.com/sirupsen/logrus v1.4.2 // indirect
 	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c // indirect
@@ -16,4 +18,5 @@ require (
 	go.mongodb.org/mongo-driver v1.8.1
 	gopkg.in/tucnak/telebot.v2 v2.0.0-20200120165535-b6c3367fed99
 	gopkg.in/yaml.v2 v2.2.8
+	modernc.org/sqlite v1.14.2
 )
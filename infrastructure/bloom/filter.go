@@ -0,0 +1,67 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a Bloom filter, a probabilistic set which tells that an item is definitely
+// not in the set or that it might be in the set with the configured false positive rate
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// NewFilter creates a new instance of Filter sized for the given
+// number of items and the given false positive rate
+func NewFilter(n int, falsePositiveRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add adds the given item to the set
+func (f *Filter) Add(item string) {
+	h1, h2 := hash(item)
+	for i := uint64(0); i < f.k; i++ {
+		b := (h1 + i*h2) % f.m
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+// Test reports whether or not the given item might be in the set
+func (f *Filter) Test(item string) bool {
+	h1, h2 := hash(item)
+	for i := uint64(0); i < f.k; i++ {
+		b := (h1 + i*h2) % f.m
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Two hashes derived from a single 64-bit FNV-1a hash to
+// simulate k hash functions through double hashing
+func hash(item string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
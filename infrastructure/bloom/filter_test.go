@@ -0,0 +1,34 @@
+package bloom_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/bloom"
+)
+
+func TestFilter(t *testing.T) {
+	n := 10000
+	f := bloom.NewFilter(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("added-%d", i))
+	}
+
+	for i := 0; i < n; i++ {
+		if !f.Test(fmt.Sprintf("added-%d", i)) {
+			t.Fatalf("expected added-%d to be in the set", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.Test(fmt.Sprintf("absent-%d", i)) {
+			falsePositives++
+		}
+	}
+
+	// Some margin over the configured rate of 1%
+	if rate := float64(falsePositives) / float64(n); rate > 0.02 {
+		t.Fatalf("expected false positive rate around 1%% but got %.2f%%", rate*100)
+	}
+}
@@ -1,8 +1,10 @@
 package concurrency
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"reflect"
 	"runtime"
 	"time"
@@ -12,6 +14,32 @@ import (
 type Retrial struct {
 	Limit int           // Max. number of retrial. If it's set to -1, it will run indefinitely
 	Delay time.Duration // Delay between each recursion
+	// Multiplier grows the delay after every retrial exponentially.
+	// The delay is fixed if it's less than or equal to 1
+	Multiplier float64
+	// MaxDelay caps the delay when it grows. No cap if it's 0
+	MaxDelay time.Duration
+	// Jitter randomizes the delay by the given fraction, e.g. 0.2 for ±20%
+	Jitter float64
+}
+
+// PermanentError stops the retrial immediately
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps the given error so that it's not retried
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
 }
 
 // Retry calls the given function recursively with the given retrial paramters
@@ -26,7 +54,7 @@ func Retry(r Retrial, f func() (interface{}, error)) (interface{}, error) {
 			i--
 			if i >= 0 || r.Limit == -1 {
 				log.Printf("retrying(%d) to call function \"%s()\"\n", r.Limit-i, funcName)
-				time.Sleep(r.Delay)
+				time.Sleep(r.Backoff(r.Limit - i))
 				continue
 			}
 			break
@@ -37,3 +65,51 @@ func Retry(r Retrial, f func() (interface{}, error)) (interface{}, error) {
 
 	return nil, fmt.Errorf("retry has reached to limit: %d", r.Limit)
 }
+
+// RetryContext calls the given function until it succeeds, returns a permanent error, the retrial
+// limit is reached or the context is done. Returns the last error of the function if it fails
+func RetryContext(ctx context.Context, r Retrial, f func() error) error {
+	for retrial := 1; ; retrial++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		if p, ok := err.(*PermanentError); ok {
+			return p.Err
+		}
+
+		if r.Limit != -1 && retrial > r.Limit {
+			return err
+		}
+
+		t := time.NewTimer(r.Backoff(retrial))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%s, %w", err.Error(), ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// Backoff returns the delay before the given retrial, starting from 1
+func (r Retrial) Backoff(retrial int) time.Duration {
+	d := float64(r.Delay)
+	for i := 1; i < retrial && r.Multiplier > 1; i++ {
+		d *= r.Multiplier
+		if r.MaxDelay > 0 && d > float64(r.MaxDelay) {
+			break
+		}
+	}
+
+	if r.MaxDelay > 0 && d > float64(r.MaxDelay) {
+		d = float64(r.MaxDelay)
+	}
+
+	if r.Jitter > 0 {
+		d += d * r.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
@@ -1,6 +1,7 @@
 package concurrency_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
@@ -80,3 +81,66 @@ func succedingFuncAfterTrial(a string, trial *int, maxTrials int) (string, error
 func cleanUp(filename string) error {
 	return nil
 }
+
+func TestRetryContext_PermanentError(t *testing.T) {
+	trials := 0
+	err := concurrency.RetryContext(context.Background(), r, func() error {
+		trials++
+		return concurrency.Permanent(errTest)
+	})
+
+	if err != errTest {
+		t.Fatalf("\nerror: %s\nwant: %s\n", err, errTest)
+	}
+
+	if trials != 1 {
+		t.Fatalf("\nnumber of trials: %d\nwant: %d\n", trials, 1)
+	}
+}
+
+func TestRetryContext_Limit(t *testing.T) {
+	trials := 0
+	err := concurrency.RetryContext(context.Background(), concurrency.Retrial{Limit: 2, Delay: time.Millisecond}, func() error {
+		trials++
+		return errTest
+	})
+
+	if err != errTest {
+		t.Fatalf("\nerror: %s\nwant: %s\n", err, errTest)
+	}
+
+	if trials != 3 {
+		t.Fatalf("\nnumber of trials: %d\nwant: %d\n", trials, 3)
+	}
+}
+
+func TestRetryContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := concurrency.RetryContext(ctx, concurrency.Retrial{Limit: -1, Delay: time.Second}, func() error {
+		return errTest
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("\nerror: %s\nwant: %s\n", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := concurrency.Retrial{Delay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: time.Second}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+
+	for i, e := range expected {
+		if d := b.Backoff(i + 1); d != e {
+			t.Fatalf("\ndelay of retrial#%d: %s\nwant: %s\n", i+1, d, e)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Backoff(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("\ndelay with jitter: %s\nwant: between 50ms and 150ms\n", d)
+		}
+	}
+}
@@ -1,30 +1,373 @@
 package net
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/concurrency"
+)
+
+// UserAgent is sent with every request
+const UserAgent = "crypto-balance-bot"
+
+// Default options of HTTPClient
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultRateLimit        = 5 // Requests per second
+	defaultBurst            = 1
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
 )
 
-// GetJSON makes a HTTP GET call and expects a JSON response
-func GetJSON(url string, v interface{}) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// Max. number of bytes of an error response kept in HTTPError
+const maxErrorBodySize = 512
+
+// ErrCircuitOpen is returned without making a request while the host keeps failing
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// HTTPError is returned upon an error status code
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	URL        string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s from %s", e.Status, e.URL)
+}
+
+// Temporary reports whether or not the request might succeed when retried
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// ClientOptions represents configurables for HTTPClient
+type ClientOptions struct {
+	// Timeout of a single attempt
+	Timeout time.Duration
+	// Max. number of requests per second to a host and the burst of the
+	// token bucket. They are shared by all the clients requesting the same
+	// host and set by the first one of them
+	RateLimit float64
+	Burst     int
+	// Retrial of the failing requests. Only the network errors, 429 and
+	// 5xx status codes are retried. Defaults to 3 retrials with backoff
+	Retrial *concurrency.Retrial
+	// Number of consecutive failures to a host after which the requests fail fast
+	// with ErrCircuitOpen during the cool-down. Shared by all the clients like the rate limit
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Headers sent with every request, e.g. the API key of the provider
+	Headers map[string]string
+	// Transport makes the requests. Defaults to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+var defaultRetrial = concurrency.Retrial{
+	Limit:      3,
+	Delay:      500 * time.Millisecond,
+	Multiplier: 2,
+	MaxDelay:   10 * time.Second,
+	Jitter:     0.2,
+}
+
+// HTTPClient makes HTTP requests with per-host rate limits, retries and circuit breakers
+type HTTPClient struct {
+	client           *http.Client
+	timeout          time.Duration
+	rateLimit        float64
+	burst            int
+	retrial          concurrency.Retrial
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	headers          map[string]string
+}
+
+// Rate limiters and circuit breakers are shared by all clients per host
+var (
+	hostsMu sync.Mutex
+	hosts   = make(map[string]*host)
+)
+
+type host struct {
+	l *rateLimiter
+	b *circuitBreaker
+}
+
+// NewHTTPClient creates a new instance of HTTPClient
+func NewHTTPClient(opts ...*ClientOptions) *HTTPClient {
+	c := &HTTPClient{
+		client:           &http.Client{},
+		timeout:          defaultTimeout,
+		rateLimit:        defaultRateLimit,
+		burst:            defaultBurst,
+		retrial:          defaultRetrial,
+		breakerThreshold: defaultBreakerThreshold,
+		breakerCooldown:  defaultBreakerCooldown,
+		headers:          make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Timeout != 0 {
+			c.timeout = opt.Timeout
+		}
+		if opt.RateLimit != 0 {
+			c.rateLimit = opt.RateLimit
+		}
+		if opt.Burst != 0 {
+			c.burst = opt.Burst
+		}
+		if opt.Retrial != nil {
+			c.retrial = *opt.Retrial
+		}
+		if opt.BreakerThreshold != 0 {
+			c.breakerThreshold = opt.BreakerThreshold
+		}
+		if opt.BreakerCooldown != 0 {
+			c.breakerCooldown = opt.BreakerCooldown
+		}
+		for k, v := range opt.Headers {
+			c.headers[k] = v
+		}
+		if opt.Transport != nil {
+			c.client.Transport = opt.Transport
+		}
+	}
+
+	return c
+}
+
+// GetJSON makes a HTTP GET request and decodes the JSON response into v
+func (c *HTTPClient) GetJSON(ctx context.Context, rawURL string, v interface{}) error {
+	u, err := url.Parse(rawURL)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Accept", `text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8`)
-	req.Header.Add("User-Agent", `Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:95.0) Gecko/20100101 Firefox/95.0`)
-	resp, err := client.Do(req)
+	h := c.host(u.Host)
+
+	return concurrency.RetryContext(ctx, c.retrial, func() error {
+		if !h.b.allow() {
+			return concurrency.Permanent(fmt.Errorf("%w for %s", ErrCircuitOpen, u.Host))
+		}
+
+		if err := h.l.wait(ctx); err != nil {
+			h.b.abort()
+			return concurrency.Permanent(err)
+		}
+
+		err := c.getJSON(ctx, rawURL, v)
+		if err == nil {
+			h.b.success()
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			h.b.abort()
+			return concurrency.Permanent(err)
+		}
+
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && !httpErr.Temporary() {
+			// The host is up, it's the request which is wrong
+			h.b.success()
+			return concurrency.Permanent(err)
+		}
+
+		h.b.failure()
+		log.Printf("request to %s failed, %s", u.Host, err.Error())
+
+		return err
+	})
+}
+
+func (c *HTTPClient) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return concurrency.Permanent(err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return errors.New(resp.Status)
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		// Drain the rest so that the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return &HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			URL:        redact(req.URL),
+			Body:       string(body),
+		}
 	}
 
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(v)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return concurrency.Permanent(err)
+	}
+
+	return nil
+}
+
+func (c *HTTPClient) host(name string) *host {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+
+	h, exist := hosts[name]
+	if !exist {
+		h = &host{
+			l: newRateLimiter(c.rateLimit, c.burst),
+			b: newCircuitBreaker(c.breakerThreshold, c.breakerCooldown),
+		}
+		hosts[name] = h
+	}
+
+	return h
+}
+
+// Removes the query from the url not to leak secrets such as api keys into the errors
+func redact(u *url.URL) string {
+	r := *u
+	if r.RawQuery != "" {
+		r.RawQuery = ""
+		return r.String() + "?..."
+	}
+	return r.String()
+}
+
+// rateLimiter is a token bucket refilled at the rate of requests per second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or the context is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Tokens are taken in advance, so the concurrent callers wait in turn
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// circuitBreaker opens after the given number of consecutive failures and lets
+// a single request through after the cool-down to probe whether the host recovered
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// abort releases the probe of a request which is cancelled by the caller
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
 }
@@ -0,0 +1,159 @@
+package net_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/concurrency"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+)
+
+var fastRetrial = &concurrency.Retrial{Limit: 3, Delay: time.Millisecond}
+
+func TestGetJSON(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != net.UserAgent || r.Header.Get("X-API-Key") != "secret" {
+			http.Error(w, "unexpected headers", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"height": 100}`))
+	}))
+	defer s.Close()
+
+	c := net.NewHTTPClient(&net.ClientOptions{Headers: map[string]string{"X-API-Key": "secret"}})
+	v := struct {
+		Height uint64 `json:"height"`
+	}{}
+	if err := c.GetJSON(context.Background(), s.URL, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Height != 100 {
+		t.Fatalf("expected height is 100 but got %d", v.Height)
+	}
+}
+
+func TestGetJSON_Retry(t *testing.T) {
+	calls := int32(0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer s.Close()
+
+	c := net.NewHTTPClient(&net.ClientOptions{Retrial: fastRetrial, RateLimit: 1000})
+	if err := c.GetJSON(context.Background(), s.URL, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("expected 3 calls but got %d", calls)
+	}
+}
+
+func TestGetJSON_ClientError(t *testing.T) {
+	calls := int32(0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "no such address", http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	c := net.NewHTTPClient(&net.ClientOptions{Retrial: fastRetrial, RateLimit: 1000})
+	err := c.GetJSON(context.Background(), s.URL+"?apikey=secret", &struct{}{})
+
+	var httpErr *net.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a bad request error but got %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected client errors not to be retried but got %d calls", calls)
+	}
+
+	if httpErr.Body != "no such address\n" || httpErr.URL != s.URL+"?..." {
+		t.Fatalf("unexpected error details %+v", httpErr)
+	}
+}
+
+func TestGetJSON_CircuitBreaker(t *testing.T) {
+	calls := int32(0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	c := net.NewHTTPClient(&net.ClientOptions{
+		Retrial:          &concurrency.Retrial{Limit: 0},
+		RateLimit:        1000,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := c.GetJSON(context.Background(), s.URL, &struct{}{}); err == nil {
+			t.Fatal("expected an error but got nothing")
+		}
+	}
+
+	if err := c.GetJSON(context.Background(), s.URL, &struct{}{}); !errors.Is(err, net.ErrCircuitOpen) {
+		t.Fatalf("expected circuit to be open but got %v", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected no call while the circuit is open but got %d calls", calls)
+	}
+
+	// A probe is allowed after the cool-down
+	time.Sleep(60 * time.Millisecond)
+	c.GetJSON(context.Background(), s.URL, &struct{}{})
+	if calls != 3 {
+		t.Fatalf("expected a probe after the cool-down but got %d calls", calls)
+	}
+}
+
+func TestGetJSON_RateLimit(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer s.Close()
+
+	c := net.NewHTTPClient(&net.ClientOptions{RateLimit: 20})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := c.GetJSON(context.Background(), s.URL, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first request is immediate, the rest wait 50ms each
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected requests to be rate limited but took %s", elapsed)
+	}
+}
+
+func TestGetJSON_Cancel(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	c := net.NewHTTPClient(&net.ClientOptions{Retrial: fastRetrial})
+	if err := c.GetJSON(ctx, s.URL, &struct{}{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to be cancelled but got %v", err)
+	}
+}
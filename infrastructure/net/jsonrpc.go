@@ -0,0 +1,109 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Timeout for a JSON-RPC call
+const rpcTimeout = 30 * time.Second
+
+// RPCError is an error object returning from a JSON-RPC server
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error(%d), %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// JSONRPCClient makes JSON-RPC calls over HTTP with optional basic authentication
+type JSONRPCClient struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+	id       uint64
+}
+
+// NewJSONRPCClient creates a new instance of JSONRPCClient.
+// Basic authentication is used only if username is not empty
+func NewJSONRPCClient(url string, username string, password string) *JSONRPCClient {
+	return &JSONRPCClient{
+		url:      url,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: rpcTimeout},
+	}
+}
+
+// Call invokes the given method with the given params and decodes the result into v.
+// Errors returning from the server are of type *RPCError
+func (c *JSONRPCClient) Call(v interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	body, err := json.Marshal(&rpcRequest{
+		JSONRPC: "2.0",
+		ID:      atomic.AddUint64(&c.id, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	r := &rpcResponse{}
+	// Some servers, e.g. bitcoind, reply RPC errors with an error status code
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s calling %s", resp.Status, method)
+		}
+		return err
+	}
+
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(r.Result, v)
+}
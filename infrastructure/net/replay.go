@@ -0,0 +1,197 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RecordFixturesEnv is the environment variable which switches ReplayTransport
+// to record mode when it's set to 1
+const RecordFixturesEnv = "RECORD_FIXTURES"
+
+// Query parameters which are never recorded nor matched, not to leak secrets into the fixtures
+var secretParams = []string{"apikey", "api_key", "key"}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	StatusCode  int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	// JSON is the body if it's a valid JSON, otherwise Text is the body
+	JSON json.RawMessage `json:"json,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// Fixture is the content of a fixture file
+type Fixture struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// ReplayTransport is a http.RoundTripper which replays the responses recorded in a fixture
+// file, so that the tests of the provider adapters run without network. In record mode,
+// it makes the requests through http.DefaultTransport and records the responses instead
+type ReplayTransport struct {
+	path      string
+	recording bool
+	ignored   []string
+
+	mu      sync.Mutex
+	fixture *Fixture
+	used    map[*Interaction]bool
+}
+
+// NewReplayTransport creates a new instance of ReplayTransport for the given fixture file. Requests
+// are matched by their method and url without the given query parameters, e.g. the volatile ones
+// such as timestamps. It's in record mode if RecordFixturesEnv is set, otherwise the fixture must exist
+func NewReplayTransport(path string, ignoredParams ...string) (*ReplayTransport, error) {
+	t := &ReplayTransport{
+		path:      path,
+		recording: os.Getenv(RecordFixturesEnv) == "1",
+		ignored:   append(append([]string{}, ignoredParams...), secretParams...),
+		fixture:   &Fixture{},
+		used:      make(map[*Interaction]bool),
+	}
+
+	if t.recording {
+		return t, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixture, run with %s=1 to record it, %s", RecordFixturesEnv, err.Error())
+	}
+
+	if err := json.Unmarshal(data, t.fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture(%s), %s", path, err.Error())
+	}
+
+	return t, nil
+}
+
+// RoundTrip replays the response of the given request or records it in record mode
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.key(req.URL)
+
+	if t.recording {
+		return t.record(req, key)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The same requests are replayed in the recorded order
+	// and the last one is repeated once all are replayed
+	var last *Interaction
+	for _, i := range t.fixture.Interactions {
+		if i.Method != req.Method || i.URL != key {
+			continue
+		}
+		if !t.used[i] {
+			t.used[i] = true
+			return i.response(req), nil
+		}
+		last = i
+	}
+
+	if last != nil {
+		return last.response(req), nil
+	}
+
+	return nil, fmt.Errorf("no recorded response for %s %s in %s", req.Method, key, t.path)
+}
+
+// Save writes the recorded interactions into the fixture file in record mode
+func (t *ReplayTransport) Save() error {
+	if !t.recording {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.MarshalIndent(t.fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(t.path, append(data, '\n'), 0644)
+}
+
+func (t *ReplayTransport) record(req *http.Request, key string) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Interaction{
+		Method:      req.Method,
+		URL:         key,
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if json.Valid(body) {
+		i.JSON = body
+	} else {
+		i.Text = string(body)
+	}
+
+	t.mu.Lock()
+	t.fixture.Interactions = append(t.fixture.Interactions, i)
+	t.mu.Unlock()
+
+	return i.response(req), nil
+}
+
+// Returns the given url without the ignored query parameters
+func (t *ReplayTransport) key(u *url.URL) string {
+	k := *u
+	query := k.Query()
+	for _, p := range t.ignored {
+		query.Del(p)
+	}
+	k.RawQuery = query.Encode()
+
+	return k.String()
+}
+
+func (i *Interaction) response(req *http.Request) *http.Response {
+	body := []byte(i.Text)
+	if len(i.JSON) > 0 {
+		body = i.JSON
+	}
+
+	header := make(http.Header)
+	if i.ContentType != "" {
+		header.Set("Content-Type", i.ContentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,79 @@
+package net_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+)
+
+func TestReplayTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"height": 100}`))
+	}))
+
+	// Record the response from the live server
+	os.Setenv(net.RecordFixturesEnv, "1")
+	rec, err := net.NewReplayTransport(path, "timestamp")
+	os.Unsetenv(net.RecordFixturesEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := net.NewHTTPClient(&net.ClientOptions{Transport: rec, RateLimit: 1000})
+	if err := c.GetJSON(context.Background(), s.URL+"/status?timestamp=1&apikey=secret", &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "secret") {
+		t.Fatal("expected the api key not to be recorded")
+	}
+
+	// Replay without the server, with another value of the ignored parameter
+	rep, err := net.NewReplayTransport(path, "timestamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = net.NewHTTPClient(&net.ClientOptions{Transport: rep, RateLimit: 1000})
+	v := struct {
+		Height uint64 `json:"height"`
+	}{}
+	if err := c.GetJSON(context.Background(), s.URL+"/status?timestamp=2", &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Height != 100 {
+		t.Fatalf("expected height is 100 but got %d", v.Height)
+	}
+}
+
+func TestReplayTransport_Missing(t *testing.T) {
+	if _, err := net.NewReplayTransport(filepath.Join("testdata", "missing.json")); err == nil {
+		t.Fatal("expected an error for a missing fixture but got nothing")
+	}
+}
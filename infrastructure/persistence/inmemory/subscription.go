@@ -2,6 +2,8 @@ package inmemory
 
 import (
 	"errors"
+	"math/big"
+	"sync"
 
 	"github.com/google/uuid"
 	domain "github.com/psychoplasma/crypto-balance-bot"
@@ -9,32 +11,89 @@ import (
 
 var errIndifferentUserID = errors.New("updating UserID field of an existing subscription is not allowed")
 
-// SubscriptionRepository is an in-memory implementation of SubscriptionRepository
+// SubscriptionRepository is an in-memory implementation of SubscriptionRepository which is safe
+// for concurrent use. Units of work are done one after another, so nothing but the repository
+// should be waited for in a unit of work, and a failed one is rolled back to the snapshot taken
+// when it began. Subscriptions are stored and returned as copies, so the stored ones are only
+// modified through Save
 type SubscriptionRepository struct {
+	uowMutex *sync.Mutex
+	mutex    *sync.RWMutex
+	state    *state
+	// State when the unit of work in progress began, nil outside of a unit of work
+	snapshot *state
+	// Subscriptions saved in the unit of work in progress
+	saved []*domain.Subscription
+}
+
+// state is never modified while it is the snapshot of a unit of work.
+// The first write in a unit of work copies it, i.e. copy-on-write
+type state struct {
 	subsByUserID map[string]map[string]*domain.Subscription
 	subsByID     map[string]*domain.Subscription
-	size         int
+	scanCursors  map[string]uint64
 }
 
 // NewSubscriptionRepository creates a new instance of SubscriptionRepository
 func NewSubscriptionRepository() *SubscriptionRepository {
 	return &SubscriptionRepository{
-		subsByUserID: make(map[string]map[string]*domain.Subscription),
-		subsByID:     make(map[string]*domain.Subscription),
-		size:         0,
+		uowMutex: new(sync.Mutex),
+		mutex:    new(sync.RWMutex),
+		state: &state{
+			subsByUserID: make(map[string]map[string]*domain.Subscription),
+			subsByID:     make(map[string]*domain.Subscription),
+			scanCursors:  make(map[string]uint64),
+		},
 	}
 }
 
+// Connect does nothing since the subscriptions are kept in memory
+func (r *SubscriptionRepository) Connect(uri string, databaseName string) error {
+	return nil
+}
+
+// Disconnect does nothing since the subscriptions are kept in memory
+func (r *SubscriptionRepository) Disconnect() error {
+	return nil
+}
+
 // Begin starts a new unit for a work to be done on repository
 func (r *SubscriptionRepository) Begin() error {
+	r.uowMutex.Lock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.snapshot = r.state
+
 	return nil
 }
 
 // Fail rollbacks repository to the state before this work
-func (r *SubscriptionRepository) Fail() {}
+func (r *SubscriptionRepository) Fail() {
+	defer r.uowMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state = r.snapshot
+	r.snapshot = nil
+	r.saved = nil
+}
 
 // Success finalizes the work done on repository
-func (r *SubscriptionRepository) Success() {}
+func (r *SubscriptionRepository) Success() error {
+	defer r.uowMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.snapshot = nil
+
+	for _, s := range r.saved {
+		s.IncrementVersion()
+	}
+	r.saved = nil
+
+	return nil
+}
 
 // NextIdentity returns the next available identity
 func (r *SubscriptionRepository) NextIdentity(userID string) string {
@@ -43,29 +102,74 @@ func (r *SubscriptionRepository) NextIdentity(userID string) string {
 
 // Size returns the total number of subscriptions persited in the repository
 func (r *SubscriptionRepository) Size() int64 {
-	return int64(r.size)
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return int64(len(r.state.subsByID))
 }
 
 // Get returns the subscription for the given subscription id
 func (r *SubscriptionRepository) Get(id string) (*domain.Subscription, error) {
-	return r.subsByID[id], nil
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s := r.state.subsByID[id]
+	if s == nil {
+		return nil, nil
+	}
+
+	return deepCopy(s), nil
 }
 
 // GetAllForUser returns all subscriptions for the given user id
 func (r *SubscriptionRepository) GetAllForUser(userID string) ([]*domain.Subscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	subs := make([]*domain.Subscription, 0)
-	for k := range r.subsByUserID[userID] {
-		subs = append(subs, r.subsByUserID[userID][k])
+	for _, s := range r.state.subsByUserID[userID] {
+		subs = append(subs, deepCopy(s))
 	}
 	return subs, nil
 }
 
 // GetAllForCurrency returns all subscriptions for the given currency
 func (r *SubscriptionRepository) GetAllForCurrency(currencySymbol string, updatedBefore uint64) ([]*domain.Subscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	subs := make([]*domain.Subscription, 0)
-	for _, s := range r.subsByID {
+	for _, s := range r.state.subsByID {
 		if s.Currency().Symbol == currencySymbol && s.BlockHeight() < updatedBefore {
-			subs = append(subs, s)
+			subs = append(subs, deepCopy(s))
+		}
+	}
+	return subs, nil
+}
+
+// GetAllForAccount returns all subscriptions for the given account of the given currency
+func (r *SubscriptionRepository) GetAllForAccount(currencySymbol string, account string) ([]*domain.Subscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	subs := make([]*domain.Subscription, 0)
+	for _, s := range r.state.subsByID {
+		if s.Currency().Symbol == currencySymbol && s.Account() == account {
+			subs = append(subs, deepCopy(s))
+		}
+	}
+	return subs, nil
+}
+
+// GetAllWithDigest returns all subscriptions for the given currency which are in digest mode
+func (r *SubscriptionRepository) GetAllWithDigest(currencySymbol string) ([]*domain.Subscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	subs := make([]*domain.Subscription, 0)
+	for _, s := range r.state.subsByID {
+		if s.Currency().Symbol == currencySymbol && s.Digest() != nil {
+			subs = append(subs, deepCopy(s))
 		}
 	}
 	return subs, nil
@@ -73,36 +177,157 @@ func (r *SubscriptionRepository) GetAllForCurrency(currencySymbol string, update
 
 // Save persists/updates the given subscription
 func (r *SubscriptionRepository) Save(s *domain.Subscription) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	existing := r.state.subsByID[s.ID()]
+
 	// Do not allow to update UserID of an existing subscription
-	if r.subsByID[s.ID()] != nil && r.subsByID[s.ID()].UserID() != s.UserID() {
+	if existing != nil && existing.UserID() != s.UserID() {
 		return errIndifferentUserID
 	}
 
-	// Increment the size if the item doesn't exit upon persistance
-	if r.subsByID[s.ID()] == nil {
-		// We're caching the size because everytime calling
-		// len() would have an overhead for a non-local slice
-		r.size++
+	// Do not allow to overwrite a subscription modified or removed since it was loaded
+	version := uint64(0)
+	if existing != nil {
+		version = existing.Version()
+	}
+	if s.Version() != version {
+		return domain.ErrConcurrentModification
+	}
+
+	stored := deepCopy(s)
+	stored.IncrementVersion()
+
+	st := r.writable()
+	st.subsByID[s.ID()] = stored
+	if st.subsByUserID[s.UserID()] == nil {
+		st.subsByUserID[s.UserID()] = make(map[string]*domain.Subscription)
 	}
-	r.subsByID[s.ID()] = s
+	st.subsByUserID[s.UserID()][s.ID()] = stored
 
-	if r.subsByUserID[s.UserID()] == nil {
-		r.subsByUserID[s.UserID()] = make(map[string]*domain.Subscription)
+	// Its version is incremented once the unit of work succeeds
+	if r.snapshot != nil {
+		r.saved = append(r.saved, s)
+		return nil
 	}
-	r.subsByUserID[s.UserID()][s.ID()] = s
+	s.IncrementVersion()
 
 	return nil
 }
 
 // Remove removes the given subscription from the persistance
 func (r *SubscriptionRepository) Remove(s *domain.Subscription) error {
-	// Decrement the size if the item exists upon removal
-	if r.subsByID[s.ID()] != nil {
-		r.size--
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.state.subsByID[s.ID()] == nil {
+		return nil
 	}
 
-	delete(r.subsByID, s.ID())
-	delete(r.subsByUserID[s.UserID()], s.ID())
+	st := r.writable()
+	delete(st.subsByID, s.ID())
+	delete(st.subsByUserID[s.UserID()], s.ID())
+
+	return nil
+}
+
+// GetScanCursor returns the height of the last scanned block for the given currency
+func (r *SubscriptionRepository) GetScanCursor(currencySymbol string) (uint64, bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 
+	bh, exist := r.state.scanCursors[currencySymbol]
+	return bh, exist, nil
+}
+
+// SaveScanCursor persists the height of the last scanned block for the given currency
+func (r *SubscriptionRepository) SaveScanCursor(currencySymbol string, blockHeight uint64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.writable().scanCursors[currencySymbol] = blockHeight
 	return nil
 }
+
+// Returns the state to be written, copying it first if it is the snapshot of the
+// unit of work in progress. Must be called while holding the write lock
+func (r *SubscriptionRepository) writable() *state {
+	if r.snapshot != nil && r.state == r.snapshot {
+		r.state = r.state.copy()
+	}
+	return r.state
+}
+
+// Copies the indexes. The subscriptions are shared since the stored ones are never modified
+func (st *state) copy() *state {
+	c := &state{
+		subsByUserID: make(map[string]map[string]*domain.Subscription, len(st.subsByUserID)),
+		subsByID:     make(map[string]*domain.Subscription, len(st.subsByID)),
+		scanCursors:  make(map[string]uint64, len(st.scanCursors)),
+	}
+
+	for userID, subs := range st.subsByUserID {
+		c.subsByUserID[userID] = make(map[string]*domain.Subscription, len(subs))
+		for id, s := range subs {
+			c.subsByUserID[userID][id] = s
+		}
+	}
+
+	for id, s := range st.subsByID {
+		c.subsByID[id] = s
+	}
+
+	for symbol, bh := range st.scanCursors {
+		c.scanCursors[symbol] = bh
+	}
+
+	return c
+}
+
+// Returns a copy of the given subscription which shares nothing modifiable with it
+func deepCopy(s *domain.Subscription) *domain.Subscription {
+	var digest *domain.Digest
+	if d := s.Digest(); d != nil {
+		transfers := make([]*domain.Transfer, 0, len(d.Transfers()))
+		for _, t := range d.Transfers() {
+			c := *t
+			c.Amount = copyBigInt(t.Amount)
+			transfers = append(transfers, &c)
+		}
+
+		digest = domain.DeepCopyDigest(d.Schedule(), d.TimeZone(), d.LastSentAt(), transfers)
+	}
+
+	filters := make([]*domain.Filter, 0, len(s.Filters()))
+	for _, f := range s.Filters() {
+		filters = append(filters, domain.DeepCopyFilter(f))
+	}
+
+	c, _ := domain.DeepCopySubscription(
+		s.ID(),
+		s.UserID(),
+		s.Account(),
+		domain.Currency{
+			Symbol:  s.Currency().Symbol,
+			Decimal: copyBigInt(s.Currency().Decimal),
+		},
+		filters,
+		copyBigInt(s.TotalReceived()),
+		copyBigInt(s.TotalSpent()),
+		s.BlockHeight(),
+		s.StartingBlockHeight(),
+		digest,
+		s.AppliedTransfers(),
+		s.Version(),
+	)
+
+	return c
+}
+
+func copyBigInt(i *big.Int) *big.Int {
+	if i == nil {
+		return nil
+	}
+	return new(big.Int).Set(i)
+}
@@ -1,8 +1,11 @@
 package inmemory_test
 
 import (
+	"math/big"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/persistence/inmemory"
@@ -80,6 +83,39 @@ func TestSubscriptionRepository_GetAllForCurrency(t *testing.T) {
 	}
 }
 
+func TestSubscriptionRepository_GetAllForAccount(t *testing.T) {
+	testItem, _ := domain.NewSubscription("8", "user4", "account-8", domain.Currency{Symbol: "c3"}, 0)
+	subsRepo.Save(testItem)
+	defer subsRepo.Remove(testItem)
+
+	subs, _ := subsRepo.GetAllForAccount("c3", "account-8")
+	if len(subs) != 1 || subs[0].ID() != testItem.ID() {
+		t.Fatalf("expected only subscription %s, but got %d subscriptions", testItem.ID(), len(subs))
+	}
+
+	subs, _ = subsRepo.GetAllForAccount("c1", "account-8")
+	if len(subs) != 0 {
+		t.Fatalf("expected size %d, but got %d", 0, len(subs))
+	}
+}
+
+func TestSubscriptionRepository_GetAllWithDigest(t *testing.T) {
+	testItem, _ := domain.NewSubscription("7", "user4", "account-7", domain.Currency{Symbol: "c3"}, 0)
+	testItem.EnableDigest("@daily", "UTC", time.Now())
+	subsRepo.Save(testItem)
+	defer subsRepo.Remove(testItem)
+
+	subs, _ := subsRepo.GetAllWithDigest("c3")
+	if len(subs) != 1 || subs[0].ID() != testItem.ID() {
+		t.Fatalf("expected only subscription %s, but got %d subscriptions", testItem.ID(), len(subs))
+	}
+
+	subs, _ = subsRepo.GetAllWithDigest("c1")
+	if len(subs) != 0 {
+		t.Fatalf("expected size %d, but got %d", 0, len(subs))
+	}
+}
+
 func TestSubscriptionRepository_Save(t *testing.T) {
 	expectedSize := len(testSubs) + 1
 	testItem, _ := domain.NewSubscription("6", "user3", "account-6", domain.Currency{}, 0)
@@ -100,6 +136,39 @@ func TestSubscriptionRepository_Save(t *testing.T) {
 	}
 }
 
+func TestSubscriptionRepository_SaveConcurrentModification(t *testing.T) {
+	r := inmemory.NewSubscriptionRepository()
+	testItem, _ := domain.NewSubscription("1", "user1", "account-1", domain.Currency{Symbol: "c1"}, 5)
+	if err := r.Save(testItem); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loaded by someone else before the subscription is saved again
+	stale, _ := domain.DeepCopySubscription(
+		testItem.ID(), testItem.UserID(), testItem.Account(), testItem.Currency(), testItem.Filters(),
+		testItem.TotalReceived(), testItem.TotalSpent(), testItem.BlockHeight(), testItem.StartingBlockHeight(),
+		testItem.Digest(), testItem.AppliedTransfers(), testItem.Version(),
+	)
+
+	if err := r.Save(testItem); err != nil {
+		t.Fatal(err)
+	}
+
+	if testItem.Version() != 2 {
+		t.Fatalf("expected version %d, but got %d", 2, testItem.Version())
+	}
+
+	if err := r.Save(stale); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v, but got %v", domain.ErrConcurrentModification, err)
+	}
+
+	r.Remove(testItem)
+
+	if err := r.Save(testItem); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v for a removed subscription, but got %v", domain.ErrConcurrentModification, err)
+	}
+}
+
 func TestSubscriptionRepository_Remove(t *testing.T) {
 	expectedSize := subsRepo.Size() - 1
 	testItem := testSubs[0]
@@ -116,3 +185,111 @@ func TestSubscriptionRepository_Remove(t *testing.T) {
 		t.Fatalf("expected subscription item nil, but got %#v", s)
 	}
 }
+
+func TestSubscriptionRepository_GetReturnsCopy(t *testing.T) {
+	r := inmemory.NewSubscriptionRepository()
+	testItem, _ := domain.NewSubscription("1", "user1", "account-1", domain.Currency{Symbol: "c1"}, 5)
+	r.Save(testItem)
+
+	s, _ := r.Get(testItem.ID())
+	s.TotalReceived().SetInt64(100)
+	f, _ := domain.NewAmountFilter("5", false)
+	s.AddFilter(f)
+
+	s, _ = r.Get(testItem.ID())
+	if s.TotalReceived().Sign() != 0 || len(s.Filters()) != 0 {
+		t.Fatalf("expected the stored subscription to be intact, but got %s received and %d filters",
+			s.TotalReceived(), len(s.Filters()))
+	}
+}
+
+func TestSubscriptionRepository_Fail(t *testing.T) {
+	r := inmemory.NewSubscriptionRepository()
+	s1, _ := domain.NewSubscription("1", "user1", "account-1", domain.Currency{Symbol: "c1"}, 5)
+	s2, _ := domain.NewSubscription("2", "user1", "account-2", domain.Currency{Symbol: "c1"}, 5)
+	r.Save(s1)
+	r.SaveScanCursor("c1", 100)
+
+	r.Begin()
+	s1.AddFilter(domain.NewFilter(domain.Amount, nil, false))
+	r.Save(s1)
+	r.Save(s2)
+	r.SaveScanCursor("c1", 101)
+
+	// Changes are visible in the unit of work
+	if r.Size() != 2 {
+		t.Fatalf("expected size %d, but got %d", 2, r.Size())
+	}
+	r.Fail()
+
+	// The version is not incremented for the rolled back save
+	if s1.Version() != 1 {
+		t.Fatalf("expected version %d after rollback, but got %d", 1, s1.Version())
+	}
+
+	if r.Size() != 1 {
+		t.Fatalf("expected size %d after rollback, but got %d", 1, r.Size())
+	}
+
+	s, _ := r.Get(s1.ID())
+	if len(s.Filters()) != 0 || s.Version() != 1 {
+		t.Fatalf("expected the subscription before the unit of work, but got %d filters at version %d",
+			len(s.Filters()), s.Version())
+	}
+
+	if bh, _, _ := r.GetScanCursor("c1"); bh != 100 {
+		t.Fatalf("expected scan cursor at %d but got %d", 100, bh)
+	}
+
+	r.Begin()
+	r.Remove(s)
+	r.Success()
+
+	if r.Size() != 0 {
+		t.Fatalf("expected size %d, but got %d", 0, r.Size())
+	}
+}
+
+func TestSubscriptionRepository_Concurrent(t *testing.T) {
+	r := inmemory.NewSubscriptionRepository()
+	testItem, _ := domain.NewSubscription("1", "user1", "account-1", domain.Currency{Symbol: "c1"}, 0)
+	r.Save(testItem)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.Begin()
+			defer r.Success()
+			s, _ := r.Get(testItem.ID())
+			s.TotalReceived().Add(s.TotalReceived(), big.NewInt(1))
+			if err := r.Save(s); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			r.GetAllForCurrency("c1", 100)
+		}()
+	}
+	wg.Wait()
+
+	s, _ := r.Get(testItem.ID())
+	if s.TotalReceived().Cmp(big.NewInt(10)) != 0 {
+		t.Fatalf("expected total received %d, but got %s", 10, s.TotalReceived())
+	}
+}
+
+func TestSubscriptionRepository_ScanCursor(t *testing.T) {
+	if _, exist, _ := subsRepo.GetScanCursor("c1"); exist {
+		t.Fatal("expected no scan cursor for c1 but got one")
+	}
+
+	subsRepo.SaveScanCursor("c1", 100)
+
+	bh, exist, _ := subsRepo.GetScanCursor("c1")
+	if !exist || bh != 100 {
+		t.Fatalf("expected scan cursor at %d but got %d", 100, bh)
+	}
+}
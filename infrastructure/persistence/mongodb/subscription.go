@@ -22,16 +22,35 @@ import (
 // CollectionName is the name of Subscription collection
 const CollectionName = "Subscription"
 
+// ScanCursorCollectionName is the name of the collection keeping the last scanned block of each currency
+const ScanCursorCollectionName = "ScanCursor"
+
 // DocumentLimitsPerQuery limits query result to a certain number of documents
 const DocumentLimitsPerQuery = 1000
 
-// SubscriptionRepository is MongoDB implementation of SubscriptionRepository
+// Labels of the errors which tell that a transaction can be retried
+const (
+	transientTransactionErrorLabel = "TransientTransactionError"
+	unknownCommitResultLabel       = "UnknownTransactionCommitResult"
+)
+
+// Number of times a commit is retried if its result is unknown
+const commitRetries = 3
+
+// SubscriptionRepository is MongoDB implementation of SubscriptionRepository.
+// The operations between Begin and Success/Fail are done in a single transaction
+// of a session. Units of work on the same repository are done one after another,
+// and the ones on the repositories returned by NewSession are done concurrently
 type SubscriptionRepository struct {
-	client       *mongo.Client
-	session      mongo.Session
-	sessionMutex *sync.Mutex
-	subs         *mongo.Collection
-	txOpts       *options.TransactionOptions
+	client      *mongo.Client
+	session     mongo.Session
+	sessionCtx  mongo.SessionContext
+	uowMutex    *sync.Mutex
+	subs        *mongo.Collection
+	scanCursors *mongo.Collection
+	txOpts      *options.TransactionOptions
+	// Subscriptions saved in the transaction
+	saved []*domain.Subscription
 }
 
 // NewSubscriptionRepository creates a new instance of SubscriptionRepository
@@ -40,12 +59,24 @@ func NewSubscriptionRepository() *SubscriptionRepository {
 		txOpts: options.Transaction().
 			SetWriteConcern(writeconcern.New(writeconcern.WMajority())).
 			SetReadConcern(readconcern.Snapshot()),
-		sessionMutex: new(sync.Mutex),
+		uowMutex: new(sync.Mutex),
 	}
 
 	return repo
 }
 
+// NewSession returns a repository on the same connection for a unit of
+// work to be done concurrently with the ones on the other repositories
+func (r *SubscriptionRepository) NewSession() domain.SubscriptionRepository {
+	return &SubscriptionRepository{
+		client:      r.client,
+		uowMutex:    new(sync.Mutex),
+		subs:        r.subs,
+		scanCursors: r.scanCursors,
+		txOpts:      r.txOpts,
+	}
+}
+
 // Connect creates a connection to the given mongodb instance and the database
 func (r *SubscriptionRepository) Connect(uri string, databaseName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -60,6 +91,9 @@ func (r *SubscriptionRepository) Connect(uri string, databaseName string) error
 	r.subs = r.client.
 		Database(databaseName).
 		Collection(CollectionName)
+	r.scanCursors = r.client.
+		Database(databaseName).
+		Collection(ScanCursorCollectionName)
 
 	return nil
 }
@@ -69,41 +103,66 @@ func (r *SubscriptionRepository) Disconnect() error {
 	return r.client.Disconnect(context.Background())
 }
 
-// Begin starts a new session for ACID operation
+// Begin starts a new session and a transaction in which the following operations are done
 func (r *SubscriptionRepository) Begin() error {
 	log.Printf("Begin transaction")
 	r.checkConnection()
-	r.sessionMutex.Lock()
+	r.uowMutex.Lock()
 
 	session, err := r.client.StartSession()
 	if err != nil {
-		r.sessionMutex.Unlock()
+		r.uowMutex.Unlock()
+		return err
+	}
+
+	if err := session.StartTransaction(r.txOpts); err != nil {
+		session.EndSession(context.Background())
+		r.uowMutex.Unlock()
 		return err
 	}
 
 	r.session = session
+	r.sessionCtx = mongo.NewSessionContext(context.Background(), session)
 
 	return nil
 }
 
-// Fail rollbacks to the state before this change.
-// Notice that due to the fact that every operation is wrapped in
-// MongoDB Callback-API (https://docs.mongodb.com/manual/core/transactions-in-applications/#callback-api)
-// Here, just close the session and release the locked mutex
+// Fail aborts the transaction, so that none of the operations
+// done since Begin are persisted, and ends the session
 func (r *SubscriptionRepository) Fail() {
 	log.Printf("Rollback transaction")
-	defer r.sessionMutex.Unlock()
-	r.session.EndSession(context.Background())
+	defer r.endSession()
+
+	if err := r.session.AbortTransaction(r.sessionCtx); err != nil {
+		log.Printf("cannot abort transaction, %s", err.Error())
+	}
 }
 
-// Success finalizes ACID operation
-// Notice that due to the fact that every operation is wrapped in
-// MongoDB Callback-API (https://docs.mongodb.com/manual/core/transactions-in-applications/#callback-api)
-// Here, just close the session and release the locked mutex
-func (r *SubscriptionRepository) Success() {
+// Success commits the transaction and ends the session. Returns ErrConcurrentModification if
+// the transaction is aborted by the server, e.g. due to a write conflict or exceeding its lifetime
+func (r *SubscriptionRepository) Success() error {
 	log.Printf("Finalize transaction")
-	defer r.sessionMutex.Unlock()
-	r.session.EndSession(context.Background())
+	defer r.endSession()
+
+	err := r.session.CommitTransaction(r.sessionCtx)
+	// The commit is safe to retry if its result is unknown, e.g. due to a network error
+	for i := 0; i < commitRetries && hasErrorLabel(err, unknownCommitResultLabel); i++ {
+		err = r.session.CommitTransaction(r.sessionCtx)
+	}
+
+	// The whole transaction can be retried
+	if hasErrorLabel(err, transientTransactionErrorLabel) {
+		return domain.ErrConcurrentModification
+	}
+	if err != nil {
+		return fmt.Errorf("cannot commit transaction, %s", err.Error())
+	}
+
+	for _, s := range r.saved {
+		s.IncrementVersion()
+	}
+
+	return nil
 }
 
 // NextIdentity returns the next available identity
@@ -123,56 +182,92 @@ func (r *SubscriptionRepository) Size() int64 {
 
 // Get returns the subscription for the given subscription id
 func (r *SubscriptionRepository) Get(id string) (*domain.Subscription, error) {
-	s, err := r.applyOperation(func() (interface{}, error) {
-		return r.get(id)
-	})
+	s, err := r.get(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return ToDomain(s.(*Subscription)), err
+	return ToDomain(s), nil
 }
 
 // GetAllForUser returns all subscriptions for the given user id
 func (r *SubscriptionRepository) GetAllForUser(userID string) ([]*domain.Subscription, error) {
-	subs, err := r.applyOperation(func() (interface{}, error) {
-		return r.getByUserID(userID)
-	})
+	subs, err := r.getByUserID(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return ToDomainSlice(subs.([]*Subscription)), nil
+	return ToDomainSlice(subs), nil
 }
 
 // GetAllForCurrency returns all subscriptions for the given currency
 func (r *SubscriptionRepository) GetAllForCurrency(currencySymbol string, updatedBefore uint64) ([]*domain.Subscription, error) {
-	subs, err := r.applyOperation(func() (interface{}, error) {
-		return r.getByCurrency(currencySymbol, updatedBefore)
-	})
+	subs, err := r.getByCurrency(currencySymbol, updatedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	return ToDomainSlice(subs), nil
+}
+
+// GetAllForAccount returns all subscriptions for the given account of the given currency
+func (r *SubscriptionRepository) GetAllForAccount(currencySymbol string, account string) ([]*domain.Subscription, error) {
+	subs, err := r.getByAccount(currencySymbol, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return ToDomainSlice(subs), nil
+}
+
+// GetAllWithDigest returns all subscriptions for the given currency which are in digest mode
+func (r *SubscriptionRepository) GetAllWithDigest(currencySymbol string) ([]*domain.Subscription, error) {
+	subs, err := r.getWithDigest(currencySymbol)
 	if err != nil {
 		return nil, err
 	}
 
-	return ToDomainSlice(subs.([]*Subscription)), nil
+	return ToDomainSlice(subs), nil
 }
 
 // Save persists/updates the given subscription
 func (r *SubscriptionRepository) Save(s *domain.Subscription) error {
-	_, err := r.applyOperation(func() (interface{}, error) {
-		return nil, r.replaceOrInsert(FromDomain(s))
-	})
+	if err := r.compareAndSwap(FromDomain(s)); err != nil {
+		return err
+	}
 
-	return err
+	// Its version is incremented once the transaction of the unit of work is committed
+	if r.session != nil {
+		r.saved = append(r.saved, s)
+		return nil
+	}
+	s.IncrementVersion()
+
+	return nil
 }
 
 // Remove removes the given subscription from the persistance
 func (r *SubscriptionRepository) Remove(s *domain.Subscription) error {
-	_, err := r.applyOperation(func() (interface{}, error) {
-		return nil, r.delete(s.ID())
-	})
+	return r.delete(s.ID())
+}
 
-	return err
+// GetScanCursor returns the height of the last scanned block for the given currency
+func (r *SubscriptionRepository) GetScanCursor(currencySymbol string) (uint64, bool, error) {
+	c, err := r.getScanCursor(currencySymbol)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if c == nil {
+		return 0, false, nil
+	}
+
+	return c.BlockHeight, true, nil
+}
+
+// SaveScanCursor persists the height of the last scanned block for the given currency
+func (r *SubscriptionRepository) SaveScanCursor(currencySymbol string, blockHeight uint64) error {
+	return r.upsertScanCursor(&ScanCursor{Currency: currencySymbol, BlockHeight: blockHeight})
 }
 
 func (r *SubscriptionRepository) checkConnection() {
@@ -187,19 +282,28 @@ func (r *SubscriptionRepository) checkConnection() {
 	}
 }
 
-func (r *SubscriptionRepository) applyOperation(op func() (interface{}, error)) (interface{}, error) {
-	callback := func(sctx mongo.SessionContext) (interface{}, error) {
-		return op()
+// Returns the context of the session in a unit of work, so that the
+// operations are done in its transaction, or the background context outside of it
+func (r *SubscriptionRepository) ctx() context.Context {
+	if r.sessionCtx != nil {
+		return r.sessionCtx
 	}
+	return context.Background()
+}
 
-	return r.session.WithTransaction(context.Background(), callback, r.txOpts)
+func (r *SubscriptionRepository) endSession() {
+	r.session.EndSession(context.Background())
+	r.session = nil
+	r.sessionCtx = nil
+	r.saved = nil
+	r.uowMutex.Unlock()
 }
 
 func (r *SubscriptionRepository) get(id string) (*Subscription, error) {
 	s := &Subscription{}
 	query := bson.M{"_id": id}
 
-	if err := r.subs.FindOne(context.Background(), query).Decode(s); err != nil {
+	if err := r.subs.FindOne(r.ctx(), query).Decode(s); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
@@ -210,7 +314,7 @@ func (r *SubscriptionRepository) get(id string) (*Subscription, error) {
 }
 
 func (r *SubscriptionRepository) getByUserID(userID string) ([]*Subscription, error) {
-	ctx := context.Background()
+	ctx := r.ctx()
 	query := bson.M{"userId": userID}
 
 	cursor, err := r.subs.Find(ctx, query)
@@ -231,7 +335,7 @@ func (r *SubscriptionRepository) getByUserID(userID string) ([]*Subscription, er
 }
 
 func (r *SubscriptionRepository) getByCurrency(symbol string, bh uint64) ([]*Subscription, error) {
-	ctx := context.Background()
+	ctx := r.ctx()
 	opts := options.Find()
 	opts.SetLimit(DocumentLimitsPerQuery)
 	query := bson.M{
@@ -253,83 +357,111 @@ func (r *SubscriptionRepository) getByCurrency(symbol string, bh uint64) ([]*Sub
 	return subs, nil
 }
 
-func (r *SubscriptionRepository) replaceOrInsert(s *Subscription) error {
-	query := bson.M{"_id": s.ID}
-
-	log.Println("Checking if record exists...")
-	log.Printf("Subscription: ID=%s, UserID=%s, Currency=%s, Account=%s",
-		s.ID, s.UserID, s.Currency, s.Account)
+func (r *SubscriptionRepository) getByAccount(symbol string, account string) ([]*Subscription, error) {
+	ctx := r.ctx()
+	opts := options.Find()
+	opts.SetLimit(DocumentLimitsPerQuery)
+	query := bson.M{
+		"currency": symbol,
+		"account":  account,
+	}
 
-	err := r.subs.FindOne(context.Background(), query).Err()
+	cursor, err := r.subs.Find(ctx, query, opts)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return r.insert(s)
-		}
-		return err
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	subs := make([]*Subscription, 0)
+	if err = cursor.All(ctx, &subs); err != nil {
+		return nil, err
 	}
 
-	return r.replace(s)
+	return subs, nil
 }
 
-func (r *SubscriptionRepository) replace(s *Subscription) error {
+func (r *SubscriptionRepository) getWithDigest(symbol string) ([]*Subscription, error) {
+	ctx := r.ctx()
+	opts := options.Find()
+	opts.SetLimit(DocumentLimitsPerQuery)
 	query := bson.M{
-		"userId":   s.UserID,
-		"currency": s.Currency,
-		"account":  s.Account,
-	}
-	// update := bson.M{
-	// 	"blockHeight":         s.BlockHeight,
-	// 	"totalReceived":       s.TotalReceived,
-	// 	"totalSpent":          s.TotalSpent,
-	// 	"startingBlockHeight": s.StartingBlockHeight,
-	// 	"filters":             s.Filters,
-	// }
-
-	update := bson.D{
-		{
-			Key: "$set",
-			Value: bson.M{
-				"blockHeight":         s.BlockHeight,
-				"totalReceived":       s.TotalReceived,
-				"totalSpent":          s.TotalSpent,
-				"startingBlockHeight": s.StartingBlockHeight,
-				"filters":             s.Filters,
-			},
-		},
+		"currency": symbol,
+		"digest":   bson.M{"$ne": nil},
 	}
 
-	log.Println("Updating record...")
-
-	res, err := r.subs.ReplaceOne(context.Background(), query, update)
+	cursor, err := r.subs.Find(ctx, query, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	if res.MatchedCount < 1 || (res.UpsertedID).(string) != s.ID {
-		return fmt.Errorf("failed to save the subscription (%s)", s.ID)
+	subs := make([]*Subscription, 0)
+	if err = cursor.All(ctx, &subs); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return subs, nil
 }
 
-func (r *SubscriptionRepository) insert(s *Subscription) error {
-	log.Println("Creating new record...")
+// Replaces the document of the given subscription if its version is still the persisted one,
+// or inserts it if it is a new one, incrementing its version
+func (r *SubscriptionRepository) compareAndSwap(s *Subscription) error {
+	query := bson.M{"_id": s.ID, "version": s.Version}
+	if s.Version == 0 {
+		// Documents persisted before versioning have no version
+		query["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	log.Printf("Saving subscription: ID=%s, UserID=%s, Currency=%s, Account=%s, Version=%d",
+		s.ID, s.UserID, s.Currency, s.Account, s.Version)
 
-	res, err := r.subs.InsertOne(context.Background(), s)
+	next := *s
+	next.Version++
+
+	// A new subscription is inserted, and the insertion fails
+	// with a duplicate key if it has been inserted by someone else
+	res, err := r.subs.ReplaceOne(r.ctx(), query, &next, options.Replace().SetUpsert(s.Version == 0))
+	// The transaction conflicts with another one which has written the same document
+	if mongo.IsDuplicateKeyError(err) || hasErrorLabel(err, transientTransactionErrorLabel) {
+		return domain.ErrConcurrentModification
+	}
 	if err != nil {
 		return err
 	}
 
-	if res.InsertedID != nil && (res.InsertedID).(string) != s.ID {
-		return fmt.Errorf("failed to create subscription (%s)", s.ID)
+	if res.MatchedCount < 1 && res.UpsertedCount < 1 {
+		return domain.ErrConcurrentModification
 	}
 
 	return nil
 }
 
+func (r *SubscriptionRepository) getScanCursor(symbol string) (*ScanCursor, error) {
+	c := &ScanCursor{}
+	query := bson.M{"_id": symbol}
+
+	if err := r.scanCursors.FindOne(r.ctx(), query).Decode(c); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (r *SubscriptionRepository) upsertScanCursor(c *ScanCursor) error {
+	query := bson.M{"_id": c.Currency}
+	update := bson.M{"$set": bson.M{"blockHeight": c.BlockHeight}}
+
+	_, err := r.scanCursors.UpdateOne(r.ctx(), query, update, options.Update().SetUpsert(true))
+
+	return err
+}
+
 func (r *SubscriptionRepository) delete(id string) error {
 	query := bson.M{"_id": id}
-	res, err := r.subs.DeleteOne(context.Background(), query)
+	res, err := r.subs.DeleteOne(r.ctx(), query)
 	if err != nil {
 		return err
 	}
@@ -341,6 +473,11 @@ func (r *SubscriptionRepository) delete(id string) error {
 	return nil
 }
 
+func hasErrorLabel(err error, label string) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorLabel(label)
+}
+
 // Subscription represents a document in MongoDB corresponding to domain.Subscription
 type Subscription struct {
 	ID                  string   `bson:"_id"                 json:"_id"`
@@ -353,6 +490,24 @@ type Subscription struct {
 	TotalSpent          string   `bson:"totalSpent"          json:"totalSpent"`
 	StartingBlockHeight uint64   `bson:"startingBlockHeight" json:"startingBlockHeight"`
 	Filters             []Filter `bson:"filters"             json:"filters"`
+	Digest              *Digest  `bson:"digest"              json:"digest"`
+	// Identities of the transfers applied within the retention window
+	AppliedTransfers []AppliedTransfer `bson:"appliedTransfers" json:"appliedTransfers"`
+	// Number of times the subscription has been saved
+	Version uint64 `bson:"version" json:"version"`
+}
+
+// ScanCursor represents a document in MongoDB keeping the last scanned block of a currency
+type ScanCursor struct {
+	Currency    string `bson:"_id"         json:"_id"`
+	BlockHeight uint64 `bson:"blockHeight" json:"blockHeight"`
+}
+
+// AppliedTransfer represents a document in MongoDB corresponding
+// to an identity of a transfer applied to domain.Subscription
+type AppliedTransfer struct {
+	ID          string `bson:"id"          json:"id"`
+	BlockHeight uint64 `bson:"blockHeight" json:"blockHeight"`
 }
 
 // Filter represents a document in MongoDB corresponding to domain.Filter
@@ -362,6 +517,25 @@ type Filter struct {
 	Type      string `bson:"type"      json:"type"`
 }
 
+// Digest represents a document in MongoDB corresponding to domain.Digest
+type Digest struct {
+	Schedule   string     `bson:"schedule"   json:"schedule"`
+	TimeZone   string     `bson:"timeZone"   json:"timeZone"`
+	LastSentAt time.Time  `bson:"lastSentAt" json:"lastSentAt"`
+	Transfers  []Transfer `bson:"transfers"  json:"transfers"`
+}
+
+// Transfer represents a document in MongoDB corresponding to domain.Transfer
+type Transfer struct {
+	Type        int    `bson:"type"        json:"type"`
+	Address     string `bson:"address"     json:"address"`
+	Amount      string `bson:"amount"      json:"amount"`
+	BlockHeight uint64 `bson:"blockHeight" json:"blockHeight"`
+	Timestamp   uint64 `bson:"timestamp"   json:"timestamp"`
+	TxHash      string `bson:"txHash"      json:"txHash"`
+	Index       uint   `bson:"index"       json:"index"`
+}
+
 // FromDomain converts domain.Subscription model to a MongoDB document representation
 func FromDomain(s *domain.Subscription) *Subscription {
 	if s == nil {
@@ -382,6 +556,34 @@ func FromDomain(s *domain.Subscription) *Subscription {
 		})
 	}
 
+	var digest *Digest
+	if d := s.Digest(); d != nil {
+		transfers := []Transfer{}
+		for _, t := range d.Transfers() {
+			transfers = append(transfers, Transfer{
+				Type:        t.Type,
+				Address:     t.Address,
+				Amount:      t.Amount.String(),
+				BlockHeight: t.BlockHeight,
+				Timestamp:   t.Timestamp,
+				TxHash:      t.TxHash,
+				Index:       t.Index,
+			})
+		}
+
+		digest = &Digest{
+			Schedule:   d.Schedule(),
+			TimeZone:   d.TimeZone(),
+			LastSentAt: d.LastSentAt(),
+			Transfers:  transfers,
+		}
+	}
+
+	appliedTransfers := []AppliedTransfer{}
+	for id, bh := range s.AppliedTransfers() {
+		appliedTransfers = append(appliedTransfers, AppliedTransfer{ID: id, BlockHeight: bh})
+	}
+
 	return &Subscription{
 		ID:                  s.ID(),
 		UserID:              s.UserID(),
@@ -393,6 +595,9 @@ func FromDomain(s *domain.Subscription) *Subscription {
 		StartingBlockHeight: s.StartingBlockHeight(),
 		TotalReceived:       s.TotalReceived().String(),
 		TotalSpent:          s.TotalSpent().String(),
+		Digest:              digest,
+		AppliedTransfers:    appliedTransfers,
+		Version:             s.Version(),
 	}
 }
 
@@ -428,6 +633,34 @@ func ToDomain(s *Subscription) *domain.Subscription {
 		filters = append(filters, filter)
 	}
 
+	var digest *domain.Digest
+	if s.Digest != nil {
+		transfers := []*domain.Transfer{}
+		for _, t := range s.Digest.Transfers {
+			amount, ok := new(big.Int).SetString(t.Amount, 10)
+			if !ok {
+				panic(fmt.Errorf("Transfer.Amount (%s) is not a valid bignumber representation", t.Amount))
+			}
+
+			transfers = append(transfers, &domain.Transfer{
+				Type:        t.Type,
+				Address:     t.Address,
+				Amount:      amount,
+				BlockHeight: t.BlockHeight,
+				Timestamp:   t.Timestamp,
+				TxHash:      t.TxHash,
+				Index:       t.Index,
+			})
+		}
+
+		digest = domain.DeepCopyDigest(s.Digest.Schedule, s.Digest.TimeZone, s.Digest.LastSentAt, transfers)
+	}
+
+	appliedTransfers := make(map[string]uint64, len(s.AppliedTransfers))
+	for _, at := range s.AppliedTransfers {
+		appliedTransfers[at.ID] = at.BlockHeight
+	}
+
 	sub, _ := domain.DeepCopySubscription(
 		s.ID,
 		s.UserID,
@@ -441,6 +674,9 @@ func ToDomain(s *Subscription) *domain.Subscription {
 		totalSpent,
 		s.BlockHeight,
 		s.StartingBlockHeight,
+		digest,
+		appliedTransfers,
+		s.Version,
 	)
 	return sub
 }
@@ -176,6 +176,41 @@ func TestSubscriptionRepository_Save(t *testing.T) {
 	}
 }
 
+func TestSubscriptionRepository_SaveConcurrentModification(t *testing.T) {
+	cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	r := mongodb.NewSubscriptionRepository()
+	if err := r.Connect(dbURI, dbName); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Success()
+
+	testItem := testSubs[1]
+	s, _ := r.Get(testItem.ID())
+	stale, _ := r.Get(testItem.ID())
+
+	if err := r.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(stale); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v, but got %v", domain.ErrConcurrentModification, err)
+	}
+
+	if err := r.Remove(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(s); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v for a removed subscription, but got %v", domain.ErrConcurrentModification, err)
+	}
+}
+
 func TestSubscriptionRepository_Remove(t *testing.T) {
 	cleanUp := helperCreateAndPopulateDB(t)
 	defer cleanUp()
@@ -205,6 +240,120 @@ func TestSubscriptionRepository_Remove(t *testing.T) {
 	}
 }
 
+func TestSubscriptionRepository_Fail(t *testing.T) {
+	cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	r := mongodb.NewSubscriptionRepository()
+	if err := r.Connect(dbURI, dbName); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSize := r.Size()
+	testItem, _ := domain.NewSubscription(r.NextIdentity("user3"), "user3", "account-7", domain.Currency{Decimal: big.NewInt(1000)}, 0)
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(testItem); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove(testSubs[0]); err != nil {
+		t.Fatal(err)
+	}
+	r.Fail()
+
+	// The version is not incremented for the rolled back save
+	if testItem.Version() != 0 {
+		t.Fatalf("expected version %d after rollback, but got %d", 0, testItem.Version())
+	}
+
+	if r.Size() != expectedSize {
+		t.Fatalf("expected size %d after rollback, but got %d", expectedSize, r.Size())
+	}
+
+	if s, _ := r.Get(testSubs[0].ID()); s == nil {
+		t.Fatal("expected the removed subscription to be restored, but got nil")
+	}
+
+	if s, _ := r.Get(testItem.ID()); s != nil {
+		t.Fatalf("expected subscription item nil, but got %#v", s)
+	}
+}
+
+func TestSubscriptionRepository_NewSession(t *testing.T) {
+	cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	r := mongodb.NewSubscriptionRepository()
+	if err := r.Connect(dbURI, dbName); err != nil {
+		t.Fatal(err)
+	}
+
+	// Units of work on different sessions do not wait for each other
+	r1, r2 := r.NewSession(), r.NewSession()
+	if err := r1.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	s1, _ := r1.Get(testSubs[1].ID())
+	s2, _ := r2.Get(testSubs[1].ID())
+
+	if err := r1.Save(s1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same subscription is being written in the other transaction
+	if err := r2.Save(s2); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v, but got %v", domain.ErrConcurrentModification, err)
+	}
+
+	r2.Fail()
+	r1.Success()
+
+	s, _ := r.Get(testSubs[1].ID())
+	if s.Version() != 1 {
+		t.Fatalf("expected version %d, but got %d", 1, s.Version())
+	}
+}
+
+func TestSubscriptionRepository_ScanCursor(t *testing.T) {
+	cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	r := mongodb.NewSubscriptionRepository()
+	if err := r.Connect(dbURI, dbName); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Success()
+
+	if _, exist, err := r.GetScanCursor("eth"); err != nil || exist {
+		t.Fatalf("expected no scan cursor for eth but got one, err: %v", err)
+	}
+
+	for _, bh := range []uint64{100, 101} {
+		if err := r.SaveScanCursor("eth", bh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bh, exist, err := r.GetScanCursor("eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exist || bh != 101 {
+		t.Fatalf("expected scan cursor at %d but got %d", 101, bh)
+	}
+}
+
 func helperReadTestData(t *testing.T, filename string) []*mongodb.Subscription {
 	f, err := os.Open(filepath.Join("./testdata", filename))
 	if err != nil {
@@ -0,0 +1,124 @@
+package postgresql
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Key of the advisory lock taken while migrating, so that the
+// binaries starting at the same time do not migrate concurrently
+const migrationLockKey = 7235342018
+
+// Migrations are applied in order and each of them only once. The applied ones are recorded
+// in schema_migrations by their versions, which are their positions in this list starting
+// from 1, so new migrations must only be appended. {schema} is replaced with the schema name
+var migrations = []string{
+	// 1: Subscriptions and their filters, digests and applied transfers
+	`CREATE TABLE {schema}.subscriptions (
+		id                    TEXT PRIMARY KEY,
+		user_id               TEXT NOT NULL,
+		currency              TEXT NOT NULL,
+		currency_decimal      NUMERIC(78, 0) NOT NULL,
+		account               TEXT NOT NULL,
+		block_height          BIGINT NOT NULL,
+		starting_block_height BIGINT NOT NULL,
+		total_received        NUMERIC(78, 0) NOT NULL,
+		total_spent           NUMERIC(78, 0) NOT NULL
+	);
+	CREATE INDEX subscriptions_user_id_idx ON {schema}.subscriptions (user_id);
+	CREATE INDEX subscriptions_currency_block_height_idx ON {schema}.subscriptions (currency, block_height);
+	CREATE INDEX subscriptions_currency_account_idx ON {schema}.subscriptions (currency, account);
+
+	CREATE TABLE {schema}.subscription_filters (
+		subscription_id TEXT NOT NULL REFERENCES {schema}.subscriptions (id) ON DELETE CASCADE,
+		position        INTEGER NOT NULL,
+		type            TEXT NOT NULL,
+		condition       TEXT NOT NULL,
+		is_must         BOOLEAN NOT NULL,
+		PRIMARY KEY (subscription_id, position)
+	);
+
+	CREATE TABLE {schema}.subscription_digests (
+		subscription_id TEXT PRIMARY KEY REFERENCES {schema}.subscriptions (id) ON DELETE CASCADE,
+		schedule        TEXT NOT NULL,
+		time_zone       TEXT NOT NULL,
+		last_sent_at    TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE {schema}.digest_transfers (
+		subscription_id TEXT NOT NULL REFERENCES {schema}.subscription_digests (subscription_id) ON DELETE CASCADE,
+		position        INTEGER NOT NULL,
+		type            SMALLINT NOT NULL,
+		address         TEXT NOT NULL,
+		amount          NUMERIC(78, 0) NOT NULL,
+		block_height    BIGINT NOT NULL,
+		timestamp       BIGINT NOT NULL,
+		tx_hash         TEXT NOT NULL,
+		tx_index        BIGINT NOT NULL,
+		PRIMARY KEY (subscription_id, position)
+	);
+
+	CREATE TABLE {schema}.applied_transfers (
+		subscription_id TEXT NOT NULL REFERENCES {schema}.subscriptions (id) ON DELETE CASCADE,
+		transfer_id     TEXT NOT NULL,
+		block_height    BIGINT NOT NULL,
+		PRIMARY KEY (subscription_id, transfer_id)
+	);`,
+	// 2: Last scanned block of each currency
+	`CREATE TABLE {schema}.scan_cursors (
+		currency     TEXT PRIMARY KEY,
+		block_height BIGINT NOT NULL
+	);`,
+	// 3: Number of times each subscription has been saved, for optimistic concurrency control
+	`ALTER TABLE {schema}.subscriptions ADD COLUMN version BIGINT NOT NULL DEFAULT 0;`,
+}
+
+// Creates the schema if it does not exist and applies the migrations which are not applied yet
+func migrate(db *sql.DB, schema string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, schema)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, schema)); err != nil {
+		return err
+	}
+
+	var current int
+	if err := tx.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s.schema_migrations`, schema)).Scan(&current); err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return fmt.Errorf("schema version(%d) is newer than the latest migration(%d)", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		log.Printf("Applying migration#%d", version)
+
+		if _, err := tx.Exec(strings.Replace(migrations[i], "{schema}", schema, -1)); err != nil {
+			return fmt.Errorf("migration#%d failed, %s", version, err.Error())
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s.schema_migrations (version) VALUES ($1)`, schema), version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,113 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Migrations are applied in order and each of them only once. The applied ones are recorded
+// in schema_migrations by their versions, which are their positions in this list starting
+// from 1, so new migrations must only be appended
+var migrations = []string{
+	// 1: Subscriptions and their filters, digests and applied transfers.
+	// Big numbers are kept in their decimal representations
+	`CREATE TABLE subscriptions (
+		id                    TEXT PRIMARY KEY,
+		user_id               TEXT NOT NULL,
+		currency              TEXT NOT NULL,
+		currency_decimal      TEXT NOT NULL,
+		account               TEXT NOT NULL,
+		block_height          INTEGER NOT NULL,
+		starting_block_height INTEGER NOT NULL,
+		total_received        TEXT NOT NULL,
+		total_spent           TEXT NOT NULL
+	);
+	CREATE INDEX subscriptions_user_id_idx ON subscriptions (user_id);
+	CREATE INDEX subscriptions_currency_block_height_idx ON subscriptions (currency, block_height);
+	CREATE INDEX subscriptions_currency_account_idx ON subscriptions (currency, account);
+
+	CREATE TABLE subscription_filters (
+		subscription_id TEXT NOT NULL REFERENCES subscriptions (id) ON DELETE CASCADE,
+		position        INTEGER NOT NULL,
+		type            TEXT NOT NULL,
+		condition       TEXT NOT NULL,
+		is_must         BOOLEAN NOT NULL,
+		PRIMARY KEY (subscription_id, position)
+	);
+
+	CREATE TABLE subscription_digests (
+		subscription_id TEXT PRIMARY KEY REFERENCES subscriptions (id) ON DELETE CASCADE,
+		schedule        TEXT NOT NULL,
+		time_zone       TEXT NOT NULL,
+		last_sent_at    TEXT NOT NULL
+	);
+
+	CREATE TABLE digest_transfers (
+		subscription_id TEXT NOT NULL REFERENCES subscription_digests (subscription_id) ON DELETE CASCADE,
+		position        INTEGER NOT NULL,
+		type            INTEGER NOT NULL,
+		address         TEXT NOT NULL,
+		amount          TEXT NOT NULL,
+		block_height    INTEGER NOT NULL,
+		timestamp       INTEGER NOT NULL,
+		tx_hash         TEXT NOT NULL,
+		tx_index        INTEGER NOT NULL,
+		PRIMARY KEY (subscription_id, position)
+	);
+
+	CREATE TABLE applied_transfers (
+		subscription_id TEXT NOT NULL REFERENCES subscriptions (id) ON DELETE CASCADE,
+		transfer_id     TEXT NOT NULL,
+		block_height    INTEGER NOT NULL,
+		PRIMARY KEY (subscription_id, transfer_id)
+	);`,
+	// 2: Last scanned block of each currency
+	`CREATE TABLE scan_cursors (
+		currency     TEXT PRIMARY KEY,
+		block_height INTEGER NOT NULL
+	);`,
+	// 3: Number of times each subscription has been saved, for optimistic concurrency control
+	`ALTER TABLE subscriptions ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// Applies the migrations which are not applied yet. The transaction takes the write
+// lock of the database, so the binaries starting at the same time do not migrate concurrently
+func migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return fmt.Errorf("schema version(%d) is newer than the latest migration(%d)", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		log.Printf("Applying migration#%d", version)
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration#%d failed, %s", version, err.Error())
+		}
+
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,662 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// DriverName is the name under which the pure-Go driver modernc.org/sqlite registers itself
+const DriverName = "sqlite"
+
+// RowLimitsPerQuery limits query result to a certain number of subscriptions
+const RowLimitsPerQuery = 1000
+
+// Pragmas of each connection. WAL lets the readers go on while a unit of work is writing,
+// and the writers wait for each other instead of failing with SQLITE_BUSY. Transactions
+// take the write lock on their first write, so a unit of work only holds it while writing
+const connectionOptions = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)"
+
+// Columns of subscriptions table in the order they are scanned
+const subscriptionColumns = `id, user_id, currency, currency_decimal, account,
+	block_height, starting_block_height, total_received, total_spent, version`
+
+// querier is either the database or the transaction of the current unit of work
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SubscriptionRepository is SQLite implementation of SubscriptionRepository
+type SubscriptionRepository struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	txMu *sync.Mutex
+	// Subscriptions saved in the transaction
+	saved []*domain.Subscription
+}
+
+// NewSubscriptionRepository creates a new instance of SubscriptionRepository
+func NewSubscriptionRepository() *SubscriptionRepository {
+	return &SubscriptionRepository{
+		txMu: new(sync.Mutex),
+	}
+}
+
+// Connect opens the database file at the given path, creating it if it does not exist,
+// and migrates the schema. Database name is not used since a file keeps a single database
+func (r *SubscriptionRepository) Connect(uri string, databaseName string) error {
+	db, err := sql.Open(DriverName, dataSourceName(uri))
+	if err != nil {
+		return fmt.Errorf("cannot open %s database, %s", DriverName, err.Error())
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+
+	r.db = db
+
+	if err := migrate(r.db); err != nil {
+		db.Close()
+		return fmt.Errorf("cannot migrate database(%s), %s", uri, err.Error())
+	}
+
+	return nil
+}
+
+// Disconnect closes the database file
+func (r *SubscriptionRepository) Disconnect() error {
+	return r.db.Close()
+}
+
+// Begin starts a new SQL transaction which the following operations are done in
+func (r *SubscriptionRepository) Begin() error {
+	log.Printf("Begin transaction")
+	r.txMu.Lock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		r.txMu.Unlock()
+		return err
+	}
+
+	r.tx = tx
+
+	return nil
+}
+
+// Fail rollbacks the transaction to the state before this work
+func (r *SubscriptionRepository) Fail() {
+	log.Printf("Rollback transaction")
+	defer r.txMu.Unlock()
+
+	if err := r.tx.Rollback(); err != nil {
+		log.Printf("cannot rollback transaction, %s", err.Error())
+	}
+	r.tx = nil
+	r.saved = nil
+}
+
+// Success commits the transaction
+func (r *SubscriptionRepository) Success() error {
+	log.Printf("Finalize transaction")
+	defer r.txMu.Unlock()
+
+	err := r.tx.Commit()
+	saved := r.saved
+	r.tx = nil
+	r.saved = nil
+	if isConflict(err) {
+		return domain.ErrConcurrentModification
+	}
+	if err != nil {
+		return fmt.Errorf("cannot commit transaction, %s", err.Error())
+	}
+
+	for _, s := range saved {
+		s.IncrementVersion()
+	}
+
+	return nil
+}
+
+// NextIdentity returns the next available identity
+func (r *SubscriptionRepository) NextIdentity(userID string) string {
+	return userID + ":" + uuid.New().String()
+}
+
+// Size returns the total number of subscriptions persited in the repository
+func (r *SubscriptionRepository) Size() int64 {
+	var c int64
+	if err := r.querier().QueryRow(`SELECT COUNT(*) FROM subscriptions`).Scan(&c); err != nil {
+		return -1
+	}
+
+	return c
+}
+
+// Get returns the subscription for the given subscription id
+func (r *SubscriptionRepository) Get(id string) (*domain.Subscription, error) {
+	subs, err := r.find(`id = ?`, id)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+
+	return subs[0], nil
+}
+
+// GetAllForUser returns all subscriptions for the given user id
+func (r *SubscriptionRepository) GetAllForUser(userID string) ([]*domain.Subscription, error) {
+	return r.find(`user_id = ?`, userID)
+}
+
+// GetAllForCurrency returns all subscriptions for the given currency
+func (r *SubscriptionRepository) GetAllForCurrency(currencySymbol string, updatedBefore uint64) ([]*domain.Subscription, error) {
+	return r.find(`currency = ? AND block_height < ?`, currencySymbol, int64(updatedBefore))
+}
+
+// GetAllForAccount returns all subscriptions for the given account of the given currency
+func (r *SubscriptionRepository) GetAllForAccount(currencySymbol string, account string) ([]*domain.Subscription, error) {
+	return r.find(`currency = ? AND account = ?`, currencySymbol, account)
+}
+
+// GetAllWithDigest returns all subscriptions for the given currency which are in digest mode
+func (r *SubscriptionRepository) GetAllWithDigest(currencySymbol string) ([]*domain.Subscription, error) {
+	return r.find(`currency = ? AND id IN (SELECT subscription_id FROM subscription_digests)`, currencySymbol)
+}
+
+// Save persists/updates the given subscription
+func (r *SubscriptionRepository) Save(s *domain.Subscription) error {
+	err := r.inTransaction(func(q querier) error {
+		return upsert(q, s)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Its version is incremented once the transaction of the unit of work is committed
+	if r.tx != nil {
+		r.saved = append(r.saved, s)
+		return nil
+	}
+	s.IncrementVersion()
+
+	return nil
+}
+
+// Remove removes the given subscription from the persistance
+func (r *SubscriptionRepository) Remove(s *domain.Subscription) error {
+	return r.inTransaction(func(q querier) error {
+		res, err := q.Exec(`DELETE FROM subscriptions WHERE id = ?`, s.ID())
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return fmt.Errorf("failed to delete the subscription (%s)", s.ID())
+		}
+
+		return nil
+	})
+}
+
+// GetScanCursor returns the height of the last scanned block for the given currency
+func (r *SubscriptionRepository) GetScanCursor(currencySymbol string) (uint64, bool, error) {
+	var bh int64
+	err := r.querier().QueryRow(`SELECT block_height FROM scan_cursors WHERE currency = ?`, currencySymbol).Scan(&bh)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return uint64(bh), true, nil
+}
+
+// SaveScanCursor persists the height of the last scanned block for the given currency
+func (r *SubscriptionRepository) SaveScanCursor(currencySymbol string, blockHeight uint64) error {
+	_, err := r.querier().Exec(
+		`INSERT INTO scan_cursors (currency, block_height) VALUES (?, ?)
+			ON CONFLICT (currency) DO UPDATE SET block_height = excluded.block_height`,
+		currencySymbol,
+		int64(blockHeight),
+	)
+
+	return err
+}
+
+// Returns the transaction of the current unit of work, or the database outside of it
+func (r *SubscriptionRepository) querier() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// Runs the given writes in the transaction of the current unit of
+// work, or in a transaction of their own outside of it
+func (r *SubscriptionRepository) inTransaction(op func(q querier) error) error {
+	if r.tx != nil {
+		return op(r.tx)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := op(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns the subscriptions matching the given condition along with their filters, digests and applied transfers
+func (r *SubscriptionRepository) find(where string, args ...interface{}) ([]*domain.Subscription, error) {
+	q := r.querier()
+
+	rows, err := q.Query(
+		fmt.Sprintf(`SELECT %s FROM subscriptions WHERE %s ORDER BY id LIMIT %d`, subscriptionColumns, where, RowLimitsPerQuery),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]*Subscription, 0)
+	for rows.Next() {
+		s := &Subscription{AppliedTransfers: make(map[string]uint64)}
+		var bh, sbh, version int64
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Currency, &s.CurrencyDecimal, &s.Account,
+			&bh, &sbh, &s.TotalReceived, &s.TotalSpent, &version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.BlockHeight, s.StartingBlockHeight, s.Version = uint64(bh), uint64(sbh), uint64(version)
+		subs = append(subs, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(subs) > 0 {
+		if err := loadChildren(q, subs); err != nil {
+			return nil, err
+		}
+	}
+
+	return ToDomainSlice(subs)
+}
+
+// Updates the row of the given subscription if its version is still the
+// persisted one, or inserts it if it is a new one, incrementing its version
+func compareAndSwap(q querier, s *domain.Subscription) error {
+	var res sql.Result
+	var err error
+	if s.Version() == 0 {
+		// Rows persisted before versioning are at version 0 as well
+		res, err = q.Exec(
+			fmt.Sprintf(`INSERT INTO subscriptions (%s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (id) DO UPDATE SET
+					currency_decimal = excluded.currency_decimal,
+					block_height = excluded.block_height,
+					starting_block_height = excluded.starting_block_height,
+					total_received = excluded.total_received,
+					total_spent = excluded.total_spent,
+					version = excluded.version
+				WHERE subscriptions.version = 0`, subscriptionColumns),
+			s.ID(),
+			s.UserID(),
+			s.Currency().Symbol,
+			s.Currency().Decimal.String(),
+			s.Account(),
+			int64(s.BlockHeight()),
+			int64(s.StartingBlockHeight()),
+			s.TotalReceived().String(),
+			s.TotalSpent().String(),
+			int64(s.Version()+1),
+		)
+	} else {
+		res, err = q.Exec(
+			`UPDATE subscriptions SET
+					currency_decimal = ?,
+					block_height = ?,
+					starting_block_height = ?,
+					total_received = ?,
+					total_spent = ?,
+					version = ?
+				WHERE id = ? AND version = ?`,
+			s.Currency().Decimal.String(),
+			int64(s.BlockHeight()),
+			int64(s.StartingBlockHeight()),
+			s.TotalReceived().String(),
+			s.TotalSpent().String(),
+			int64(s.Version()+1),
+			s.ID(),
+			int64(s.Version()),
+		)
+	}
+	if isConflict(err) {
+		return domain.ErrConcurrentModification
+	}
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return domain.ErrConcurrentModification
+	}
+
+	return nil
+}
+
+// Replaces the row of the given subscription and the rows of its filters, digest and applied transfers
+func upsert(q querier, s *domain.Subscription) error {
+	if err := compareAndSwap(q, s); err != nil {
+		return err
+	}
+
+	// Digest transfers are deleted along with the digest
+	for _, table := range []string{"subscription_filters", "subscription_digests", "applied_transfers"} {
+		if _, err := q.Exec(fmt.Sprintf(`DELETE FROM %s WHERE subscription_id = ?`, table), s.ID()); err != nil {
+			return err
+		}
+	}
+
+	for i, f := range s.Filters() {
+		condition, err := f.SerializeCondition()
+		if err != nil {
+			return err
+		}
+
+		if _, err := q.Exec(
+			`INSERT INTO subscription_filters (subscription_id, position, type, condition, is_must) VALUES (?, ?, ?, ?, ?)`,
+			s.ID(), i, string(f.Type()), string(condition), f.IsMust(),
+		); err != nil {
+			return err
+		}
+	}
+
+	if d := s.Digest(); d != nil {
+		if _, err := q.Exec(
+			`INSERT INTO subscription_digests (subscription_id, schedule, time_zone, last_sent_at) VALUES (?, ?, ?, ?)`,
+			s.ID(), d.Schedule(), d.TimeZone(), d.LastSentAt().Format(time.RFC3339Nano),
+		); err != nil {
+			return err
+		}
+
+		for i, t := range d.Transfers() {
+			if _, err := q.Exec(
+				`INSERT INTO digest_transfers
+					(subscription_id, position, type, address, amount, block_height, timestamp, tx_hash, tx_index)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				s.ID(), i, t.Type, t.Address, t.Amount.String(), int64(t.BlockHeight), int64(t.Timestamp), t.TxHash, int64(t.Index),
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for id, bh := range s.AppliedTransfers() {
+		if _, err := q.Exec(
+			`INSERT INTO applied_transfers (subscription_id, transfer_id, block_height) VALUES (?, ?, ?)`,
+			s.ID(), id, int64(bh),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Loads the filters, digests and applied transfers of the given subscriptions
+func loadChildren(q querier, subs []*Subscription) error {
+	byID := make(map[string]*Subscription, len(subs))
+	ids := make([]interface{}, 0, len(subs))
+	for _, s := range subs {
+		byID[s.ID] = s
+		ids = append(ids, s.ID)
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	err := scan(q, fmt.Sprintf(`SELECT subscription_id, type, condition, is_must FROM subscription_filters
+		WHERE subscription_id IN (%s) ORDER BY subscription_id, position`, in), ids, func(rows *sql.Rows) error {
+		var id string
+		f := Filter{}
+		if err := rows.Scan(&id, &f.Type, &f.Condition, &f.IsMust); err != nil {
+			return err
+		}
+		byID[id].Filters = append(byID[id].Filters, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = scan(q, fmt.Sprintf(`SELECT subscription_id, schedule, time_zone, last_sent_at FROM subscription_digests
+		WHERE subscription_id IN (%s)`, in), ids, func(rows *sql.Rows) error {
+		var id, lastSentAt string
+		d := &Digest{}
+		if err := rows.Scan(&id, &d.Schedule, &d.TimeZone, &lastSentAt); err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339Nano, lastSentAt)
+		if err != nil {
+			return err
+		}
+		d.LastSentAt = t
+		byID[id].Digest = d
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = scan(q, fmt.Sprintf(`SELECT subscription_id, type, address, amount, block_height, timestamp, tx_hash, tx_index
+		FROM digest_transfers WHERE subscription_id IN (%s) ORDER BY subscription_id, position`, in), ids, func(rows *sql.Rows) error {
+		var id string
+		var bh, ts, index int64
+		t := Transfer{}
+		if err := rows.Scan(&id, &t.Type, &t.Address, &t.Amount, &bh, &ts, &t.TxHash, &index); err != nil {
+			return err
+		}
+		t.BlockHeight, t.Timestamp, t.Index = uint64(bh), uint64(ts), uint(index)
+		if d := byID[id].Digest; d != nil {
+			d.Transfers = append(d.Transfers, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return scan(q, fmt.Sprintf(`SELECT subscription_id, transfer_id, block_height FROM applied_transfers
+		WHERE subscription_id IN (%s)`, in), ids, func(rows *sql.Rows) error {
+		var id, transferID string
+		var bh int64
+		if err := rows.Scan(&id, &transferID, &bh); err != nil {
+			return err
+		}
+		byID[id].AppliedTransfers[transferID] = uint64(bh)
+		return nil
+	})
+}
+
+func scan(q querier, query string, args []interface{}, scanRow func(rows *sql.Rows) error) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scanRow(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Appends the connection options to the given file path or URI
+func dataSourceName(uri string) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + connectionOptions
+	}
+	return uri + "?" + connectionOptions
+}
+
+// Subscription represents a row in SQLite corresponding to domain.Subscription
+// along with the rows of its filters, digest and applied transfers
+type Subscription struct {
+	ID                  string
+	UserID              string
+	Currency            string
+	CurrencyDecimal     string
+	Account             string
+	BlockHeight         uint64
+	TotalReceived       string
+	TotalSpent          string
+	StartingBlockHeight uint64
+	Filters             []Filter
+	Digest              *Digest
+	AppliedTransfers    map[string]uint64
+	Version             uint64
+}
+
+// Filter represents a row in SQLite corresponding to domain.Filter
+type Filter struct {
+	Condition string
+	IsMust    bool
+	Type      string
+}
+
+// Digest represents a row in SQLite corresponding to domain.Digest
+type Digest struct {
+	Schedule   string
+	TimeZone   string
+	LastSentAt time.Time
+	Transfers  []Transfer
+}
+
+// Transfer represents a row in SQLite corresponding to domain.Transfer
+type Transfer struct {
+	Type        int
+	Address     string
+	Amount      string
+	BlockHeight uint64
+	Timestamp   uint64
+	TxHash      string
+	Index       uint
+}
+
+// ToDomain converts SQLite representation of Subscription to domain model
+func ToDomain(s *Subscription) (*domain.Subscription, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	totalReceived, ok := new(big.Int).SetString(s.TotalReceived, 10)
+	if !ok {
+		return nil, fmt.Errorf("TotalReceived (%s) is not a valid bignumber representation", s.TotalReceived)
+	}
+
+	totalSpent, ok := new(big.Int).SetString(s.TotalSpent, 10)
+	if !ok {
+		return nil, fmt.Errorf("TotalSpent (%s) is not a valid bignumber representation", s.TotalSpent)
+	}
+
+	decimal, ok := new(big.Int).SetString(s.CurrencyDecimal, 10)
+	if !ok {
+		return nil, fmt.Errorf("CurrencyDecimal (%s) is not a valid bignumber representation", s.CurrencyDecimal)
+	}
+
+	filters := []*domain.Filter{}
+	for _, f := range s.Filters {
+		filter := domain.NewFilter(domain.FilterType(f.Type), nil, f.IsMust)
+		if err := filter.DeserializeCondition([]byte(f.Condition)); err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	var digest *domain.Digest
+	if s.Digest != nil {
+		transfers := []*domain.Transfer{}
+		for _, t := range s.Digest.Transfers {
+			amount, ok := new(big.Int).SetString(t.Amount, 10)
+			if !ok {
+				return nil, fmt.Errorf("Transfer.Amount (%s) is not a valid bignumber representation", t.Amount)
+			}
+
+			transfers = append(transfers, &domain.Transfer{
+				Type:        t.Type,
+				Address:     t.Address,
+				Amount:      amount,
+				BlockHeight: t.BlockHeight,
+				Timestamp:   t.Timestamp,
+				TxHash:      t.TxHash,
+				Index:       t.Index,
+			})
+		}
+
+		digest = domain.DeepCopyDigest(s.Digest.Schedule, s.Digest.TimeZone, s.Digest.LastSentAt, transfers)
+	}
+
+	return domain.DeepCopySubscription(
+		s.ID,
+		s.UserID,
+		s.Account,
+		domain.Currency{
+			Symbol:  s.Currency,
+			Decimal: decimal,
+		},
+		filters,
+		totalReceived,
+		totalSpent,
+		s.BlockHeight,
+		s.StartingBlockHeight,
+		digest,
+		s.AppliedTransfers,
+		s.Version,
+	)
+}
+
+// ToDomainSlice converts slice of SQLite representations to slice of domain models
+func ToDomainSlice(subs []*Subscription) ([]*domain.Subscription, error) {
+	domainSlice := make([]*domain.Subscription, len(subs))
+	for i, s := range subs {
+		d, err := ToDomain(s)
+		if err != nil {
+			return nil, err
+		}
+		domainSlice[i] = d
+	}
+
+	return domainSlice, nil
+}
+
+// Tells whether the given error is caused by another connection writing at the same time,
+// e.g. a transaction cannot take the write lock after reading a snapshot which is outdated
+func isConflict(err error) bool {
+	var e *sqlite.Error
+	return errors.As(err, &e) && e.Code()&0xff == sqlite3.SQLITE_BUSY
+}
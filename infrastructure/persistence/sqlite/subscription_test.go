@@ -0,0 +1,444 @@
+package sqlite_test
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/persistence/sqlite"
+)
+
+var testSubs = []*domain.Subscription{}
+
+func TestSubscriptionRepository_NextIdentity(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	if id := r.NextIdentity("user1"); id == "" {
+		t.Fatalf("expected non-empty string, but got empty one")
+	}
+}
+
+func TestSubscriptionRepository_Size(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	if r.Size() != int64(len(testSubs)) {
+		t.Fatalf("expected size %d, but got %d", len(testSubs), r.Size())
+	}
+}
+
+func TestSubscriptionRepository_Get(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	testItem := testSubs[0]
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := r.Get(testItem.ID())
+	r.Success()
+
+	if s.ID() != testItem.ID() || s.UserID() != testItem.UserID() {
+		t.Fatalf("expected (ID, UserID) (%s, %s), but got (%s, %s)",
+			testItem.ID(), testItem.UserID(),
+			s.ID(), s.UserID(),
+		)
+	}
+
+	if len(s.Filters()) != len(testItem.Filters()) {
+		t.Fatalf("expected %d filters, but got %d", len(testItem.Filters()), len(s.Filters()))
+	}
+
+	if s.TotalReceived().Cmp(testItem.TotalReceived()) != 0 || s.Currency().Decimal.Cmp(testItem.Currency().Decimal) != 0 {
+		t.Fatalf("expected (TotalReceived, Decimal) (%s, %s), but got (%s, %s)",
+			testItem.TotalReceived(), testItem.Currency().Decimal,
+			s.TotalReceived(), s.Currency().Decimal,
+		)
+	}
+
+	if s.Digest() == nil || len(s.Digest().Transfers()) != len(testItem.Digest().Transfers()) {
+		t.Fatalf("expected a digest with %d transfers, but got %#v", len(testItem.Digest().Transfers()), s.Digest())
+	}
+
+	if len(s.AppliedTransfers()) != len(testItem.AppliedTransfers()) {
+		t.Fatalf("expected %d applied transfers, but got %d", len(testItem.AppliedTransfers()), len(s.AppliedTransfers()))
+	}
+
+	if s, err := r.Get("user1:unknown"); err != nil || s != nil {
+		t.Fatalf("expected subscription item nil, but got %#v, err: %v", s, err)
+	}
+}
+
+func TestSubscriptionRepository_GetAllForUser(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	testUser := testSubs[0].UserID()
+	expectedCount := 2
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := r.GetAllForUser(testUser)
+	r.Success()
+
+	if len(subs) != expectedCount {
+		t.Fatalf("expected size %d, but got %d", expectedCount, len(subs))
+	}
+}
+
+func TestSubscriptionRepository_GetAllForCurrency(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	expectedCount := 1
+	sinceBlockHeight := uint64(10)
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := r.GetAllForCurrency("eth", sinceBlockHeight)
+	r.Success()
+
+	if len(subs) != expectedCount {
+		t.Fatalf("expected size %d, but got %d", expectedCount, len(subs))
+	}
+
+	expectedCount = 2
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	subs, _ = r.GetAllForCurrency("btc", sinceBlockHeight)
+	r.Success()
+
+	if len(subs) != expectedCount {
+		t.Fatalf("expected size %d, but got %d", expectedCount, len(subs))
+	}
+}
+
+func TestSubscriptionRepository_GetAllForAccount(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	testSub := testSubs[0]
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := r.GetAllForAccount(testSub.Currency().Symbol, testSub.Account())
+	r.Success()
+
+	if len(subs) != 1 || subs[0].ID() != testSub.ID() {
+		t.Fatalf("expected only subscription %s, but got %d subscriptions", testSub.ID(), len(subs))
+	}
+}
+
+func TestSubscriptionRepository_GetAllWithDigest(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	subs, err := r.GetAllWithDigest("eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subs) != 1 || subs[0].ID() != testSubs[0].ID() {
+		t.Fatalf("expected only %s in digest mode, but got %d subscriptions", testSubs[0].ID(), len(subs))
+	}
+}
+
+func TestSubscriptionRepository_Save(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	expectedSize := r.Size() + 1
+	testItem, _ := domain.NewSubscription(
+		r.NextIdentity("user3"),
+		"user3",
+		"account-6",
+		domain.Currency{Decimal: big.NewInt(1000)},
+		0,
+	)
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	r.Save(testItem)
+	r.Success()
+
+	if r.Size() != expectedSize {
+		t.Fatalf("expected size %d, but got %d", expectedSize, r.Size())
+	}
+
+	s, _ := r.Get(testItem.ID())
+
+	if s.ID() != testItem.ID() || s.UserID() != testItem.UserID() {
+		t.Fatalf("expected (ID, UserID) (%s, %s), but got (%s, %s)",
+			testItem.ID(), testItem.UserID(),
+			s.ID(), s.UserID(),
+		)
+	}
+
+	// Updating replaces the filters and the digest
+	s.RemoveFilters()
+	s.DisableDigest()
+	if err := r.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, _ = r.Get(testItem.ID()); len(s.Filters()) != 0 || s.Digest() != nil {
+		t.Fatalf("expected no filters and no digest, but got %d filters and %#v", len(s.Filters()), s.Digest())
+	}
+}
+
+func TestSubscriptionRepository_SaveConcurrentModification(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	testItem := testSubs[1]
+	s, _ := r.Get(testItem.ID())
+	stale, _ := r.Get(testItem.ID())
+
+	if err := r.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(stale); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v, but got %v", domain.ErrConcurrentModification, err)
+	}
+
+	// The latest one is saved again after the conflict
+	s, _ = r.Get(testItem.ID())
+	if err := r.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Remove(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(s); err != domain.ErrConcurrentModification {
+		t.Fatalf("expected %v for a removed subscription, but got %v", domain.ErrConcurrentModification, err)
+	}
+}
+
+func TestSubscriptionRepository_Remove(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	expectedSize := r.Size() - 1
+	testItem := testSubs[0]
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	r.Remove(testItem)
+	r.Success()
+
+	if r.Size() != expectedSize {
+		t.Fatalf("expected size %d, but got %d", expectedSize, r.Size())
+	}
+
+	s, _ := r.Get(testItem.ID())
+
+	if s != nil {
+		t.Fatalf("expected subscription item nil, but got %#v", s)
+	}
+
+	if err := r.Remove(testItem); err == nil {
+		t.Fatal("expected an error for a removed subscription but got nothing")
+	}
+}
+
+func TestSubscriptionRepository_Fail(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	expectedSize := r.Size()
+	testItem, _ := domain.NewSubscription(r.NextIdentity("user3"), "user3", "account-7", domain.Currency{Decimal: big.NewInt(1000)}, 0)
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	r.Save(testItem)
+	r.Remove(testSubs[0])
+	r.Fail()
+
+	// The version is not incremented for the rolled back save
+	if testItem.Version() != 0 {
+		t.Fatalf("expected version %d after rollback, but got %d", 0, testItem.Version())
+	}
+
+	if r.Size() != expectedSize {
+		t.Fatalf("expected size %d after rollback, but got %d", expectedSize, r.Size())
+	}
+
+	if s, _ := r.Get(testSubs[0].ID()); s == nil {
+		t.Fatal("expected the removed subscription to be restored, but got nil")
+	}
+}
+
+func TestSubscriptionRepository_Concurrent(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	expectedSize := r.Size() + 10
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s, _ := domain.NewSubscription(r.NextIdentity("user4"), "user4", "account", domain.Currency{Symbol: "eth", Decimal: big.NewInt(1000)}, 0)
+			if err := r.Begin(); err != nil {
+				errs <- err
+				return
+			}
+			if err := r.Save(s); err != nil {
+				r.Fail()
+				errs <- err
+				return
+			}
+			r.Success()
+		}()
+		go func() {
+			defer wg.Done()
+			if err := r.Begin(); err != nil {
+				errs <- err
+				return
+			}
+			defer r.Success()
+			if _, err := r.GetAllForCurrency("eth", 100); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if r.Size() != expectedSize {
+		t.Fatalf("expected size %d, but got %d", expectedSize, r.Size())
+	}
+}
+
+func TestSubscriptionRepository_ScanCursor(t *testing.T) {
+	r, cleanUp := helperCreateAndPopulateDB(t)
+	defer cleanUp()
+
+	if err := r.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Success()
+
+	if _, exist, err := r.GetScanCursor("eth"); err != nil || exist {
+		t.Fatalf("expected no scan cursor for eth but got one, err: %v", err)
+	}
+
+	for _, bh := range []uint64{100, 101} {
+		if err := r.SaveScanCursor("eth", bh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bh, exist, err := r.GetScanCursor("eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exist || bh != 101 {
+		t.Fatalf("expected scan cursor at %d but got %d", 101, bh)
+	}
+}
+
+func helperTestData(t *testing.T) []*domain.Subscription {
+	eth := domain.Currency{Symbol: "eth", Decimal: big.NewInt(1000000000000000000)}
+	btc := domain.Currency{Symbol: "btc", Decimal: big.NewInt(100000000)}
+
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	filters := []*domain.Filter{}
+	for _, f := range []func() (*domain.Filter, error){
+		func() (*domain.Filter, error) { return domain.NewAmountFilter("5", false) },
+		func() (*domain.Filter, error) { return domain.NewAddressOnFilter("address-1", true) },
+		func() (*domain.Filter, error) { return domain.NewAddressOffFilter("address-2", true) },
+	} {
+		filter, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, filter)
+	}
+
+	digest := domain.DeepCopyDigest("daily", "UTC", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), []*domain.Transfer{
+		{Type: domain.Received, Address: "address-1", Amount: amount, BlockHeight: 9, Timestamp: 1609459200, TxHash: "tx-1"},
+	})
+
+	subs := []struct {
+		id, userID, account string
+		c                   domain.Currency
+		filters             []*domain.Filter
+		received            *big.Int
+		bh                  uint64
+		digest              *domain.Digest
+		applied             map[string]uint64
+	}{
+		{"user1:36e7e52f-8430-47a7-a841-0cdb2d77b4f0", "user1", "account1", eth, filters, amount, 10, digest, map[string]uint64{"tx-1:0": 9}},
+		{"user1:36e7e52f-8430-47a7-a841-0cdb2d77b4f1", "user1", "account2", eth, nil, big.NewInt(0), 20, nil, nil},
+		{"user2:36e7e52f-8430-47a7-a841-0cdb2d77b4f2", "user2", "account3", eth, nil, big.NewInt(0), 5, nil, nil},
+		{"user2:36e7e52f-8430-47a7-a841-0cdb2d77b4f3", "user2", "account4", btc, nil, big.NewInt(0), 0, nil, nil},
+		{"user3:36e7e52f-8430-47a7-a841-0cdb2d77b4f4", "user3", "account5", btc, nil, big.NewInt(0), 9, nil, nil},
+	}
+
+	td := []*domain.Subscription{}
+	for _, s := range subs {
+		d, err := domain.DeepCopySubscription(s.id, s.userID, s.account, s.c, s.filters, s.received, big.NewInt(0), s.bh, 0, s.digest, s.applied, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		td = append(td, d)
+	}
+
+	return td
+}
+
+func helperCreateAndPopulateDB(t *testing.T) (*sqlite.SubscriptionRepository, func()) {
+	dir, err := ioutil.TempDir("", "cryptobalancebot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connecting creates the database file and its tables
+	r := sqlite.NewSubscriptionRepository()
+	if err := r.Connect(filepath.Join(dir, "test.db"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	testSubs = helperTestData(t)
+	for _, s := range testSubs {
+		if err := r.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Return a function for clean-up
+	dbCleanUp := func() {
+		r.Disconnect()
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r, dbCleanUp
+}
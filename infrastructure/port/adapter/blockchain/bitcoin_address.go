@@ -0,0 +1,163 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Checksum constants of bech32 and bech32m encodings
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// Human-readable parts of segwit addresses for mainnet, testnet and regtest
+var segwitHRPs = map[string]bool{
+	"bc":   true,
+	"tb":   true,
+	"bcrt": true,
+}
+
+// Legacy address version bytes for mainnet and testnet
+var (
+	p2pkhVersions = map[byte]bool{0x00: true, 0x6f: true}
+	p2shVersions  = map[byte]bool{0x05: true, 0xc4: true}
+)
+
+// Script opcodes used by the standard output scripts
+const (
+	opDup         = 0x76
+	opHash160     = 0xa9
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opCheckSig    = 0xac
+	opData20      = 0x14
+)
+
+// BitcoinAddressToScript converts the given Bitcoin address to its output script(scriptPubKey).
+// Legacy P2PKH/P2SH and segwit(bech32/bech32m) addresses are supported
+func BitcoinAddressToScript(address string) ([]byte, error) {
+	address = strings.TrimSpace(address)
+
+	if i := strings.LastIndexByte(address, '1'); i > 0 && segwitHRPs[strings.ToLower(address[:i])] {
+		return segwitAddressToScript(address)
+	}
+
+	decoded, err := decodeBase58Check(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != 21 {
+		return nil, fmt.Errorf("invalid legacy address(%s), unexpected length", address)
+	}
+
+	switch {
+	case p2pkhVersions[decoded[0]]:
+		script := append([]byte{opDup, opHash160, opData20}, decoded[1:]...)
+		return append(script, opEqualVerify, opCheckSig), nil
+	case p2shVersions[decoded[0]]:
+		script := append([]byte{opHash160, opData20}, decoded[1:]...)
+		return append(script, opEqual), nil
+	default:
+		return nil, fmt.Errorf("invalid legacy address(%s), unsupported version(%d)", address, decoded[0])
+	}
+}
+
+// BitcoinAddressToScriptHash converts the given Bitcoin address to the script hash
+// used by Electrum protocol, which is the reversed sha256 of the output script in hex
+func BitcoinAddressToScriptHash(address string) (string, error) {
+	script, err := BitcoinAddressToScript(address)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(script)
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+// For further info: https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+// and https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+func segwitAddressToScript(address string) ([]byte, error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return nil, fmt.Errorf("invalid segwit address(%s), mixed case", address)
+	}
+	lower := strings.ToLower(address)
+
+	sep := strings.LastIndexByte(lower, '1')
+	hrp, data := lower[:sep], lower[sep+1:]
+	if len(data) < 7 {
+		return nil, fmt.Errorf("invalid segwit address(%s), too short", address)
+	}
+
+	values := make([]byte, len(data))
+	for i, c := range data {
+		v := strings.IndexRune(cashAddrCharset, c)
+		if v < 0 {
+			return nil, fmt.Errorf("invalid segwit address(%s), unexpected character(%c)", address, c)
+		}
+		values[i] = byte(v)
+	}
+
+	checksum := bech32Polymod(append(bech32HRPExpand(hrp), values...))
+	version := values[0]
+	if (version == 0 && checksum != bech32Const) || (version != 0 && checksum != bech32mConst) {
+		return nil, fmt.Errorf("invalid segwit address(%s), checksum mismatch", address)
+	}
+
+	if version > 16 {
+		return nil, fmt.Errorf("invalid segwit address(%s), unsupported witness version(%d)", address, version)
+	}
+
+	program, err := convertBits(values[1:len(values)-6], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid segwit address(%s), %s", address, err.Error())
+	}
+
+	if len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return nil, fmt.Errorf("invalid segwit address(%s), unexpected program length(%d)", address, len(program))
+	}
+
+	// OP_0 or OP_1..OP_16 followed by the push of the witness program
+	op := byte(0)
+	if version > 0 {
+		op = 0x50 + version
+	}
+
+	return append([]byte{op, byte(len(program))}, program...), nil
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	values := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&0x1f)
+	}
+	return values
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generators := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generators {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+
+	return chk
+}
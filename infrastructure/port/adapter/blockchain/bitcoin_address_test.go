@@ -0,0 +1,63 @@
+package blockchain_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// Test vectors from BIP-173 and BIP-350
+func TestBitcoinAddressToScript(t *testing.T) {
+	cases := map[string]string{
+		"1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu":                             "76a91476a04053bda0a88bda5177b86a15c3b29f55987388ac",
+		"3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC":                             "a91476a04053bda0a88bda5177b86a15c3b29f55987387",
+		"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4":                     "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3": "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0": "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+	}
+
+	for address, expected := range cases {
+		script, err := blockchain.BitcoinAddressToScript(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := hex.EncodeToString(script); s != expected {
+			t.Fatalf("expected script of %s is %s but got %s", address, expected, s)
+		}
+	}
+}
+
+func TestBitcoinAddressToScript_Invalid(t *testing.T) {
+	addresses := []string{
+		"",
+		"1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggv",
+		// Invalid checksums
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj1",
+		// Witness version 0 with bech32m checksum
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",
+		// Mixed case
+		"bc1qW508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+	}
+
+	for _, address := range addresses {
+		if _, err := blockchain.BitcoinAddressToScript(address); err == nil {
+			t.Fatalf("expected an error for address \"%s\" but got nothing", address)
+		}
+	}
+}
+
+// Test vector from Electrum protocol documentation
+func TestBitcoinAddressToScriptHash(t *testing.T) {
+	sh, err := blockchain.BitcoinAddressToScriptHash("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "8b01df4e368ea28f8dc0423bcf7a4923e3a12d307c875e47a0cfbf90b5c39161"
+	if sh != expected {
+		t.Fatalf("expected script hash is %s but got %s", expected, sh)
+	}
+}
@@ -0,0 +1,238 @@
+package bitcoind
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// Max. number of blocks to be scanned in one call. A larger range is scanned
+// in more calls, each going on from the scanned height of the previous one
+const maxScanRange = 1000
+
+// ScriptPubKey is a data structure returning from Bitcoin Core JSON-RPC
+type ScriptPubKey struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	// Addresses is returned by the versions before v22
+	Addresses []string `json:"addresses"`
+}
+
+// Output is a data structure returning from Bitcoin Core JSON-RPC
+type Output struct {
+	Value        json.Number  `json:"value"`
+	Index        uint         `json:"n"`
+	ScriptPubKey ScriptPubKey `json:"scriptPubKey"`
+}
+
+// Input is a data structure returning from Bitcoin Core JSON-RPC.
+// Prevout is the output spent by this input which is returned by getblock
+// with verbosity 3 since v23, or otherwise resolved by API
+type Input struct {
+	TxID     string  `json:"txid"`
+	Vout     uint    `json:"vout"`
+	Coinbase string  `json:"coinbase"`
+	Prevout  *Output `json:"prevout,omitempty"`
+}
+
+// Transaction is a data structure returning from Bitcoin Core JSON-RPC.
+// BlockHeight and BlockTime are filled by API from the containing block
+type Transaction struct {
+	TxID        string   `json:"txid"`
+	Inputs      []Input  `json:"vin"`
+	Outputs     []Output `json:"vout"`
+	BlockHeight uint64   `json:"-"`
+	BlockTime   uint64   `json:"-"`
+}
+
+// Block is a data structure returning from Bitcoin Core JSON-RPC's getblock with verbosity 2
+type Block struct {
+	Hash         string        `json:"hash"`
+	Height       uint64        `json:"height"`
+	Time         uint64        `json:"time"`
+	Transactions []Transaction `json:"tx"`
+}
+
+// API implements CurrencyAPI and BlockScanner for Bitcoin Core JSON-RPC. Previous outputs of the
+// inputs are returned along with the blocks since v23. For the older versions, they are resolved
+// with getrawtransaction, so bitcoind must run with -txindex. Scanning each block once for all the
+// accounts by the block observer is preferred to scanning the blocks once for each account
+type API struct {
+	rpc *net.JSONRPCClient
+	t   blockchain.Translator
+}
+
+// NewAPI creates a new instance of API for the given RPC url and credentials
+func NewAPI(url string, username string, password string, t blockchain.Translator) *API {
+	return &API{
+		rpc: net.NewJSONRPCClient(url, username, password),
+		t:   t,
+	}
+}
+
+// GetAccountMovements scans at most maxScanRange blocks since the given block height up to the
+// latest block and collects the txs related to the given address. The returned movements tell
+// the scanned height which the next call goes on from
+func (a *API) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	latest, err := a.GetLatestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	if sinceBlockHeight > latest {
+		return domain.NewAccountMovements(address), nil
+	}
+
+	to := latest
+	if latest-sinceBlockHeight >= maxScanRange {
+		to = sinceBlockHeight + maxScanRange - 1
+	}
+
+	txs := []Transaction{}
+	for h := sinceBlockHeight; h <= to; h++ {
+		b, err := a.fetchBlock(h)
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, b.Transactions...)
+	}
+
+	am, err := a.t.ToAccountMovements(address, txs)
+	if err != nil {
+		return nil, err
+	}
+
+	am.ScannedHeight = to
+	return am, nil
+}
+
+// NormalizeAddress returns the form of the given address which the addresses in blocks are matched in
+func (a *API) NormalizeAddress(address string) (string, error) {
+	return strings.TrimSpace(address), nil
+}
+
+// GetBlockMovements fetches txs in the block at the given height and converts
+// them to the account movements of the watched addresses involved in them
+func (a *API) GetBlockMovements(blockHeight uint64, watched func(address string) bool) ([]*domain.AccountMovements, error) {
+	b, err := a.fetchBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	// Txs of each watched address in the order of their first appearance in the block
+	addresses := make([]string, 0)
+	txsOf := make(map[string][]Transaction)
+	for _, tx := range b.Transactions {
+		for _, address := range watchedAddressesOf(tx, watched) {
+			if _, exist := txsOf[address]; !exist {
+				addresses = append(addresses, address)
+			}
+			txsOf[address] = append(txsOf[address], tx)
+		}
+	}
+
+	acms := make([]*domain.AccountMovements, 0, len(addresses))
+	for _, address := range addresses {
+		am, err := a.t.ToAccountMovements(address, txsOf[address])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(am.Transfers) > 0 {
+			acms = append(acms, am)
+		}
+	}
+
+	return acms, nil
+}
+
+// GetLatestBlockHeight fetches the latest block number
+func (a *API) GetLatestBlockHeight() (uint64, error) {
+	var height uint64
+	if err := a.rpc.Call(&height, "getblockcount"); err != nil {
+		return 0, err
+	}
+
+	return height, nil
+}
+
+// RPC calls to getblockhash and getblock with verbosity 3, which the versions before v23 take as 2
+// For further info: https://developer.bitcoin.org/reference/rpc/getblock.html
+func (a *API) fetchBlock(height uint64) (*Block, error) {
+	var hash string
+	if err := a.rpc.Call(&hash, "getblockhash", height); err != nil {
+		return nil, err
+	}
+
+	b := &Block{}
+	if err := a.rpc.Call(b, "getblock", hash, 3); err != nil {
+		return nil, err
+	}
+
+	for i := range b.Transactions {
+		tx := &b.Transactions[i]
+		tx.BlockHeight = b.Height
+		tx.BlockTime = b.Time
+		if err := a.resolvePrevouts(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// RPC call to getrawtransaction for each non-coinbase input which is returned without its previous output
+// For further info: https://developer.bitcoin.org/reference/rpc/getrawtransaction.html
+func (a *API) resolvePrevouts(tx *Transaction) error {
+	for i, in := range tx.Inputs {
+		if in.Coinbase != "" || in.Prevout != nil {
+			continue
+		}
+
+		prev := &Transaction{}
+		if err := a.rpc.Call(prev, "getrawtransaction", in.TxID, true); err != nil {
+			return fmt.Errorf("cannot resolve input#%d of tx(%s), %w", i, tx.TxID, err)
+		}
+
+		if int(in.Vout) >= len(prev.Outputs) {
+			return fmt.Errorf("cannot resolve input#%d of tx(%s), tx(%s) has no output#%d", i, tx.TxID, in.TxID, in.Vout)
+		}
+
+		tx.Inputs[i].Prevout = &prev.Outputs[in.Vout]
+	}
+
+	return nil
+}
+
+// Returns the watched addresses of the inputs and the outputs of the given tx, each of them only once
+func watchedAddressesOf(tx Transaction, watched func(address string) bool) []string {
+	found := make(map[string]bool)
+	addresses := make([]string, 0)
+
+	check := func(out *Output) {
+		// Outputs with non-standard scripts have no address and never match
+		address := addressOf(out)
+		if address == "" || found[address] || !watched(address) {
+			return
+		}
+
+		found[address] = true
+		addresses = append(addresses, address)
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Prevout != nil {
+			check(in.Prevout)
+		}
+	}
+	for i := range tx.Outputs {
+		check(&tx.Outputs[i])
+	}
+
+	return addresses
+}
@@ -0,0 +1,317 @@
+package bitcoind_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/bitcoind"
+)
+
+const (
+	rpcUser     = "test-user"
+	rpcPassword = "test-password"
+	addr1       = "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+	addr2       = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+)
+
+var blocks = map[string]string{
+	"hash-100": `{
+		"hash": "hash-100", "height": 100, "time": 1610503881,
+		"tx": [
+			{
+				"txid": "tx-coinbase",
+				"vin": [{"coinbase": "0364", "sequence": 4294967295}],
+				"vout": [{"value": 6.25000000, "n": 0, "scriptPubKey": {"type": "witness_v0_keyhash", "address": "` + addr1 + `"}}]
+			},
+			{
+				"txid": "tx-a",
+				"vin": [{"txid": "tx-prev", "vout": 1}],
+				"vout": [
+					{"value": 0.3, "n": 0, "scriptPubKey": {"type": "pubkeyhash", "address": "` + addr2 + `"}},
+					{"value": 0.1999, "n": 1, "scriptPubKey": {"type": "witness_v0_keyhash", "address": "` + addr1 + `"}}
+				]
+			}
+		]
+	}`,
+	// Since v23, previous outputs are returned along with the blocks
+	"hash-102": `{
+		"hash": "hash-102", "height": 102, "time": 1610505081,
+		"tx": [
+			{
+				"txid": "tx-c",
+				"vin": [{"txid": "tx-b", "vout": 0, "prevout": {"generated": false, "height": 101, "value": 0.1998, "scriptPubKey": {"type": "pubkeyhash", "address": "` + addr2 + `"}}}],
+				"vout": [{"value": 0.1997, "n": 0, "scriptPubKey": {"type": "witness_v0_keyhash", "address": "` + addr1 + `"}}]
+			}
+		]
+	}`,
+	"hash-101": `{
+		"hash": "hash-101", "height": 101, "time": 1610504481,
+		"tx": [
+			{
+				"txid": "tx-b",
+				"vin": [{"txid": "tx-a", "vout": 1}],
+				"vout": [
+					{"value": 0.1998, "n": 0, "scriptPubKey": {"type": "pubkeyhash", "addresses": ["` + addr2 + `"]}},
+					{"value": 0, "n": 1, "scriptPubKey": {"type": "nulldata"}}
+				]
+			}
+		]
+	}`,
+}
+
+var rawTxs = map[string]string{
+	"tx-prev": `{
+		"txid": "tx-prev",
+		"vin": [{"txid": "tx-prev-prev", "vout": 0}],
+		"vout": [
+			{"value": 1.2, "n": 0, "scriptPubKey": {"type": "pubkeyhash", "address": "` + addr2 + `"}},
+			{"value": 0.5, "n": 1, "scriptPubKey": {"type": "witness_v0_keyhash", "address": "` + addr1 + `"}}
+		]
+	}`,
+}
+
+type rpcRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// Stub for bitcoind's JSON-RPC server. Counts the calls per method
+func newStubServer(t *testing.T, calls map[string]int) *httptest.Server {
+	return newStubServerAt(t, calls, 101)
+}
+
+// Stub for bitcoind's JSON-RPC server whose blocks up to the given latest one are empty
+// unless they are in blocks. Counts the calls per method
+func newStubServerAt(t *testing.T, calls map[string]int, latest uint64) *httptest.Server {
+	rawTxs["tx-a"] = extractTx(t, blocks["hash-100"], 1)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != rpcUser || p != rpcPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		req := &rpcRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		calls[req.Method]++
+
+		var result string
+		switch req.Method {
+		case "getblockcount":
+			result = strconv.FormatUint(latest, 10)
+		case "getblockhash":
+			result = `"hash-` + string(req.Params[0]) + `"`
+		case "getblock":
+			var hash string
+			json.Unmarshal(req.Params[0], &hash)
+			result = blocks[hash]
+			if h, err := strconv.ParseUint(hash[len("hash-"):], 10, 64); result == "" && err == nil && h <= latest {
+				result = `{"hash": "` + hash + `", "height": ` + strconv.FormatUint(h, 10) + `, "time": 1610503881, "tx": []}`
+			}
+		case "getrawtransaction":
+			var txid string
+			json.Unmarshal(req.Params[0], &txid)
+			result = rawTxs[txid]
+		}
+
+		if result == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"result": null, "error": {"code": -5, "message": "not found"}, "id": 1}`))
+			return
+		}
+
+		w.Write([]byte(`{"result": ` + result + `, "error": null, "id": 1}`))
+	}))
+}
+
+func extractTx(t *testing.T, block string, index int) string {
+	b := struct {
+		Txs []json.RawMessage `json:"tx"`
+	}{}
+	if err := json.Unmarshal([]byte(block), &b); err != nil {
+		t.Fatal(err)
+	}
+	return string(b.Txs[index])
+}
+
+func TestGetLatestBlockHeight(t *testing.T) {
+	s := newStubServer(t, map[string]int{})
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	bh, err := api.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 101 {
+		t.Fatalf("expected latest block height is 101 but got %d", bh)
+	}
+}
+
+func TestGetAccountMovements(t *testing.T) {
+	calls := map[string]int{}
+	s := newStubServer(t, calls)
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	mv, err := api.GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*domain.Transfer{
+		{Type: domain.Received, Amount: big.NewInt(625000000), BlockHeight: 100, Timestamp: 1610503881, TxHash: "tx-coinbase", Index: 0},
+		{Type: domain.Spent, Amount: big.NewInt(50000000), BlockHeight: 100, Timestamp: 1610503881, TxHash: "tx-a", Index: 0},
+		{Type: domain.Received, Amount: big.NewInt(19990000), BlockHeight: 100, Timestamp: 1610503881, TxHash: "tx-a", Index: 1},
+		{Type: domain.Spent, Amount: big.NewInt(19990000), BlockHeight: 101, Timestamp: 1610504481, TxHash: "tx-b", Index: 0},
+	}
+
+	if len(mv.Transfers) != len(expected) {
+		t.Fatalf("expected transfer count is %d but got %d", len(expected), len(mv.Transfers))
+	}
+
+	for i, e := range expected {
+		tr := mv.Transfers[i]
+		if tr.Type != e.Type ||
+			tr.Amount.Cmp(e.Amount) != 0 ||
+			tr.BlockHeight != e.BlockHeight ||
+			tr.Timestamp != e.Timestamp ||
+			tr.TxHash != e.TxHash ||
+			tr.Index != e.Index {
+			t.Fatalf("expected transfer#%d is %+v but got %+v", i, e, tr)
+		}
+	}
+
+	// Previous output of each non-coinbase input is resolved once
+	if calls["getblock"] != 2 || calls["getrawtransaction"] != 2 {
+		t.Fatalf("expected 2 getblock and 2 getrawtransaction calls but got %v", calls)
+	}
+}
+
+func TestGetAccountMovements_UpToDate(t *testing.T) {
+	s := newStubServer(t, map[string]int{})
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	mv, err := api.GetAccountMovements(addr1, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != 0 {
+		t.Fatalf("expected no transfers but got %d", len(mv.Transfers))
+	}
+}
+
+func TestGetAccountMovements_ScanRange(t *testing.T) {
+	s := newStubServerAt(t, map[string]int{}, 2500)
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	mv, err := api.GetAccountMovements(addr1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// At most 1000 blocks are scanned in one call
+	if mv.ScannedHeight != 999 {
+		t.Fatalf("expected scanned height is %d but got %d", 999, mv.ScannedHeight)
+	}
+
+	if len(mv.Transfers) != 5 {
+		t.Fatalf("expected transfer count is %d but got %d", 5, len(mv.Transfers))
+	}
+
+	// Goes on from the scanned height even though nothing is found
+	mv, err = api.GetAccountMovements(addr1, mv.ScannedHeight+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mv.ScannedHeight != 1999 || len(mv.Transfers) != 0 {
+		t.Fatalf("expected no transfers up to %d but got %d up to %d", 1999, len(mv.Transfers), mv.ScannedHeight)
+	}
+}
+
+func TestGetAccountMovements_Prevouts(t *testing.T) {
+	calls := map[string]int{}
+	s := newStubServerAt(t, calls, 102)
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	mv, err := api.GetAccountMovements(addr2, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != 1 || mv.Transfers[0].Type != domain.Spent || mv.Transfers[0].Amount.Cmp(big.NewInt(19980000)) != 0 {
+		t.Fatalf("expected to spend %d but got %+v", 19980000, mv.Transfers)
+	}
+
+	// Previous outputs returned along with the block are not resolved again
+	if calls["getrawtransaction"] != 0 {
+		t.Fatalf("expected no getrawtransaction calls but got %d", calls["getrawtransaction"])
+	}
+}
+
+func TestGetBlockMovements(t *testing.T) {
+	s := newStubServer(t, map[string]int{})
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	watched, err := api.NormalizeAddress(" " + addr2 + " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acms, err := api.GetBlockMovements(100, func(address string) bool { return address == addr1 || address == watched })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Accounts in the order of their first appearance in the block
+	if len(acms) != 2 || acms[0].Address != addr1 || acms[1].Address != addr2 {
+		t.Fatalf("expected the movements of %s and %s but got %d accounts", addr1, addr2, len(acms))
+	}
+
+	if len(acms[0].Transfers) != 3 || len(acms[1].Transfers) != 1 {
+		t.Fatalf("expected 3 and 1 transfers but got %d and %d", len(acms[0].Transfers), len(acms[1].Transfers))
+	}
+}
+
+func TestGetAccountMovements_RPCError(t *testing.T) {
+	s := newStubServer(t, map[string]int{})
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, rpcPassword, bitcoind.BitcoinTranslator{})
+	prev := rawTxs["tx-prev"]
+	delete(rawTxs, "tx-prev")
+	defer func() { rawTxs["tx-prev"] = prev }()
+
+	_, err := api.GetAccountMovements(addr1, 100)
+	rpcErr := &net.RPCError{}
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -5 {
+		t.Fatalf("expected rpc error with code -5 but got %v", err)
+	}
+}
+
+func TestGetLatestBlockHeight_Unauthorized(t *testing.T) {
+	s := newStubServer(t, map[string]int{})
+	defer s.Close()
+
+	api := bitcoind.NewAPI(s.URL, rpcUser, "wrong-password", bitcoind.BitcoinTranslator{})
+	if _, err := api.GetLatestBlockHeight(); err == nil {
+		t.Fatal("expected an error for wrong credentials but got nothing")
+	}
+}
@@ -0,0 +1,80 @@
+package bitcoind
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// Number of satoshis in one bitcoin
+var satoshisPerBitcoin = big.NewRat(100000000, 1)
+
+// BitcoinTranslator is a translator for Bitcoin Core JSON-RPC. It serves the other
+// UTXO-based blockchains forked from Bitcoin Core such as Litecoin and Dogecoin
+type BitcoinTranslator struct{}
+
+// ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
+func (tr BitcoinTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
+	txs, _ := v.([]Transaction)
+	am := domain.NewAccountMovements(address)
+
+	for _, tx := range txs {
+		// Inputs will be reflected as a spent. Coinbase inputs have no previous output
+		for i, in := range tx.Inputs {
+			if in.Prevout == nil || addressOf(in.Prevout) != address {
+				continue
+			}
+
+			val, err := toSatoshis(in.Prevout.Value)
+			if err != nil {
+				return nil, fmt.Errorf("bitcoind translation error, %s", err.Error())
+			}
+			am.Spend(tx.BlockHeight, tx.BlockTime, tx.TxID, uint(i), val, "")
+		}
+
+		// Outputs will be reflected as a receive
+		for _, out := range tx.Outputs {
+			if addressOf(&out) != address {
+				continue
+			}
+
+			val, err := toSatoshis(out.Value)
+			if err != nil {
+				return nil, fmt.Errorf("bitcoind translation error, %s", err.Error())
+			}
+			am.Receive(tx.BlockHeight, tx.BlockTime, tx.TxID, out.Index, val, "")
+		}
+	}
+
+	return am, nil
+}
+
+// Outputs with non-standard scripts have no address
+func addressOf(out *Output) string {
+	if out.ScriptPubKey.Address != "" {
+		return out.ScriptPubKey.Address
+	}
+
+	if len(out.ScriptPubKey.Addresses) == 1 {
+		return out.ScriptPubKey.Addresses[0]
+	}
+
+	return ""
+}
+
+// Converts the given amount in bitcoins to satoshis without any precision loss
+func toSatoshis(amount json.Number) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(amount.String())
+	if !ok {
+		return nil, fmt.Errorf("cannot convert value(%s) to bigint", amount)
+	}
+
+	r.Mul(r, satoshisPerBitcoin)
+	if !r.IsInt() {
+		return nil, fmt.Errorf("value(%s) has more than 8 decimals", amount)
+	}
+
+	return new(big.Int).Set(r.Num()), nil
+}
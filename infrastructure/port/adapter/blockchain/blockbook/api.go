@@ -1,8 +1,8 @@
 package blockbook
 
 import (
+	"context"
 	"fmt"
-	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
@@ -109,17 +109,16 @@ type API struct {
 	hostURL     string
 	pagingLimit int
 	t           blockchain.Translator
+	c           *net.HTTPClient
 }
 
-// Delay between consecutive api requests, not to choking api provider
-const requestDelay = 200 * time.Millisecond
-
 // NewAPI creates a new instance of BitcoinAPI
 func NewAPI(hostURL string, t blockchain.Translator, pagingLimit ...*int) *API {
 	api := &API{
 		hostURL:     hostURL,
 		pagingLimit: defaultPagingLimit,
 		t:           t,
+		c:           net.NewHTTPClient(),
 	}
 
 	for _, pl := range pagingLimit {
@@ -131,6 +130,11 @@ func NewAPI(hostURL string, t blockchain.Translator, pagingLimit ...*int) *API {
 	return api
 }
 
+// SetHTTPClient sets the client making the requests to the provider, e.g. to send an api key
+func (a *API) SetHTTPClient(c *net.HTTPClient) {
+	a.c = c
+}
+
 // GetAccountMovements fetches txs of the given address since the given block height
 func (a *API) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
 	currPage := 1
@@ -171,10 +175,9 @@ func (a *API) GetLatestBlockHeight() (uint64, error) {
 // API call to blockbook's api/v2/address endpoint
 // For further info: https://github.com/trezor/blockbook/blob/master/docs/api.md#get-address
 func (a *API) fetchAddressTxs(address string, since uint64, page int) (*AddressTxs, error) {
-	defer time.Sleep(requestDelay)
 	url := fmt.Sprintf("%s/api/v2/address/%s?details=txs&page=%d&pageSize=%d&from=%d", a.hostURL, address, page, a.pagingLimit, since)
 	ad := &AddressTxs{}
-	if err := net.GetJSON(url, &ad); err != nil {
+	if err := a.c.GetJSON(context.Background(), url, &ad); err != nil {
 		return nil, err
 	}
 
@@ -184,10 +187,9 @@ func (a *API) fetchAddressTxs(address string, since uint64, page int) (*AddressT
 // API call to blockbook's api/v2 endpoint
 // For further info: https://github.com/trezor/blockbook/blob/master/docs/api.md#status
 func (a *API) fetchStatus() (*Status, error) {
-	defer time.Sleep(requestDelay)
 	url := fmt.Sprintf("%s/api/v2", a.hostURL)
 	s := &Status{}
-	if err := net.GetJSON(url, &s); err != nil {
+	if err := a.c.GetJSON(context.Background(), url, &s); err != nil {
 		return nil, err
 	}
 
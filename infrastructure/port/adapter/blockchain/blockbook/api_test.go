@@ -1,12 +1,12 @@
-// +integration
-
 package blockbook_test
 
 import (
 	"math/big"
+	"path/filepath"
 	"testing"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
 )
 
@@ -30,6 +30,10 @@ func TestGetAccountMovements_Bitcoin(t *testing.T) {
 	}
 
 	api := blockbook.NewAPI(bitcoinHostURL, blockbook.BitcoinTranslator{})
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
+
 	mv, err := api.GetAccountMovements(address, since)
 	if err != nil {
 		t.Fatal(err)
@@ -54,6 +58,10 @@ func TestGetAccountMovements_Bitcoin_WithPages(t *testing.T) {
 	pagingLimit := 1000
 
 	api := blockbook.NewAPI(bitcoinHostURL, blockbook.BitcoinTranslator{}, &pagingLimit)
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
+
 	mv, err := api.GetAccountMovements(address, since)
 	if err != nil {
 		t.Fatal(err)
@@ -100,6 +108,10 @@ func TestGetAccountMovements_Ethereum(t *testing.T) {
 	}
 
 	api := blockbook.NewAPI(ethereumHostURL, blockbook.EthereumTranslator{})
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
+
 	mv, err := api.GetAccountMovements(address, since)
 	if err != nil {
 		t.Fatal(err)
@@ -121,6 +133,10 @@ func TestGetAccountMovements_Ethereum(t *testing.T) {
 
 func TestGetLatestBlockHeight(t *testing.T) {
 	api := blockbook.NewAPI(bitcoinHostURL, blockbook.BitcoinTranslator{})
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
+
 	bh, err := api.GetLatestBlockHeight()
 	if err != nil {
 		t.Fatal(err)
@@ -130,3 +146,19 @@ func TestGetLatestBlockHeight(t *testing.T) {
 		t.Fatal("expected anything but 0")
 	}
 }
+
+// Returns a client replaying the responses recorded in the fixture of the running test
+// and a function saving the fixture, which records the responses when RECORD_FIXTURES=1
+func helperReplayClient(t *testing.T, ignoredParams ...string) (*net.HTTPClient, func()) {
+	t.Helper()
+	rt, err := net.NewReplayTransport(filepath.Join("testdata", "fixtures", t.Name()+".json"), ignoredParams...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return net.NewHTTPClient(&net.ClientOptions{Transport: rt}), func() {
+		if err := rt.Save(); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package blockbook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// Block is a data structure returning from Blockbook's API
+type Block struct {
+	Paging
+	Hash         string        `json:"hash"`
+	Height       uint64        `json:"height"`
+	Time         uint64        `json:"time"`
+	TxCount      int           `json:"txCount"`
+	Transactions []Transaction `json:"txs"`
+}
+
+// Translators which can normalize the addresses into the form they compare addresses in
+type addressNormalizer interface {
+	NormalizeAddress(address string) (string, error)
+}
+
+// NormalizeAddress returns the form of the given address which the addresses in blocks are matched in
+func (a *API) NormalizeAddress(address string) (string, error) {
+	if n, ok := a.t.(addressNormalizer); ok {
+		return n.NormalizeAddress(address)
+	}
+
+	return strings.TrimSpace(address), nil
+}
+
+// GetBlockMovements fetches txs in the block at the given height and converts
+// them to the account movements of the watched addresses involved in them
+func (a *API) GetBlockMovements(blockHeight uint64, watched func(address string) bool) ([]*domain.AccountMovements, error) {
+	currPage := 1
+	b, err := a.fetchBlock(blockHeight, currPage)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := b.Transactions
+	for currPage < b.TotalPages {
+		currPage++
+		next, err := a.fetchBlock(blockHeight, currPage)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, next.Transactions...)
+	}
+
+	// Txs of each watched address in the order of their first appearance in the block
+	addresses := make([]string, 0)
+	txsOf := make(map[string][]Transaction)
+	for _, tx := range txs {
+		// Txs of a block might come without the block info
+		if tx.BlockHeight == 0 {
+			tx.BlockHeight = b.Height
+		}
+		if tx.BlockTime == 0 {
+			tx.BlockTime = b.Time
+		}
+
+		for _, address := range a.watchedAddressesOf(tx, watched) {
+			if _, exist := txsOf[address]; !exist {
+				addresses = append(addresses, address)
+			}
+			txsOf[address] = append(txsOf[address], tx)
+		}
+	}
+
+	acms := make([]*domain.AccountMovements, 0, len(addresses))
+	for _, address := range addresses {
+		am, err := a.t.ToAccountMovements(address, txsOf[address])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(am.Transfers) > 0 {
+			acms = append(acms, am)
+		}
+	}
+
+	return acms, nil
+}
+
+// Returns the normalized addresses of the inputs and the outputs of
+// the given tx which are watched, each of them only once
+func (a *API) watchedAddressesOf(tx Transaction, watched func(address string) bool) []string {
+	found := make(map[string]bool)
+	addresses := make([]string, 0)
+
+	check := func(as []string) {
+		// Inputs/outputs without any address such as coinbase inputs
+		// or the ones with non-standard scripts will never match
+		if len(as) == 0 {
+			return
+		}
+
+		address, err := a.NormalizeAddress(as[0])
+		if err != nil || found[address] || !watched(address) {
+			return
+		}
+
+		found[address] = true
+		addresses = append(addresses, address)
+	}
+
+	for _, in := range tx.Inputs {
+		check(in.Addresses)
+	}
+	for _, out := range tx.Outputs {
+		check(out.Addresses)
+	}
+
+	return addresses
+}
+
+// API call to blockbook's api/v2/block endpoint
+// For further info: https://github.com/trezor/blockbook/blob/master/docs/api.md#get-block
+func (a *API) fetchBlock(height uint64, page int) (*Block, error) {
+	url := fmt.Sprintf("%s/api/v2/block/%d?page=%d", a.hostURL, height, page)
+	b := &Block{}
+	if err := a.c.GetJSON(context.Background(), url, &b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
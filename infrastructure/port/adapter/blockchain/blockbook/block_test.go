@@ -0,0 +1,56 @@
+package blockbook_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
+)
+
+var testBlockPages = []string{
+	`{"page":1,"totalPages":2,"height":700000,"time":1631333672,"txs":[
+		{"txid":"tx1","vin":[{"n":0,"isAddress":false}],"vout":[{"n":0,"value":"625000000","addresses":["addr-miner"],"isAddress":true}]},
+		{"txid":"tx2","vin":[{"n":0,"value":"1000","addresses":["addr-1"],"isAddress":true}],"vout":[{"n":0,"value":"900","addresses":["addr-2"],"isAddress":true},{"n":1,"value":"50","addresses":["addr-1"],"isAddress":true}]}
+	]}`,
+	`{"page":2,"totalPages":2,"height":700000,"time":1631333672,"txs":[
+		{"txid":"tx3","vin":[{"n":0,"value":"300","addresses":["addr-3"],"isAddress":true}],"vout":[{"n":0,"value":"200","addresses":["addr-1"],"isAddress":true}]}
+	]}`,
+}
+
+func TestAPI_GetBlockMovements(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if r.URL.Path != "/api/v2/block/700000" || (page != "1" && page != "2") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testBlockPages[page[0]-'1'])
+	}))
+	defer s.Close()
+
+	watched := map[string]bool{"addr-1": true, "addr-2": true, "addr-4": true}
+	api := blockbook.NewAPI(s.URL, blockbook.BitcoinTranslator{})
+	acms, err := api.GetBlockMovements(700000, func(address string) bool {
+		return watched[address]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acms) != 2 || acms[0].Address != "addr-1" || acms[1].Address != "addr-2" {
+		t.Fatalf("expected the movements of addr-1 and addr-2 but got %+v", acms)
+	}
+
+	// Spent 1000 and received 50 in tx2, and received 200 in tx3
+	if len(acms[0].Transfers) != 3 {
+		t.Fatalf("expected %d transfers for addr-1 but got %d", 3, len(acms[0].Transfers))
+	}
+
+	for _, tr := range acms[0].Transfers {
+		if tr.BlockHeight != 700000 || tr.Timestamp != 1631333672 {
+			t.Fatalf("expected the transfers to have the block info but got %+v", tr)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package blockbooktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
+)
+
+// Default and max. number of txs per page as in Blockbook
+const (
+	defaultPageSize = 1000
+	maxPageSize     = 1000
+)
+
+// State is the chain state served by Server. The blocks above BestHeight are
+// not mined yet, they are revealed one by one as the chain advances
+type State struct {
+	Coin       string            `json:"coin"`
+	BestHeight uint64            `json:"bestHeight"`
+	Blocks     []blockbook.Block `json:"blocks"`
+}
+
+// LoadState reads a chain state from the given JSON file
+func LoadState(path string) (*State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid chain state(%s), %s", path, err.Error())
+	}
+
+	return s, nil
+}
+
+// Server is a fake Blockbook serving the status, address and block endpoints of the HTTP API and the
+// subscriptions of the WebSocket API from a scripted chain state, so that the real API runs against it.
+// The chain advances by Mine or by POST /fake/mine, which notifies the subscribers of the new block
+type Server struct {
+	router   *mux.Router
+	upgrader websocket.Upgrader
+
+	mu         sync.Mutex
+	coin       string
+	bestHeight uint64
+	blocks     map[uint64]*blockbook.Block
+	clients    map[*client]bool
+}
+
+// NewServer creates a new instance of Server with the given chain state
+func NewServer(state *State) (*Server, error) {
+	s := &Server{
+		coin:       state.Coin,
+		bestHeight: state.BestHeight,
+		blocks:     make(map[uint64]*blockbook.Block),
+		clients:    make(map[*client]bool),
+	}
+
+	for _, b := range state.Blocks {
+		if err := s.addBlock(b); err != nil {
+			return nil, err
+		}
+	}
+
+	s.router = mux.NewRouter()
+	s.router.HandleFunc("/api/v2", s.handleStatus).Methods(http.MethodGet)
+	s.router.HandleFunc("/api/v2/address/{address}", s.handleAddress).Methods(http.MethodGet)
+	s.router.HandleFunc("/api/v2/block/{height:[0-9]+}", s.handleBlock).Methods(http.MethodGet)
+	s.router.HandleFunc("/websocket", s.handleWebSocket)
+	s.router.HandleFunc("/fake/mine", s.handleMine).Methods(http.MethodPost)
+
+	return s, nil
+}
+
+// ServeHTTP serves the requests to the fake Blockbook
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// AddBlock scripts the given block, which is served once the chain advances to its height
+func (s *Server) AddBlock(b blockbook.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b.Height <= s.bestHeight {
+		return fmt.Errorf("block#%d is already mined", b.Height)
+	}
+
+	return s.addBlock(b)
+}
+
+func (s *Server) addBlock(b blockbook.Block) error {
+	if _, exist := s.blocks[b.Height]; exist {
+		return fmt.Errorf("block#%d is scripted more than once", b.Height)
+	}
+
+	if b.Hash == "" {
+		b.Hash = blockHash(b.Height)
+	}
+
+	// Txs are served with the info of their block
+	txs := make([]blockbook.Transaction, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		tx.BlockHeight = b.Height
+		tx.BlockHash = b.Hash
+		tx.BlockTime = b.Time
+		txs[i] = tx
+	}
+	b.Transactions = txs
+	b.TxCount = len(txs)
+
+	s.blocks[b.Height] = &b
+
+	return nil
+}
+
+// BestHeight returns the height of the latest mined block
+func (s *Server) BestHeight() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bestHeight
+}
+
+// Mine advances the chain by one block and notifies the subscribers of the new block and of the
+// addresses involved in it. The block is empty unless it's scripted. Returns the new best height
+func (s *Server) Mine() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bestHeight++
+	b := s.block(s.bestHeight)
+
+	for c := range s.clients {
+		c.notifyBlock(b)
+	}
+
+	return s.bestHeight
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := map[string]interface{}{
+		"blockbook": map[string]interface{}{
+			"coin":       s.coin,
+			"bestHeight": s.bestHeight,
+			"inSync":     true,
+		},
+		"backend": map[string]interface{}{
+			"blocks":        s.bestHeight,
+			"bestBlockHash": s.block(s.bestHeight).Hash,
+		},
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, status)
+}
+
+// Serves the txs of the address from the newest to the oldest as Blockbook does
+func (s *Server) handleAddress(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	q := r.URL.Query()
+
+	if d := q.Get("details"); d != "" && d != "txs" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("details=%s is not supported", d))
+		return
+	}
+
+	page, pageSize, ok := paging(w, q.Get("page"), q.Get("pageSize"))
+	if !ok {
+		return
+	}
+
+	from, err := parseHeight(q.Get("from"), 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from")
+		return
+	}
+
+	s.mu.Lock()
+	to, err := parseHeight(q.Get("to"), s.bestHeight)
+	if err != nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid to")
+		return
+	}
+
+	txs := make([]blockbook.Transaction, 0)
+	received, sent := big.NewInt(0), big.NewInt(0)
+	for _, h := range s.minedHeights() {
+		for _, tx := range s.blocks[h].Transactions {
+			in, out, involved := valuesOf(tx, address)
+			if !involved {
+				continue
+			}
+
+			sent.Add(sent, in)
+			received.Add(received, out)
+			if h >= from && h <= to {
+				tx.Confirmations = s.bestHeight - h + 1
+				txs = append(txs, tx)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	// Newest first
+	for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+		txs[i], txs[j] = txs[j], txs[i]
+	}
+
+	at := &blockbook.AddressTxs{
+		Paging: blockbook.Paging{
+			Page:        page,
+			TotalPages:  (len(txs) + pageSize - 1) / pageSize,
+			ItemsOnPage: pageSize,
+		},
+		Address:            address,
+		Balance:            new(big.Int).Sub(received, sent).String(),
+		UnconfirmedBalance: "0",
+		TotalReceived:      received.String(),
+		TotalSent:          sent.String(),
+		TxCount:            uint64(len(txs)),
+	}
+
+	if start := (page - 1) * pageSize; start < len(txs) {
+		end := start + pageSize
+		if end > len(txs) {
+			end = len(txs)
+		}
+		at.Transactions = txs[start:end]
+	}
+
+	writeJSON(w, at)
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	height, _ := strconv.ParseUint(mux.Vars(r)["height"], 10, 64)
+
+	page, pageSize, ok := paging(w, r.URL.Query().Get("page"), r.URL.Query().Get("pageSize"))
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if height > s.bestHeight {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("block not found, block#%d is not mined yet", height))
+		return
+	}
+	b := *s.block(height)
+	s.mu.Unlock()
+
+	txs := b.Transactions
+	b.Paging = blockbook.Paging{
+		Page:        page,
+		TotalPages:  (len(txs) + pageSize - 1) / pageSize,
+		ItemsOnPage: pageSize,
+	}
+	b.Transactions = nil
+
+	if start := (page - 1) * pageSize; start < len(txs) {
+		end := start + pageSize
+		if end > len(txs) {
+			end = len(txs)
+		}
+		b.Transactions = txs[start:end]
+	}
+
+	writeJSON(w, b)
+}
+
+func (s *Server) handleMine(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]uint64{"bestHeight": s.Mine()})
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &client{conn: conn, addresses: make(map[string]bool)}
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	c.serve()
+}
+
+// Returns the block at the given height, which is an empty one if it's not scripted
+func (s *Server) block(height uint64) *blockbook.Block {
+	if b, exist := s.blocks[height]; exist {
+		return b
+	}
+
+	return &blockbook.Block{Height: height, Hash: blockHash(height), Transactions: []blockbook.Transaction{}}
+}
+
+// Returns the heights of the scripted blocks which are mined in ascending order
+func (s *Server) minedHeights() []uint64 {
+	heights := make([]uint64, 0, len(s.blocks))
+	for h := range s.blocks {
+		if h <= s.bestHeight {
+			heights = append(heights, h)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	return heights
+}
+
+// client is a WebSocket connection and its subscriptions
+type client struct {
+	conn *websocket.Conn
+
+	mu          sync.Mutex
+	newBlockID  string
+	addressesID string
+	addresses   map[string]bool // lower-cased addresses
+}
+
+type request struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (c *client) serve() {
+	for {
+		req := &request{}
+		if err := c.conn.ReadJSON(req); err != nil {
+			return
+		}
+
+		switch req.Method {
+		case "subscribeNewBlock":
+			c.mu.Lock()
+			c.newBlockID = req.ID
+			c.mu.Unlock()
+			c.write(req.ID, map[string]bool{"subscribed": true})
+		case "subscribeAddresses":
+			p := struct {
+				Addresses []string `json:"addresses"`
+			}{}
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				c.write(req.ID, errorData(err.Error()))
+				continue
+			}
+
+			c.mu.Lock()
+			c.addressesID = req.ID
+			c.addresses = make(map[string]bool)
+			for _, a := range p.Addresses {
+				c.addresses[strings.ToLower(a)] = true
+			}
+			c.mu.Unlock()
+			c.write(req.ID, map[string]bool{"subscribed": true})
+		case "unsubscribeNewBlock":
+			c.mu.Lock()
+			c.newBlockID = ""
+			c.mu.Unlock()
+			c.write(req.ID, map[string]bool{"subscribed": false})
+		case "unsubscribeAddresses":
+			c.mu.Lock()
+			c.addressesID = ""
+			c.addresses = make(map[string]bool)
+			c.mu.Unlock()
+			c.write(req.ID, map[string]bool{"subscribed": false})
+		case "ping":
+			c.write(req.ID, struct{}{})
+		default:
+			c.write(req.ID, errorData(fmt.Sprintf("unknown method %s", req.Method)))
+		}
+	}
+}
+
+// Notifies the new block if subscribed and the subscribed addresses involved in it
+func (c *client) notifyBlock(b *blockbook.Block) {
+	c.mu.Lock()
+	newBlockID, addressesID := c.newBlockID, c.addressesID
+	notified := make(map[string]bool)
+	type notification struct {
+		address string
+		tx      blockbook.Transaction
+	}
+	notifications := make([]notification, 0)
+	for _, tx := range b.Transactions {
+		for _, a := range addressesOf(tx) {
+			key := strings.ToLower(a)
+			if c.addresses[key] && !notified[key] {
+				notified[key] = true
+				notifications = append(notifications, notification{address: a, tx: tx})
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	if newBlockID != "" {
+		c.write(newBlockID, map[string]interface{}{"height": b.Height, "hash": b.Hash})
+	}
+
+	for _, n := range notifications {
+		c.write(addressesID, map[string]interface{}{"address": n.address, "tx": n.tx})
+	}
+}
+
+// Writes a message. Errors are ignored since the connection is dropped by the read loop anyway
+func (c *client) write(id string, data interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.WriteJSON(map[string]interface{}{"id": id, "data": data})
+}
+
+func errorData(message string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]string{"message": message}}
+}
+
+// Returns the addresses of the inputs and the outputs of the given tx
+func addressesOf(tx blockbook.Transaction) []string {
+	addresses := make([]string, 0)
+	for _, in := range tx.Inputs {
+		addresses = append(addresses, in.Addresses...)
+	}
+	for _, out := range tx.Outputs {
+		addresses = append(addresses, out.Addresses...)
+	}
+	return addresses
+}
+
+// Returns the values spent and received by the given address in the given tx and whether or not it's involved
+func valuesOf(tx blockbook.Transaction, address string) (*big.Int, *big.Int, bool) {
+	in, out := big.NewInt(0), big.NewInt(0)
+	involved := false
+	matches := func(addresses []string) bool {
+		for _, a := range addresses {
+			if strings.EqualFold(a, address) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, i := range tx.Inputs {
+		if matches(i.Addresses) {
+			involved = true
+			if v, ok := new(big.Int).SetString(i.Value, 10); ok {
+				in.Add(in, v)
+			}
+		}
+	}
+	for _, o := range tx.Outputs {
+		if matches(o.Addresses) {
+			involved = true
+			if v, ok := new(big.Int).SetString(o.Value, 10); ok {
+				out.Add(out, v)
+			}
+		}
+	}
+
+	return in, out, involved
+}
+
+func paging(w http.ResponseWriter, pageParam, pageSizeParam string) (int, int, bool) {
+	page, pageSize := 1, defaultPageSize
+	var err error
+
+	if pageParam != "" {
+		if page, err = strconv.Atoi(pageParam); err != nil || page < 1 {
+			writeError(w, http.StatusBadRequest, "invalid page")
+			return 0, 0, false
+		}
+	}
+
+	if pageSizeParam != "" {
+		if pageSize, err = strconv.Atoi(pageSizeParam); err != nil || pageSize < 1 {
+			writeError(w, http.StatusBadRequest, "invalid pageSize")
+			return 0, 0, false
+		}
+		if pageSize > maxPageSize {
+			pageSize = maxPageSize
+		}
+	}
+
+	return page, pageSize, true
+}
+
+func parseHeight(param string, defaultHeight uint64) (uint64, error) {
+	if param == "" {
+		return defaultHeight, nil
+	}
+	return strconv.ParseUint(param, 10, 64)
+}
+
+func blockHash(height uint64) string {
+	return fmt.Sprintf("%064x", height)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
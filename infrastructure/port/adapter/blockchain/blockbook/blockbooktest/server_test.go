@@ -0,0 +1,144 @@
+package blockbooktest_test
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook/blockbooktest"
+)
+
+const (
+	addr1 = "addr-1"
+	addr2 = "addr-2"
+)
+
+func transfer(txid, from, to, value string) blockbook.Transaction {
+	return blockbook.Transaction{
+		TxID:    txid,
+		Inputs:  []blockbook.Input{{Addresses: []string{from}, IsAddress: true, Value: value}},
+		Outputs: []blockbook.Output{{Addresses: []string{to}, IsAddress: true, Value: value}},
+	}
+}
+
+func helperServer(t *testing.T) (*blockbooktest.Server, *httptest.Server) {
+	t.Helper()
+	fake, err := blockbooktest.NewServer(&blockbooktest.State{
+		Coin:       "Bitcoin",
+		BestHeight: 103,
+		Blocks: []blockbook.Block{
+			{Height: 100, Time: 1610500000, Transactions: []blockbook.Transaction{transfer("tx-100", addr2, addr1, "5000")}},
+			{Height: 101, Time: 1610500600, Transactions: []blockbook.Transaction{transfer("tx-101", addr1, addr2, "1000")}},
+			{Height: 103, Time: 1610501800, Transactions: []blockbook.Transaction{transfer("tx-103", addr2, addr1, "300")}},
+			{Height: 104, Time: 1610502400, Transactions: []blockbook.Transaction{transfer("tx-104", addr2, addr1, "20")}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, httptest.NewServer(fake)
+}
+
+func helperBalance(t *testing.T, api *blockbook.API, address string, since uint64) *big.Int {
+	t.Helper()
+	am, err := api.GetAccountMovements(address, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance := big.NewInt(0)
+	for _, tr := range am.Transfers {
+		balance.Add(balance, tr.Value())
+	}
+
+	return balance
+}
+
+func TestServer_GetAccountMovements(t *testing.T) {
+	_, s := helperServer(t)
+	defer s.Close()
+
+	pagingLimit := 1
+	api := blockbook.NewAPI(s.URL, blockbook.BitcoinTranslator{}, &pagingLimit)
+
+	bh, err := api.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 103 {
+		t.Fatalf("expected latest block height is 103 but got %d", bh)
+	}
+
+	// Block#104 is not mined yet
+	if b := helperBalance(t, api, addr1, 0); b.Cmp(big.NewInt(4300)) != 0 {
+		t.Fatalf("expected balance is 4300 but got %s", b)
+	}
+
+	if b := helperBalance(t, api, addr1, 101); b.Cmp(big.NewInt(-700)) != 0 {
+		t.Fatalf("expected balance since block#101 is -700 but got %s", b)
+	}
+}
+
+func TestServer_Mine(t *testing.T) {
+	fake, s := helperServer(t)
+	defer s.Close()
+
+	api := blockbook.NewStreamingAPI(s.URL, blockbook.BitcoinTranslator{})
+	defer api.Close()
+
+	if err := api.WatchAddresses([]string{addr1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; !api.IsConnected(); i++ {
+		if i == 100 {
+			t.Fatal("expected to connect to the fake server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Let the subscriptions reach the server before mining
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := http.Post(s.URL+"/fake/mine", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case address := <-api.Notifications():
+		if address != addr1 {
+			t.Fatalf("expected a notification of %s but got %s", addr1, address)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a notification but got nothing")
+	}
+
+	if bh, _ := api.GetLatestBlockHeight(); bh != 104 {
+		t.Fatalf("expected latest block height is 104 but got %d", bh)
+	}
+
+	acms, err := api.GetBlockMovements(104, func(address string) bool { return address == addr1 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acms) != 1 || len(acms[0].Transfers) != 1 || acms[0].Transfers[0].Amount.Cmp(big.NewInt(20)) != 0 {
+		t.Fatalf("expected a transfer of 20 at block#104 but got %+v", acms)
+	}
+
+	// Empty blocks are mined beyond the scripted ones
+	if bh := fake.Mine(); bh != 105 {
+		t.Fatalf("expected latest block height is 105 but got %d", bh)
+	}
+
+	if err := fake.AddBlock(blockbook.Block{Height: 105}); err == nil {
+		t.Fatal("expected an error for a block already mined but got nothing")
+	}
+}
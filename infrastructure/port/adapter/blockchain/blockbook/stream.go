@@ -0,0 +1,354 @@
+package blockbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// Boundaries of the exponential backoff in-between reconnection attempts
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Timeouts for connecting to the server and for writing a message
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+// Interval of the pings keeping the connection alive
+const pingInterval = 30 * time.Second
+
+// Capacity of the notification channel. Notifications are dropped when it is full,
+// and the dropped ones are picked up by the next reconciliation poll of the observer
+const notificationBufferSize = 1000
+
+// Request ids are echoed back by Blockbook with the responses and the
+// notifications, so the subscriptions are identified by their request ids
+const (
+	newBlockRequestID  = "new-block"
+	addressesRequestID = "addresses"
+	pingRequestID      = "ping"
+)
+
+// AddressNotification is a data structure pushed by Blockbook's subscribeAddresses
+type AddressNotification struct {
+	Address string `json:"address"`
+}
+
+// BlockNotification is a data structure pushed by Blockbook's subscribeNewBlock
+type BlockNotification struct {
+	Height uint64 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+type wsRequest struct {
+	ID     string      `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type wsMessage struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+type wsError struct {
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// StreamingAPI extends API with the push notifications of Blockbook's WebSocket API. It implements
+// MovementNotifier and keeps the latest block height up-to-date through the new block notifications
+// For further info: https://github.com/trezor/blockbook/blob/master/docs/api.md#websocket-api
+type StreamingAPI struct {
+	*API
+	wsURL         string
+	notifications chan string
+
+	mu         sync.Mutex
+	conn       *websocket.Conn
+	watched    map[string]string // lower-cased address -> address
+	bestHeight uint64
+	running    bool
+	closed     bool
+}
+
+// NewStreamingAPI creates a new instance of StreamingAPI. The WebSocket
+// endpoint is derived from the given host URL of the HTTP API
+func NewStreamingAPI(hostURL string, t blockchain.Translator, pagingLimit ...*int) *StreamingAPI {
+	wsURL := strings.TrimSuffix(hostURL, "/") + "/websocket"
+	if strings.HasPrefix(wsURL, "https://") {
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	} else if strings.HasPrefix(wsURL, "http://") {
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+
+	return &StreamingAPI{
+		API:           NewAPI(hostURL, t, pagingLimit...),
+		wsURL:         wsURL,
+		notifications: make(chan string, notificationBufferSize),
+		watched:       make(map[string]string),
+	}
+}
+
+// GetLatestBlockHeight returns the height of the latest block notified over
+// the socket, or fetches it from the HTTP API if the socket is down
+func (a *StreamingAPI) GetLatestBlockHeight() (uint64, error) {
+	a.mu.Lock()
+	bh := a.bestHeight
+	connected := a.conn != nil
+	a.mu.Unlock()
+
+	if connected && bh > 0 {
+		return bh, nil
+	}
+
+	return a.API.GetLatestBlockHeight()
+}
+
+// WatchAddresses subscribes for the movements of the given addresses in addition to the ones
+// already watched. The socket is connected in background and reconnected whenever it drops
+func (a *StreamingAPI) WatchAddresses(addresses []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return fmt.Errorf("streaming api is closed")
+	}
+
+	changed := false
+	for _, address := range addresses {
+		key := strings.ToLower(address)
+		if _, exist := a.watched[key]; !exist {
+			a.watched[key] = address
+			changed = true
+		}
+	}
+
+	if !a.running {
+		a.running = true
+		go a.run()
+		return nil
+	}
+
+	// Subscriptions are renewed on connection, so they are only sent if connected
+	if changed && a.conn != nil {
+		return a.subscribeAddresses(a.conn)
+	}
+
+	return nil
+}
+
+// Notifications returns the channel of the addresses with new movements
+func (a *StreamingAPI) Notifications() <-chan string {
+	return a.notifications
+}
+
+// IsConnected reports whether or not the socket is connected
+func (a *StreamingAPI) IsConnected() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.conn != nil
+}
+
+// Close closes the socket and stops reconnecting
+func (a *StreamingAPI) Close() {
+	a.mu.Lock()
+	a.closed = true
+	conn := a.conn
+	a.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Keeps the socket connected until closed
+func (a *StreamingAPI) run() {
+	delay := minReconnectDelay
+	reconnected := false
+
+	for !a.isClosed() {
+		conn, err := a.connect()
+		if err != nil {
+			log.Printf("blockbook: cannot connect to %s, %s", a.wsURL, err.Error())
+		} else {
+			delay = minReconnectDelay
+
+			// Movements might have been missed while disconnected
+			if reconnected {
+				a.notifyAll()
+			}
+			reconnected = true
+
+			err = a.readLoop(conn)
+			a.disconnected(conn)
+			if a.isClosed() {
+				break
+			}
+			log.Printf("blockbook: connection to %s is lost, %s", a.wsURL, err.Error())
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (a *StreamingAPI) connect() (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{HandshakeTimeout: dialTimeout}
+	conn, _, err := dialer.Dial(a.wsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		conn.Close()
+		return nil, fmt.Errorf("streaming api is closed")
+	}
+
+	if err := a.write(conn, &wsRequest{ID: newBlockRequestID, Method: "subscribeNewBlock", Params: struct{}{}}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := a.subscribeAddresses(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	a.conn = conn
+	go a.ping(conn)
+
+	return conn, nil
+}
+
+func (a *StreamingAPI) disconnected(conn *websocket.Conn) {
+	conn.Close()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == conn {
+		a.conn = nil
+		a.bestHeight = 0
+	}
+}
+
+func (a *StreamingAPI) readLoop(conn *websocket.Conn) error {
+	for {
+		msg := &wsMessage{}
+		if err := conn.ReadJSON(msg); err != nil {
+			return err
+		}
+
+		if e := (&wsError{}); json.Unmarshal(msg.Data, e) == nil && e.Error != nil {
+			log.Printf("blockbook: %s request failed, %s", msg.ID, e.Error.Message)
+			continue
+		}
+
+		switch msg.ID {
+		case newBlockRequestID:
+			b := &BlockNotification{}
+			if err := json.Unmarshal(msg.Data, b); err == nil && b.Height > 0 {
+				a.mu.Lock()
+				a.bestHeight = b.Height
+				a.mu.Unlock()
+			}
+		case addressesRequestID:
+			n := &AddressNotification{}
+			if err := json.Unmarshal(msg.Data, n); err != nil || n.Address == "" {
+				continue
+			}
+
+			// Blockbook might notify the address in a different case, e.g. EIP-55 checksum
+			a.mu.Lock()
+			address, exist := a.watched[strings.ToLower(n.Address)]
+			a.mu.Unlock()
+			if exist {
+				a.notify(address)
+			}
+		}
+	}
+}
+
+// Sends pings periodically until the connection drops
+func (a *StreamingAPI) ping(conn *websocket.Conn) {
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+
+	for range t.C {
+		a.mu.Lock()
+		if a.conn != conn {
+			a.mu.Unlock()
+			return
+		}
+		err := a.write(conn, &wsRequest{ID: pingRequestID, Method: "ping", Params: struct{}{}})
+		a.mu.Unlock()
+
+		if err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// subscribeAddresses replaces the subscribed addresses with the watched ones. Must be called with the lock held
+func (a *StreamingAPI) subscribeAddresses(conn *websocket.Conn) error {
+	addresses := make([]string, 0, len(a.watched))
+	for _, address := range a.watched {
+		addresses = append(addresses, address)
+	}
+
+	return a.write(conn, &wsRequest{
+		ID:     addressesRequestID,
+		Method: "subscribeAddresses",
+		Params: map[string][]string{"addresses": addresses},
+	})
+}
+
+// write sends the given request. Must be called with the lock held since concurrent writes are not allowed
+func (a *StreamingAPI) write(conn *websocket.Conn, r *wsRequest) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(r)
+}
+
+func (a *StreamingAPI) notifyAll() {
+	a.mu.Lock()
+	addresses := make([]string, 0, len(a.watched))
+	for _, address := range a.watched {
+		addresses = append(addresses, address)
+	}
+	a.mu.Unlock()
+
+	for _, address := range addresses {
+		a.notify(address)
+	}
+}
+
+func (a *StreamingAPI) notify(address string) {
+	select {
+	case a.notifications <- address:
+	default:
+		log.Printf("blockbook: notification buffer is full, dropping notification of %s", address)
+	}
+}
+
+func (a *StreamingAPI) isClosed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed
+}
@@ -0,0 +1,205 @@
+package blockbook_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
+)
+
+// Fake Blockbook WebSocket server
+type fakeWebSocketServer struct {
+	s *httptest.Server
+
+	mu                 sync.Mutex
+	conns              []*websocket.Conn
+	connections        int
+	subscribedBlocks   bool
+	subscribedAddrs    []string
+	subscriptionsCount int
+}
+
+func newFakeWebSocketServer() *fakeWebSocketServer {
+	fs := &fakeWebSocketServer{}
+	upgrader := websocket.Upgrader{}
+
+	fs.s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/websocket" {
+			http.NotFound(w, r)
+			return
+		}
+
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		fs.mu.Lock()
+		fs.conns = append(fs.conns, c)
+		fs.connections++
+		fs.mu.Unlock()
+
+		fs.handle(c)
+	}))
+
+	return fs
+}
+
+func (fs *fakeWebSocketServer) handle(c *websocket.Conn) {
+	for {
+		req := struct {
+			ID     string          `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}{}
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+
+		fs.mu.Lock()
+		switch req.Method {
+		case "subscribeNewBlock":
+			fs.subscribedBlocks = true
+		case "subscribeAddresses":
+			p := struct {
+				Addresses []string `json:"addresses"`
+			}{}
+			json.Unmarshal(req.Params, &p)
+			fs.subscribedAddrs = p.Addresses
+			fs.subscriptionsCount++
+		}
+		c.WriteJSON(map[string]interface{}{"id": req.ID, "data": map[string]bool{"subscribed": true}})
+		fs.mu.Unlock()
+	}
+}
+
+func (fs *fakeWebSocketServer) send(id string, data interface{}) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, c := range fs.conns {
+		c.WriteJSON(map[string]interface{}{"id": id, "data": data})
+	}
+}
+
+func (fs *fakeWebSocketServer) dropConnections() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, c := range fs.conns {
+		c.Close()
+	}
+	fs.conns = nil
+}
+
+func (fs *fakeWebSocketServer) state() (int, []string, int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.connections, fs.subscribedAddrs, fs.subscriptionsCount
+}
+
+func (fs *fakeWebSocketServer) isSubscribedToBlocks() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.subscribedBlocks
+}
+
+func TestStreamingAPI_WatchAddresses(t *testing.T) {
+	fs := newFakeWebSocketServer()
+	defer fs.s.Close()
+
+	api := blockbook.NewStreamingAPI(fs.s.URL, blockbook.BitcoinTranslator{})
+	defer api.Close()
+
+	if err := api.WatchAddresses([]string{"addr1", "addr2", "addr1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	helperWaitFor(t, "subscription of 2 addresses", func() bool {
+		_, addrs, _ := fs.state()
+		return api.IsConnected() && len(addrs) == 2
+	})
+
+	if !fs.isSubscribedToBlocks() {
+		t.Fatal("expected to subscribe to new blocks but did not")
+	}
+
+	// All watched addresses should be subscribed at once
+	if err := api.WatchAddresses([]string{"addr3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	helperWaitFor(t, "subscription of 3 addresses", func() bool {
+		_, addrs, _ := fs.state()
+		return len(addrs) == 3
+	})
+
+	// Notifications of unwatched addresses should be ignored
+	fs.send("addresses", map[string]interface{}{"address": "unknown", "tx": map[string]string{"txid": "tx1"}})
+	fs.send("addresses", map[string]interface{}{"address": "ADDR2", "tx": map[string]string{"txid": "tx2"}})
+	helperExpectNotifications(t, api, "addr2")
+
+	fs.send("new-block", map[string]interface{}{"height": 700000, "hash": "hash"})
+	helperWaitFor(t, "latest block height from the socket", func() bool {
+		bh, err := api.GetLatestBlockHeight()
+		return err == nil && bh == 700000
+	})
+}
+
+func TestStreamingAPI_Reconnect(t *testing.T) {
+	fs := newFakeWebSocketServer()
+	defer fs.s.Close()
+
+	api := blockbook.NewStreamingAPI(fs.s.URL, blockbook.BitcoinTranslator{})
+	defer api.Close()
+
+	if err := api.WatchAddresses([]string{"addr1", "addr2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	helperWaitFor(t, "connection", func() bool {
+		c, _, _ := fs.state()
+		return c == 1 && api.IsConnected()
+	})
+
+	fs.dropConnections()
+
+	// Subscriptions should be renewed and all watched
+	// addresses should be notified after reconnection
+	helperWaitFor(t, "reconnection", func() bool {
+		c, addrs, subs := fs.state()
+		return c == 2 && subs == 2 && len(addrs) == 2 && api.IsConnected()
+	})
+	helperExpectNotifications(t, api, "addr1", "addr2")
+}
+
+func helperWaitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s but timed out", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func helperExpectNotifications(t *testing.T, api *blockbook.StreamingAPI, expected ...string) {
+	got := make(map[string]bool)
+	for range expected {
+		select {
+		case address := <-api.Notifications():
+			got[address] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected notifications of %v but got %v", expected, got)
+		}
+	}
+
+	for _, e := range expected {
+		if !got[e] {
+			t.Fatalf("expected notifications of %v but got %v", expected, got)
+		}
+	}
+}
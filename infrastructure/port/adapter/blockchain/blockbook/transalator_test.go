@@ -1,10 +1,14 @@
 package blockbook_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"path/filepath"
 	"testing"
 
+	domain "github.com/psychoplasma/crypto-balance-bot"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
 )
 
@@ -100,3 +104,100 @@ func TestEthereumTranslator_ToAccountMovements(t *testing.T) {
 		t.Fatalf("expected movement's total balance change is %d but got %s", -value, balanceDiff.String())
 	}
 }
+
+func TestBitcoinTranslator_ToAccountMovements_Litecoin(t *testing.T) {
+	addr := "LWcXUB6ny8ykGmBYaQqFMX6w5w2fBQ3nzW"
+	txs := helperReadAddressTxs(t, "ltc_address_txs.json")
+
+	mvs, err := new(blockbook.BitcoinTranslator).ToAccountMovements(addr, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helperCheckTransfers(t, mvs, []*domain.Transfer{
+		{Type: domain.Received, Amount: big.NewInt(100000000), BlockHeight: 2100000, Timestamp: 1627462814, TxHash: txs[0].TxID, Index: 0},
+		{Type: domain.Spent, Amount: big.NewInt(100000000), BlockHeight: 2100010, Timestamp: 1627464302, TxHash: txs[1].TxID, Index: 0},
+		{Type: domain.Received, Amount: big.NewInt(39990000), BlockHeight: 2100010, Timestamp: 1627464302, TxHash: txs[1].TxID, Index: 1},
+	})
+}
+
+func TestBitcoinTranslator_ToAccountMovements_Dogecoin(t *testing.T) {
+	addr := "DH5yaieqoZN36fDVciNyRueRGvGLR3mr7L"
+	txs := helperReadAddressTxs(t, "doge_address_txs.json")
+
+	mvs, err := new(blockbook.BitcoinTranslator).ToAccountMovements(addr, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Coinbase input and OP_RETURN output should be skipped
+	helperCheckTransfers(t, mvs, []*domain.Transfer{
+		{Type: domain.Received, Amount: big.NewInt(1000000000000), BlockHeight: 4000000, Timestamp: 1636900000, TxHash: txs[0].TxID, Index: 0},
+		{Type: domain.Spent, Amount: big.NewInt(1000000000000), BlockHeight: 4000100, Timestamp: 1636906000, TxHash: txs[1].TxID, Index: 0},
+	})
+}
+
+func TestBitcoinCashTranslator_ToAccountMovements(t *testing.T) {
+	txs := helperReadAddressTxs(t, "bch_address_txs.json")
+	addresses := []string{
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		"qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		"1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu",
+	}
+
+	for _, addr := range addresses {
+		mvs, err := new(blockbook.BitcoinCashTranslator).ToAccountMovements(addr, txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mvs.Address != addr {
+			t.Fatalf("expected movements' address is %s but got %s", addr, mvs.Address)
+		}
+
+		helperCheckTransfers(t, mvs, []*domain.Transfer{
+			{Type: domain.Received, Amount: big.NewInt(50000000), BlockHeight: 700000, Timestamp: 1628000000, TxHash: txs[0].TxID, Index: 0},
+			{Type: domain.Spent, Amount: big.NewInt(50000000), BlockHeight: 700010, Timestamp: 1628006000, TxHash: txs[1].TxID, Index: 0},
+		})
+	}
+}
+
+func TestBitcoinCashTranslator_ToAccountMovements_InvalidAddress(t *testing.T) {
+	txs := helperReadAddressTxs(t, "bch_address_txs.json")
+
+	if _, err := new(blockbook.BitcoinCashTranslator).ToAccountMovements("invalid-address", txs); err == nil {
+		t.Fatal("expected an error for invalid address but got nothing")
+	}
+}
+
+func helperReadAddressTxs(t *testing.T, filename string) []blockbook.Transaction {
+	data, err := ioutil.ReadFile(filepath.Join("./testdata", filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := new(blockbook.AddressTxs)
+	if err := json.Unmarshal(data, at); err != nil {
+		t.Fatal(err)
+	}
+
+	return at.Transactions
+}
+
+func helperCheckTransfers(t *testing.T, mvs *domain.AccountMovements, expected []*domain.Transfer) {
+	if len(mvs.Transfers) != len(expected) {
+		t.Fatalf("expected transfer count is %d but got %d", len(expected), len(mvs.Transfers))
+	}
+
+	for i, e := range expected {
+		tr := mvs.Transfers[i]
+		if tr.Type != e.Type ||
+			tr.Amount.Cmp(e.Amount) != 0 ||
+			tr.BlockHeight != e.BlockHeight ||
+			tr.Timestamp != e.Timestamp ||
+			tr.TxHash != e.TxHash ||
+			tr.Index != e.Index {
+			t.Fatalf("expected transfer#%d is %+v but got %+v", i, e, tr)
+		}
+	}
+}
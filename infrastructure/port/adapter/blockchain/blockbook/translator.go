@@ -3,52 +3,97 @@ package blockbook
 import (
 	"fmt"
 	"math/big"
+	"strings"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
 )
 
-// BitcoinTranslator is a translator for Blockbook API
+// BitcoinTranslator is a translator for Blockbook API. It serves the other
+// UTXO-based blockchains with the same address format such as Litecoin and Dogecoin
 type BitcoinTranslator struct{}
 
 // ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
 func (tr BitcoinTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
 	txs, _ := v.([]Transaction)
+	return toUTXOAccountMovements(address, txs, func(a string) (string, error) {
+		return a, nil
+	})
+}
+
+// BitcoinCashTranslator is a translator for Blockbook API. Addresses are compared
+// in CashAddr format, so that legacy addresses match with Blockbook's output as well
+type BitcoinCashTranslator struct{}
+
+// ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
+func (tr BitcoinCashTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
+	txs, _ := v.([]Transaction)
+	return toUTXOAccountMovements(address, txs, blockchain.NormalizeBitcoinCashAddress)
+}
+
+// NormalizeAddress returns the given address as is since Blockbook outputs Bitcoin addresses in their original form
+func (tr BitcoinTranslator) NormalizeAddress(address string) (string, error) {
+	return strings.TrimSpace(address), nil
+}
+
+// NormalizeAddress converts the given address to CashAddr format which Blockbook outputs
+func (tr BitcoinCashTranslator) NormalizeAddress(address string) (string, error) {
+	return blockchain.NormalizeBitcoinCashAddress(address)
+}
+
+func toUTXOAccountMovements(address string, txs []Transaction, normalize func(string) (string, error)) (*domain.AccountMovements, error) {
 	am := domain.NewAccountMovements(address)
+	normalized, err := normalize(address)
+	if err != nil {
+		return nil, fmt.Errorf("utxo translation error, %s", err.Error())
+	}
+
+	// Inputs/outputs without any address such as coinbase inputs
+	// or the ones with non-standard scripts will never match
+	matches := func(addresses []string) bool {
+		if len(addresses) == 0 {
+			return false
+		}
+		a, err := normalize(addresses[0])
+		return err == nil && a == normalized
+	}
 
 	for _, tx := range txs {
 		// Inputs will be reflected as a spent
 		for _, in := range tx.Inputs {
-			if in.Addresses[0] != address {
+			if !matches(in.Addresses) {
 				continue
 			}
 
 			val, ok := new(big.Int).SetString(in.Value, 10)
 			if !ok {
-				return nil, fmt.Errorf("bitcoin translation error, cannot convert in.Value(%s) to bigint", in.Value)
+				return nil, fmt.Errorf("utxo translation error, cannot convert in.Value(%s) to bigint", in.Value)
 			}
-			am.Spend(tx.BlockHeight, tx.BlockTime, tx.TxID, val, "")
+			am.Spend(tx.BlockHeight, tx.BlockTime, tx.TxID, in.Index, val, "")
 		}
 
 		// Outputs will be reflected as a receive
 		for _, out := range tx.Outputs {
-			if out.Addresses[0] != address {
+			if !matches(out.Addresses) {
 				continue
 			}
 
 			val, ok := new(big.Int).SetString(out.Value, 10)
 			if !ok {
-				return nil, fmt.Errorf("bitcoin translation error, cannot convert out.Value(%s) to bigint", out.Value)
+				return nil, fmt.Errorf("utxo translation error, cannot convert out.Value(%s) to bigint", out.Value)
 			}
-			am.Receive(tx.BlockHeight, tx.BlockTime, tx.TxID, val, "")
+			am.Receive(tx.BlockHeight, tx.BlockTime, tx.TxID, out.Index, val, "")
 		}
 	}
 
 	return am, nil
 }
 
-// EthereumTranslator is a translator for Blockbook API
-type EthereumTranslator struct{}
+// EthereumTranslator is a translator for Blockbook API. It serves
+// any EVM-compatible blockchain which is given by Chain
+type EthereumTranslator struct {
+	Chain blockchain.EVMChain
+}
 
 // ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
 func (tr EthereumTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
@@ -64,19 +109,24 @@ func (tr EthereumTranslator) ToAccountMovements(address string, v interface{}) (
 
 		val, ok := new(big.Int).SetString(tx.Value, 10)
 		if !ok {
-			return nil, fmt.Errorf("blockbook ethereum translation error, cannot convert in.Value(%s) to bigint", tx.Value)
+			return nil, fmt.Errorf("blockbook %s translation error, cannot convert in.Value(%s) to bigint", tr.Chain, tx.Value)
 		}
 
 		// Any value transfers from this address will be reflected as a spent
 		if blockchain.NormalizeEthereumAddress(tx.Inputs[0].Addresses[0]) == address {
-			am.Spend(tx.BlockHeight, tx.BlockTime, tx.TxID, val, tx.Outputs[0].Addresses[0])
+			am.Spend(tx.BlockHeight, tx.BlockTime, tx.TxID, 0, val, tx.Outputs[0].Addresses[0])
 		}
 
 		// Any value transfers to this address will be reflected as a receive
 		if blockchain.NormalizeEthereumAddress(tx.Outputs[0].Addresses[0]) == address {
-			am.Receive(tx.BlockHeight, tx.BlockTime, tx.TxID, val, tx.Inputs[0].Addresses[0])
+			am.Receive(tx.BlockHeight, tx.BlockTime, tx.TxID, 0, val, tx.Inputs[0].Addresses[0])
 		}
 	}
 
 	return am, nil
 }
+
+// NormalizeAddress converts the given address to lowercase with 0x prefix
+func (tr EthereumTranslator) NormalizeAddress(address string) (string, error) {
+	return blockchain.NormalizeEthereumAddress(address), nil
+}
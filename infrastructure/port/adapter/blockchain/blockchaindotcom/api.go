@@ -1,9 +1,9 @@
 package blockchaindotcom
 
 import (
+	"context"
 	"fmt"
 	"math/big"
-	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
@@ -54,18 +54,22 @@ type Block struct {
 // API implements CurrencyAPI for Bitcoin
 type API struct {
 	t blockchain.Translator
+	c *net.HTTPClient
 }
 
-// Delay between consecutive api requests, not to choking api provider
-const requestDelay = 200 * time.Millisecond
-
 // NewAPI creates a new instance of API
 func NewAPI(t blockchain.Translator) *API {
 	return &API{
 		t: t,
+		c: net.NewHTTPClient(),
 	}
 }
 
+// SetHTTPClient sets the client making the requests to the provider, e.g. to send an api key
+func (a *API) SetHTTPClient(c *net.HTTPClient) {
+	a.c = c
+}
+
 // GetAccountMovements fetches txs of the given address since the given block height.
 // Worth to mention that this does not fetches since exact sinceBlockHeight,
 // rather guarantees that txs at sinceBlockHeight will be included. There may be
@@ -108,10 +112,9 @@ func (a *API) GetLatestBlockHeight() (uint64, error) {
 // API call to https://blockchain.info/rawaddr/$bitcoin_address.
 // For further info: https://www.blockchain.com/api/blockchain_api
 func (a *API) fetchAddressInfo(address string, pLimit int, pOffset int) (*AddressInfo, error) {
-	defer time.Sleep(requestDelay)
 	url := fmt.Sprintf("https://blockchain.info/rawaddr/%s?n=%d&offset=%d", address, pLimit, pOffset)
 	ad := &AddressInfo{}
-	if err := net.GetJSON(url, ad); err != nil {
+	if err := a.c.GetJSON(context.Background(), url, ad); err != nil {
 		return nil, err
 	}
 
@@ -121,9 +124,8 @@ func (a *API) fetchAddressInfo(address string, pLimit int, pOffset int) (*Addres
 // API call to https://blockchain.info/latestblock
 // For further info: https://www.blockchain.com/api/blockchain_api
 func (a *API) fetchLatestBlock() (*Block, error) {
-	defer time.Sleep(requestDelay)
 	b := &Block{}
-	if err := net.GetJSON("https://blockchain.info/latestblock", b); err != nil {
+	if err := a.c.GetJSON(context.Background(), "https://blockchain.info/latestblock", b); err != nil {
 		return nil, err
 	}
 
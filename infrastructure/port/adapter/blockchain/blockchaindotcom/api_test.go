@@ -1,16 +1,19 @@
-// +integration
-
 package blockchaindotcom_test
 
 import (
+	"path/filepath"
 	"testing"
 
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockchaindotcom"
 )
 
 func TestGetAccountMovements(t *testing.T) {
 	blockNum := uint64(183579)
 	api := blockchaindotcom.NewAPI(blockchaindotcom.BitcoinTranslator{})
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
 
 	mv, err := api.GetAccountMovements("1AJbsFZ64EpEfS5UAjAfcUG8pH8Jn3rn1F", blockNum)
 	if err != nil {
@@ -32,6 +35,10 @@ func TestGetAccountMovements(t *testing.T) {
 
 func TestGetLatestBlockHeight(t *testing.T) {
 	api := blockchaindotcom.NewAPI(blockchaindotcom.BitcoinTranslator{})
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
+
 	bh, err := api.GetLatestBlockHeight()
 	if err != nil {
 		t.Fatal(err)
@@ -41,3 +48,19 @@ func TestGetLatestBlockHeight(t *testing.T) {
 		t.Fatal("expected anything but 0")
 	}
 }
+
+// Returns a client replaying the responses recorded in the fixture of the running test
+// and a function saving the fixture, which records the responses when RECORD_FIXTURES=1
+func helperReplayClient(t *testing.T, ignoredParams ...string) (*net.HTTPClient, func()) {
+	t.Helper()
+	rt, err := net.NewReplayTransport(filepath.Join("testdata", "fixtures", t.Name()+".json"), ignoredParams...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return net.NewHTTPClient(&net.ClientOptions{Transport: rt}), func() {
+		if err := rt.Save(); err != nil {
+			t.Error(err)
+		}
+	}
+}
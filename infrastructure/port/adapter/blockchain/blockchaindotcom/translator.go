@@ -14,21 +14,21 @@ func (bt BitcoinTranslator) ToAccountMovements(address string, v interface{}) (*
 
 	for _, tx := range txs {
 		// Inputs will be reflected as a spent
-		for _, in := range tx.Inputs {
+		for i, in := range tx.Inputs {
 			if in.PrevOutput.Address != address {
 				continue
 			}
 
-			am.Spend(tx.BlockHeight, tx.Timestamp, tx.Hash, in.PrevOutput.Value, "")
+			am.Spend(tx.BlockHeight, tx.Timestamp, tx.Hash, uint(i), in.PrevOutput.Value, "")
 		}
 
 		// Outputs will be reflected as a receive
-		for _, out := range tx.Outputs {
+		for i, out := range tx.Outputs {
 			if out.Address != address {
 				continue
 			}
 
-			am.Receive(tx.BlockHeight, tx.Timestamp, tx.Hash, out.Value, "")
+			am.Receive(tx.BlockHeight, tx.Timestamp, tx.Hash, uint(i), out.Value, "")
 		}
 	}
 
@@ -0,0 +1,191 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	bitcoinCashPrefix = "bitcoincash"
+	cashAddrCharset   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	base58Alphabet    = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// Legacy address version bytes and their corresponding CashAddr types
+var legacyToCashAddrType = map[byte]byte{
+	0x00: 0, // P2PKH
+	0x05: 1, // P2SH
+}
+
+// NormalizeBitcoinCashAddress converts the given Bitcoin Cash address to the
+// CashAddr format with 'bitcoincash:' prefix in lowercase, which is the format
+// Blockbook outputs. Legacy(base58) addresses and CashAddr addresses without
+// the prefix are accepted as well.
+func NormalizeBitcoinCashAddress(address string) (string, error) {
+	address = strings.TrimSpace(address)
+
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, bitcoinCashPrefix+":") {
+		return decodeCashAddr(lower)
+	}
+
+	if strings.HasPrefix(lower, "q") || strings.HasPrefix(lower, "p") {
+		return decodeCashAddr(bitcoinCashPrefix + ":" + lower)
+	}
+
+	return legacyToCashAddr(address)
+}
+
+func decodeCashAddr(address string) (string, error) {
+	payload := strings.TrimPrefix(address, bitcoinCashPrefix+":")
+	if len(payload) <= 8 {
+		return "", fmt.Errorf("invalid cashaddr(%s), too short", address)
+	}
+
+	values := make([]byte, len(payload))
+	for i, c := range payload {
+		v := strings.IndexRune(cashAddrCharset, c)
+		if v < 0 {
+			return "", fmt.Errorf("invalid cashaddr(%s), unexpected character(%c)", address, c)
+		}
+		values[i] = byte(v)
+	}
+
+	if cashAddrPolymod(append(prefixValues(bitcoinCashPrefix), values...)) != 0 {
+		return "", fmt.Errorf("invalid cashaddr(%s), checksum mismatch", address)
+	}
+
+	return address, nil
+}
+
+func legacyToCashAddr(address string) (string, error) {
+	decoded, err := decodeBase58Check(address)
+	if err != nil {
+		return "", err
+	}
+
+	if len(decoded) != 21 {
+		return "", fmt.Errorf("invalid legacy address(%s), unexpected length", address)
+	}
+
+	t, ok := legacyToCashAddrType[decoded[0]]
+	if !ok {
+		return "", fmt.Errorf("invalid legacy address(%s), unsupported version(%d)", address, decoded[0])
+	}
+
+	// Version byte consists of type bits and size bits where
+	// the size bits for a 160-bit hash are 0
+	versionByte := t << 3
+	payload, err := convertBits(append([]byte{versionByte}, decoded[1:]...), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	checksumInput := append(prefixValues(bitcoinCashPrefix), payload...)
+	checksumInput = append(checksumInput, make([]byte, 8)...)
+	polymod := cashAddrPolymod(checksumInput)
+	for i := 0; i < 8; i++ {
+		payload = append(payload, byte((polymod>>uint(5*(7-i)))&0x1f))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(bitcoinCashPrefix + ":")
+	for _, v := range payload {
+		sb.WriteByte(cashAddrCharset[v])
+	}
+
+	return sb.String(), nil
+}
+
+// Lower 5 bits of each prefix character followed by a zero separator
+func prefixValues(prefix string) []byte {
+	values := make([]byte, 0, len(prefix)+1)
+	for i := 0; i < len(prefix); i++ {
+		values = append(values, prefix[i]&0x1f)
+	}
+	return append(values, 0)
+}
+
+// For further info: https://github.com/bitcoincashorg/bitcoincash.org/blob/master/spec/cashaddr.md#checksum
+func cashAddrPolymod(values []byte) uint64 {
+	generators := []uint64{0x98f2bc8e61, 0x79b76d99e2, 0xf33e5fb3c4, 0xae2eabe2a8, 0x1e4f43e470}
+	c := uint64(1)
+
+	for _, d := range values {
+		c0 := byte(c >> 35)
+		c = ((c & 0x07ffffffff) << 5) ^ uint64(d)
+		for i, g := range generators {
+			if c0&(1<<uint(i)) != 0 {
+				c ^= g
+			}
+		}
+	}
+
+	return c ^ 1
+}
+
+// Regroups the given bits of 'from' size to 'to' size. If pad is true, the last group is padded
+// with zeros, otherwise any leftover bits must be zero padding of less than 'from' size
+func convertBits(data []byte, from uint, to uint, pad bool) ([]byte, error) {
+	acc := uint(0)
+	bits := uint(0)
+	maxv := uint(1<<to) - 1
+	out := make([]byte, 0, len(data)*int(from)/int(to)+1)
+
+	for _, b := range data {
+		if uint(b)>>from != 0 {
+			return nil, fmt.Errorf("invalid %d-bit value(%d)", from, b)
+		}
+		acc = (acc << from) | uint(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte((acc>>bits)&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte((acc<<(to-bits))&maxv))
+		}
+	} else if bits >= from || (acc<<(to-bits))&maxv != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+
+	return out, nil
+}
+
+func decodeBase58Check(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		v := strings.IndexRune(base58Alphabet, c)
+		if v < 0 {
+			return nil, fmt.Errorf("invalid base58 string(%s), unexpected character(%c)", s, c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(v)))
+	}
+
+	decoded := n.Bytes()
+	// Leading '1's represent leading zero bytes
+	for i := 0; i < len(s) && s[i] == base58Alphabet[0]; i++ {
+		decoded = append([]byte{0}, decoded...)
+	}
+
+	if len(decoded) < 4 {
+		return nil, fmt.Errorf("invalid base58 string(%s), too short", s)
+	}
+
+	data, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return nil, fmt.Errorf("invalid base58 string(%s), checksum mismatch", s)
+	}
+
+	return data, nil
+}
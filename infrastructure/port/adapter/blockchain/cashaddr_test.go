@@ -0,0 +1,47 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// Test vectors from https://github.com/bitcoincashorg/bitcoincash.org/blob/master/spec/cashaddr.md
+func TestNormalizeBitcoinCashAddress(t *testing.T) {
+	cases := map[string]string{
+		"1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu":                       "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		"1KXrWXciRDZUpQwQmuM1DbwsKDLYAYsVLR":                       "bitcoincash:qr95sy3j9xwd2ap32xkykttr4cvcu7as4y0qverfuy",
+		"16w1D5WRVKJuZUsSRzdLp9w3YGcgoxDXb":                        "bitcoincash:qqq3728yw0y47sqn6l2na30mcw6zm78dzqre909m2r",
+		"3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC":                       "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq",
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a":   "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		"BITCOINCASH:QPM2QSZNHKS23Z7629MMS6S4CWEF74VCWVY22GDX6A":   "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		"qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a":               "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		" bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq ": "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq",
+	}
+
+	for address, expected := range cases {
+		normalized, err := blockchain.NormalizeBitcoinCashAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if normalized != expected {
+			t.Fatalf("expected normalized address of %s is %s but got %s", address, expected, normalized)
+		}
+	}
+}
+
+func TestNormalizeBitcoinCashAddress_Invalid(t *testing.T) {
+	addresses := []string{
+		"",
+		"1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggv",
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6b",
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6i",
+	}
+
+	for _, address := range addresses {
+		if _, err := blockchain.NormalizeBitcoinCashAddress(address); err == nil {
+			t.Fatalf("expected an error for address \"%s\" but got nothing", address)
+		}
+	}
+}
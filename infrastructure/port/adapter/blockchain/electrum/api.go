@@ -0,0 +1,291 @@
+package electrum
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// Capacity of the notification channel. Notifications are dropped when it is full,
+// and the dropped ones are picked up by the next reconciliation poll of the observer
+const notificationBufferSize = 1000
+
+// HistoryItem is a data structure returning from Electrum's blockchain.scripthash.get_history.
+// Height is 0 or -1 for the txs in mempool
+type HistoryItem struct {
+	TxHash string `json:"tx_hash"`
+	Height int64  `json:"height"`
+}
+
+// HeaderNotification is a data structure returning from Electrum's blockchain.headers.subscribe
+type HeaderNotification struct {
+	Height uint64 `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+// API implements CurrencyAPI and MovementNotifier for Electrum protocol
+// servers such as electrs or Fulcrum. Addresses are converted to script hashes
+type API struct {
+	c             *client
+	t             blockchain.Translator
+	notifications chan string
+
+	mu      sync.Mutex
+	watched map[string]string // script hash -> address
+}
+
+// NewAPI creates a new instance of API for the given server URL,
+// which is either tcp://host:port or ssl://host:port
+func NewAPI(serverURL string, t blockchain.Translator) (*API, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid server url(%s), host and port are required", serverURL)
+	}
+
+	var tlsConfig *tls.Config
+	switch u.Scheme {
+	case "tcp":
+	case "ssl", "tls":
+		tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid server url(%s), scheme must be tcp or ssl", serverURL)
+	}
+
+	a := &API{
+		c:             newClient(u.Host, tlsConfig),
+		t:             t,
+		notifications: make(chan string, notificationBufferSize),
+		watched:       make(map[string]string),
+	}
+	a.c.onNotification = a.handleNotification
+	a.c.onConnect = a.resubscribe
+
+	return a, nil
+}
+
+// Close closes the connection to the server
+func (a *API) Close() {
+	a.c.close()
+}
+
+// GetAccountMovements fetches the confirmed txs of the given address since the given block height
+func (a *API) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	sh, err := blockchain.BitcoinAddressToScriptHash(address)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []HistoryItem{}
+	if err := a.c.call(&history, "blockchain.scripthash.get_history", sh); err != nil {
+		return nil, err
+	}
+
+	txs := []*Transaction{}
+	resolved := make(map[string]*Transaction)
+	timestamps := make(map[uint64]uint64)
+
+	for _, h := range history {
+		// Txs in mempool cannot be applied until confirmed
+		if h.Height <= 0 || uint64(h.Height) < sinceBlockHeight {
+			continue
+		}
+
+		tx, err := a.fetchTransaction(h.TxHash, resolved)
+		if err != nil {
+			return nil, err
+		}
+
+		tx.BlockHeight = uint64(h.Height)
+		if _, exist := timestamps[tx.BlockHeight]; !exist {
+			if timestamps[tx.BlockHeight], err = a.fetchBlockTime(tx.BlockHeight); err != nil {
+				return nil, err
+			}
+		}
+		tx.BlockTime = timestamps[tx.BlockHeight]
+
+		if err := a.resolvePrevouts(tx, resolved); err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return a.t.ToAccountMovements(address, txs)
+}
+
+// GetLatestBlockHeight fetches the latest block number
+func (a *API) GetLatestBlockHeight() (uint64, error) {
+	h := &HeaderNotification{}
+	if err := a.c.call(h, "blockchain.headers.subscribe"); err != nil {
+		return 0, err
+	}
+
+	return h.Height, nil
+}
+
+// WatchAddresses subscribes for the status changes of the given addresses in addition to the
+// ones already watched. Subscriptions are renewed whenever the connection is re-established
+func (a *API) WatchAddresses(addresses []string) error {
+	a.c.setKeepAlive()
+
+	// Connect beforehand, otherwise the addresses would be subscribed twice on connection
+	if !a.c.isConnected() {
+		if err := a.c.connect(); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range addresses {
+		sh, err := blockchain.BitcoinAddressToScriptHash(address)
+		if err != nil {
+			return err
+		}
+
+		a.mu.Lock()
+		_, exist := a.watched[sh]
+		a.watched[sh] = address
+		a.mu.Unlock()
+
+		if exist {
+			continue
+		}
+
+		if err := a.c.call(nil, "blockchain.scripthash.subscribe", sh); err != nil {
+			a.mu.Lock()
+			delete(a.watched, sh)
+			a.mu.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Notifications returns the channel of the addresses whose status has changed
+func (a *API) Notifications() <-chan string {
+	return a.notifications
+}
+
+// IsConnected reports whether or not the connection to the server is alive
+func (a *API) IsConnected() bool {
+	return a.c.isConnected()
+}
+
+// RPC call to blockchain.transaction.get. Verbose mode is not
+// supported by all servers, so the raw transaction is parsed
+// For further info: https://electrumx-spesmilo.readthedocs.io/en/latest/protocol-methods.html#blockchain-transaction-get
+func (a *API) fetchTransaction(txHash string, cache map[string]*Transaction) (*Transaction, error) {
+	if tx, exist := cache[txHash]; exist {
+		return tx, nil
+	}
+
+	var raw string
+	if err := a.c.call(&raw, "blockchain.transaction.get", txHash); err != nil {
+		return nil, err
+	}
+
+	tx, err := ParseTransaction(txHash, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	cache[txHash] = tx
+
+	return tx, nil
+}
+
+func (a *API) resolvePrevouts(tx *Transaction, cache map[string]*Transaction) error {
+	for i, in := range tx.Inputs {
+		if in.IsCoinbase() || in.Prevout != nil {
+			continue
+		}
+
+		prev, err := a.fetchTransaction(in.PrevTxID, cache)
+		if err != nil {
+			return fmt.Errorf("cannot resolve input#%d of tx(%s), %w", i, tx.TxID, err)
+		}
+
+		if int(in.PrevIndex) >= len(prev.Outputs) {
+			return fmt.Errorf("cannot resolve input#%d of tx(%s), tx(%s) has no output#%d", i, tx.TxID, in.PrevTxID, in.PrevIndex)
+		}
+
+		tx.Inputs[i].Prevout = &prev.Outputs[in.PrevIndex]
+	}
+
+	return nil
+}
+
+// RPC call to blockchain.block.header
+// For further info: https://electrumx-spesmilo.readthedocs.io/en/latest/protocol-methods.html#blockchain-block-header
+func (a *API) fetchBlockTime(height uint64) (uint64, error) {
+	var header string
+	if err := a.c.call(&header, "blockchain.block.header", height); err != nil {
+		return 0, err
+	}
+
+	return headerTimestamp(header)
+}
+
+func (a *API) handleNotification(method string, params json.RawMessage) {
+	if method != "blockchain.scripthash.subscribe" {
+		return
+	}
+
+	// Params are [script hash, status]
+	p := []interface{}{}
+	if err := json.Unmarshal(params, &p); err != nil || len(p) == 0 {
+		log.Printf("electrum: unexpected notification params, %s", string(params))
+		return
+	}
+
+	sh, _ := p[0].(string)
+	a.mu.Lock()
+	address, exist := a.watched[sh]
+	a.mu.Unlock()
+	if !exist {
+		return
+	}
+
+	a.notify(address)
+}
+
+// Renews the subscriptions after a reconnection and notifies all
+// watched addresses since their movements might have been missed
+func (a *API) resubscribe() error {
+	a.mu.Lock()
+	watched := make(map[string]string, len(a.watched))
+	for sh, address := range a.watched {
+		watched[sh] = address
+	}
+	a.mu.Unlock()
+
+	for sh := range watched {
+		if err := a.c.send(nil, "blockchain.scripthash.subscribe", sh); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range watched {
+		a.notify(address)
+	}
+
+	return nil
+}
+
+func (a *API) notify(address string) {
+	select {
+	case a.notifications <- address:
+	default:
+		log.Printf("electrum: notification buffer is full, dropping notification of %s", address)
+	}
+}
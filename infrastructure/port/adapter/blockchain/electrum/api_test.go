@@ -0,0 +1,388 @@
+package electrum_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/electrum"
+)
+
+const (
+	addr1   = "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu"
+	addr2   = "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	script1 = "76a91476a04053bda0a88bda5177b86a15c3b29f55987388ac"
+	script2 = "0014751e76e8199196d454941c45d1b3a323f1433bd6"
+)
+
+var (
+	txPrev     = strings.Repeat("01", 32)
+	txCoinbase = strings.Repeat("02", 32)
+	txA        = strings.Repeat("03", 32)
+	txB        = strings.Repeat("04", 32)
+	txMempool  = strings.Repeat("05", 32)
+	coinbase   = strings.Repeat("00", 32)
+)
+
+type testInput struct {
+	txID  string
+	index uint32
+}
+
+type testOutput struct {
+	value  uint64
+	script string
+}
+
+// Serializes a transaction in raw format, with the segwit marker and an empty witness if segwit is set
+func serializeTx(segwit bool, inputs []testInput, outputs []testOutput) string {
+	b := []byte{2, 0, 0, 0}
+	if segwit {
+		b = append(b, 0, 1)
+	}
+
+	b = append(b, byte(len(inputs)))
+	for _, in := range inputs {
+		prev, _ := hex.DecodeString(in.txID)
+		for i := len(prev) - 1; i >= 0; i-- {
+			b = append(b, prev[i])
+		}
+		b = append(b, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], in.index)
+		// Script sig and sequence
+		b = append(b, 2, 0xab, 0xcd, 0xff, 0xff, 0xff, 0xff)
+	}
+
+	b = append(b, byte(len(outputs)))
+	for _, out := range outputs {
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], out.value)
+		script, _ := hex.DecodeString(out.script)
+		b = append(b, byte(len(script)))
+		b = append(b, script...)
+	}
+
+	if segwit {
+		for range inputs {
+			b = append(b, 0)
+		}
+	}
+
+	// Locktime
+	b = append(b, 0, 0, 0, 0)
+
+	return hex.EncodeToString(b)
+}
+
+func serializeHeader(timestamp uint32) string {
+	h := make([]byte, 80)
+	binary.LittleEndian.PutUint32(h[68:], timestamp)
+	return hex.EncodeToString(h)
+}
+
+var rawTxs = map[string]string{
+	txPrev: serializeTx(false, []testInput{{txID: strings.Repeat("aa", 32), index: 0}}, []testOutput{
+		{value: 100000, script: script2},
+		{value: 50000, script: script1},
+	}),
+	txCoinbase: serializeTx(false, []testInput{{txID: coinbase, index: 0xffffffff}}, []testOutput{
+		{value: 625000000, script: script1},
+	}),
+	txA: serializeTx(true, []testInput{{txID: txPrev, index: 1}}, []testOutput{
+		{value: 30000, script: script2},
+		{value: 19000, script: script1},
+	}),
+	txB: serializeTx(false, []testInput{{txID: txA, index: 1}}, []testOutput{
+		{value: 18000, script: script2},
+	}),
+	txMempool: serializeTx(false, []testInput{{txID: txB, index: 0}}, []testOutput{
+		{value: 5, script: script1},
+	}),
+}
+
+var headers = map[uint64]uint32{
+	100: 1610500000,
+	101: 1610500600,
+	103: 1610501800,
+}
+
+// Fake Electrum server
+type fakeServer struct {
+	l          net.Listener
+	scriptHash string
+
+	mu            sync.Mutex
+	conns         []net.Conn
+	subscriptions int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh, err := blockchain.BitcoinAddressToScriptHash(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{l: l, scriptHash: sh}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "tcp://" + s.l.Addr().String()
+}
+
+func (s *fakeServer) close() {
+	s.l.Close()
+	s.dropConnections()
+}
+
+func (s *fakeServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) subscriptionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscriptions
+}
+
+func (s *fakeServer) notify(scriptHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		fmt.Fprintf(c, `{"jsonrpc": "2.0", "method": "blockchain.scripthash.subscribe", "params": ["%s", "new-status"]}`+"\n", scriptHash)
+	}
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.mu.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		req := struct {
+			ID     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}{}
+		json.Unmarshal(line, &req)
+
+		var param string
+		if len(req.Params) > 0 {
+			json.Unmarshal(req.Params[0], &param)
+		}
+
+		result := "null"
+		switch req.Method {
+		case "server.version":
+			result = `["fake 1.0", "1.4"]`
+		case "blockchain.headers.subscribe":
+			result = fmt.Sprintf(`{"height": 103, "hex": "%s"}`, serializeHeader(headers[103]))
+		case "blockchain.scripthash.get_history":
+			if param == s.scriptHash {
+				result = fmt.Sprintf(`[{"tx_hash": "%s", "height": 100}, {"tx_hash": "%s", "height": 101}, {"tx_hash": "%s", "height": 103}, {"tx_hash": "%s", "height": 0}]`,
+					txCoinbase, txA, txB, txMempool)
+			} else {
+				result = "[]"
+			}
+		case "blockchain.transaction.get":
+			result = fmt.Sprintf(`"%s"`, rawTxs[param])
+		case "blockchain.block.header":
+			var height uint64
+			json.Unmarshal(req.Params[0], &height)
+			result = fmt.Sprintf(`"%s"`, serializeHeader(headers[height]))
+		case "blockchain.scripthash.subscribe":
+			s.mu.Lock()
+			s.subscriptions++
+			s.mu.Unlock()
+			result = `"status"`
+		default:
+			fmt.Fprintf(c, `{"jsonrpc": "2.0", "id": %d, "error": {"code": -32601, "message": "unknown method"}}`+"\n", req.ID)
+			continue
+		}
+
+		fmt.Fprintf(c, `{"jsonrpc": "2.0", "id": %d, "result": %s}`+"\n", req.ID, result)
+	}
+}
+
+func TestGetLatestBlockHeight(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+
+	api, err := electrum.NewAPI(s.url(), electrum.BitcoinTranslator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	bh, err := api.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 103 {
+		t.Fatalf("expected latest block height is 103 but got %d", bh)
+	}
+}
+
+func TestGetAccountMovements(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+
+	api, err := electrum.NewAPI(s.url(), electrum.BitcoinTranslator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	mv, err := api.GetAccountMovements(addr1, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Coinbase tx is older than the given block height and mempool tx is not confirmed
+	expected := []*domain.Transfer{
+		{Type: domain.Spent, Amount: big.NewInt(50000), BlockHeight: 101, Timestamp: 1610500600, TxHash: txA, Index: 0},
+		{Type: domain.Received, Amount: big.NewInt(19000), BlockHeight: 101, Timestamp: 1610500600, TxHash: txA, Index: 1},
+		{Type: domain.Spent, Amount: big.NewInt(19000), BlockHeight: 103, Timestamp: 1610501800, TxHash: txB, Index: 0},
+	}
+
+	if len(mv.Transfers) != len(expected) {
+		t.Fatalf("expected transfer count is %d but got %d", len(expected), len(mv.Transfers))
+	}
+
+	for i, e := range expected {
+		tr := mv.Transfers[i]
+		if tr.Type != e.Type ||
+			tr.Amount.Cmp(e.Amount) != 0 ||
+			tr.BlockHeight != e.BlockHeight ||
+			tr.Timestamp != e.Timestamp ||
+			tr.TxHash != e.TxHash ||
+			tr.Index != e.Index {
+			t.Fatalf("expected transfer#%d is %+v but got %+v", i, e, tr)
+		}
+	}
+
+	mv, err = api.GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != 4 || mv.Transfers[0].TxHash != txCoinbase {
+		t.Fatalf("expected coinbase transfer to be included but got %+v", mv.Transfers)
+	}
+}
+
+func TestWatchAddresses(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+
+	api, err := electrum.NewAPI(s.url(), electrum.BitcoinTranslator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	if err := api.WatchAddresses([]string{addr1, addr1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := s.subscriptionCount(); c != 1 {
+		t.Fatalf("expected 1 subscription but got %d", c)
+	}
+
+	// Notifications of unwatched script hashes should be ignored
+	s.notify(strings.Repeat("ff", 32))
+	s.notify(s.scriptHash)
+	helperExpectNotification(t, api, addr1)
+
+	// Subscriptions should be renewed after reconnection
+	s.dropConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.subscriptionCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected to resubscribe after reconnection but did not")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if !api.IsConnected() {
+		t.Fatal("expected to be connected but it is not")
+	}
+
+	// Watched addresses should be notified after reconnection since their movements might have been missed
+	helperExpectNotification(t, api, addr1)
+
+	s.notify(s.scriptHash)
+	helperExpectNotification(t, api, addr1)
+}
+
+func TestNewAPI_InvalidURL(t *testing.T) {
+	urls := []string{
+		"localhost:50001",
+		"http://localhost:50001",
+		"tcp://localhost",
+	}
+
+	for _, u := range urls {
+		if _, err := electrum.NewAPI(u, electrum.BitcoinTranslator{}); err == nil {
+			t.Fatalf("expected an error for url \"%s\" but got nothing", u)
+		}
+	}
+}
+
+func TestParseTransaction_Invalid(t *testing.T) {
+	raw := rawTxs[txA]
+	if _, err := electrum.ParseTransaction(txA, raw[:len(raw)/2]); err == nil {
+		t.Fatal("expected an error for truncated tx but got nothing")
+	}
+}
+
+func helperExpectNotification(t *testing.T, api *electrum.API, expected string) {
+	select {
+	case address := <-api.Notifications():
+		if address != expected {
+			t.Fatalf("expected notification of %s but got %s", expected, address)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a notification but got nothing")
+	}
+}
@@ -0,0 +1,311 @@
+package electrum
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Timeouts for connecting to the server and for a call
+const (
+	dialTimeout = 10 * time.Second
+	callTimeout = 30 * time.Second
+)
+
+// Boundaries of the exponential backoff in-between reconnection attempts
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Client name and protocol version sent to the server with server.version
+const (
+	clientName      = "crypto-balance-bot"
+	protocolVersion = "1.4"
+)
+
+// RPCError is an error object returning from an Electrum server
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("electrum error(%d), %s", e.Code, e.Message)
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// A message from the server is either a response with an id or a notification with a method
+type message struct {
+	ID     *uint64         `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// client makes JSON-RPC calls over a newline-delimited TCP/TLS stream.
+// It reconnects with backoff when the connection drops if keepAlive is set
+type client struct {
+	address   string
+	tlsConfig *tls.Config
+
+	// Called with the notifications from the server
+	onNotification func(method string, params json.RawMessage)
+	// Called after every successful (re)connection
+	onConnect func() error
+
+	mu           sync.Mutex
+	conn         net.Conn
+	pending      map[uint64]chan *message
+	nextID       uint64
+	keepAlive    bool
+	reconnecting bool
+	closed       bool
+}
+
+func newClient(address string, tlsConfig *tls.Config) *client {
+	return &client{
+		address:   address,
+		tlsConfig: tlsConfig,
+		pending:   make(map[uint64]chan *message),
+	}
+}
+
+func (c *client) isConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// setKeepAlive makes the client reconnect whenever the connection drops
+func (c *client) setKeepAlive() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keepAlive = true
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// call invokes the given method and decodes the result into v. It connects to the server if not connected
+func (c *client) call(v interface{}, method string, params ...interface{}) error {
+	if !c.isConnected() {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+
+	return c.send(v, method, params...)
+}
+
+func (c *client) send(v interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return fmt.Errorf("not connected to %s", c.address)
+	}
+
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *message, 1)
+	c.pending[id] = ch
+
+	data, err := json.Marshal(&request{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err == nil {
+		c.conn.SetWriteDeadline(time.Now().Add(callTimeout))
+		_, err = c.conn.Write(append(data, '\n'))
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.removePending(id)
+		return err
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("connection to %s is lost while calling %s", c.address, method)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, v)
+	case <-time.After(callTimeout):
+		c.removePending(id)
+		return fmt.Errorf("timeout while calling %s", method)
+	}
+}
+
+func (c *client) removePending(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+func (c *client) connect() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return fmt.Errorf("client is closed")
+	}
+	if c.conn != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	// Another goroutine might have connected in the meantime
+	if c.conn != nil || c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	c.conn = conn
+	c.mu.Unlock()
+
+	go c.readLoop(conn)
+
+	// Protocol version must be negotiated before any other call
+	if err := c.send(nil, "server.version", clientName, protocolVersion); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if c.onConnect != nil {
+		if err := c.onConnect(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *client) readLoop(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			c.disconnected(conn, err)
+			return
+		}
+
+		msg := &message{}
+		if err := json.Unmarshal(line, msg); err != nil {
+			log.Printf("electrum: cannot decode message from %s, %s", c.address, err.Error())
+			continue
+		}
+
+		if msg.ID == nil {
+			if msg.Method != "" && c.onNotification != nil {
+				c.onNotification(msg.Method, msg.Params)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, exist := c.pending[*msg.ID]
+		delete(c.pending, *msg.ID)
+		c.mu.Unlock()
+
+		if exist {
+			ch <- msg
+		}
+	}
+}
+
+// Fails the pending calls and starts reconnecting if keepAlive is set
+func (c *client) disconnected(conn net.Conn, cause error) {
+	conn.Close()
+
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	reconnect := c.keepAlive && !c.closed && !c.reconnecting
+	if reconnect {
+		c.reconnecting = true
+	}
+	c.mu.Unlock()
+
+	if reconnect {
+		log.Printf("electrum: connection to %s is lost, %s", c.address, cause.Error())
+		go c.reconnect()
+	}
+}
+
+func (c *client) reconnect() {
+	delay := minReconnectDelay
+	for {
+		time.Sleep(delay)
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			break
+		}
+
+		err := c.connect()
+		if err == nil {
+			log.Printf("electrum: reconnected to %s", c.address)
+			break
+		}
+
+		log.Printf("electrum: cannot reconnect to %s, %s", c.address, err.Error())
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	c.mu.Lock()
+	c.reconnecting = false
+	c.mu.Unlock()
+}
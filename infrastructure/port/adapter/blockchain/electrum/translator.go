@@ -0,0 +1,49 @@
+package electrum
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// BitcoinTranslator is a translator for Electrum protocol. Inputs and
+// outputs are matched by the output script of the given address
+type BitcoinTranslator struct{}
+
+// ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
+func (tr BitcoinTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
+	txs, _ := v.([]*Transaction)
+	am := domain.NewAccountMovements(address)
+
+	script, err := blockchain.BitcoinAddressToScript(address)
+	if err != nil {
+		return nil, fmt.Errorf("electrum translation error, %s", err.Error())
+	}
+
+	for _, tx := range txs {
+		// Inputs will be reflected as a spent. Coinbase inputs have no previous output
+		for i, in := range tx.Inputs {
+			if in.Prevout == nil || !bytes.Equal(in.Prevout.Script, script) {
+				continue
+			}
+
+			val := new(big.Int).SetUint64(in.Prevout.Value)
+			am.Spend(tx.BlockHeight, tx.BlockTime, tx.TxID, uint(i), val, "")
+		}
+
+		// Outputs will be reflected as a receive
+		for i, out := range tx.Outputs {
+			if !bytes.Equal(out.Script, script) {
+				continue
+			}
+
+			val := new(big.Int).SetUint64(out.Value)
+			am.Receive(tx.BlockHeight, tx.BlockTime, tx.TxID, uint(i), val, "")
+		}
+	}
+
+	return am, nil
+}
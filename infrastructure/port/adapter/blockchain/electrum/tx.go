@@ -0,0 +1,171 @@
+package electrum
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Output is an output of a Bitcoin transaction
+type Output struct {
+	Value  uint64
+	Script []byte
+}
+
+// Input is an input of a Bitcoin transaction.
+// Prevout is the output spent by this input which is resolved by API
+type Input struct {
+	PrevTxID  string
+	PrevIndex uint32
+	Prevout   *Output
+}
+
+// IsCoinbase reports whether or not the input is a coinbase input
+func (in Input) IsCoinbase() bool {
+	return in.PrevIndex == 0xffffffff && in.PrevTxID == coinbasePrevTxID
+}
+
+// Transaction is a Bitcoin transaction parsed from its raw form.
+// BlockHeight and BlockTime are filled by API from the history and the block header
+type Transaction struct {
+	TxID        string
+	Inputs      []Input
+	Outputs     []Output
+	BlockHeight uint64
+	BlockTime   uint64
+}
+
+var coinbasePrevTxID = hex.EncodeToString(make([]byte, 32))
+
+// Offset of the timestamp in a block header
+const headerTimestampOffset = 68
+
+// ParseTransaction parses the given raw transaction in hex. Witness data and locktime are not parsed
+// For further info: https://en.bitcoin.it/wiki/Transaction and
+// https://github.com/bitcoin/bips/blob/master/bip-0144.mediawiki
+func ParseTransaction(txID string, rawHex string) (*Transaction, error) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw tx(%s), %s", txID, err.Error())
+	}
+
+	r := &txReader{b: raw}
+	tx := &Transaction{TxID: txID}
+
+	// Version
+	r.read(4)
+
+	// Segwit marker and flag
+	if len(r.b) > r.pos+1 && r.b[r.pos] == 0 && r.b[r.pos+1] == 1 {
+		r.read(2)
+	}
+
+	inputCount := r.readVarInt()
+	for i := uint64(0); i < inputCount && r.err == nil; i++ {
+		prevTxID := reversed(r.read(32))
+		prevIndex := r.readUint32()
+		r.read(int(r.readVarInt()))
+		r.read(4)
+		tx.Inputs = append(tx.Inputs, Input{
+			PrevTxID:  hex.EncodeToString(prevTxID),
+			PrevIndex: prevIndex,
+		})
+	}
+
+	outputCount := r.readVarInt()
+	for i := uint64(0); i < outputCount && r.err == nil; i++ {
+		value := r.readUint64()
+		script := r.read(int(r.readVarInt()))
+		tx.Outputs = append(tx.Outputs, Output{
+			Value:  value,
+			Script: append([]byte{}, script...),
+		})
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid raw tx(%s), %s", txID, r.err.Error())
+	}
+
+	return tx, nil
+}
+
+// Extracts the timestamp from the given block header in hex
+func headerTimestamp(headerHex string) (uint64, error) {
+	header, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(header) < headerTimestampOffset+4 {
+		return 0, fmt.Errorf("invalid block header, too short")
+	}
+
+	return uint64(binary.LittleEndian.Uint32(header[headerTimestampOffset:])), nil
+}
+
+type txReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *txReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n < 0 || r.pos+n > len(r.b) {
+		r.err = fmt.Errorf("unexpected end of data")
+		return nil
+	}
+
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *txReader) readUint32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *txReader) readUint64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *txReader) readVarInt() uint64 {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+
+	switch b[0] {
+	case 0xfd:
+		if v := r.read(2); v != nil {
+			return uint64(binary.LittleEndian.Uint16(v))
+		}
+	case 0xfe:
+		return uint64(r.readUint32())
+	case 0xff:
+		return r.readUint64()
+	default:
+		return uint64(b[0])
+	}
+
+	return 0
+}
+
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
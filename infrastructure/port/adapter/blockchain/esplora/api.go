@@ -0,0 +1,154 @@
+package esplora
+
+import (
+	"context"
+	"fmt"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// Output is a data structure returning from Esplora API
+type Output struct {
+	Address string `json:"scriptpubkey_address"`
+	Type    string `json:"scriptpubkey_type"`
+	Value   uint64 `json:"value"`
+}
+
+// Input is a data structure returning from Esplora API
+type Input struct {
+	TxID       string  `json:"txid"`
+	Vout       uint    `json:"vout"`
+	Prevout    *Output `json:"prevout"`
+	IsCoinbase bool    `json:"is_coinbase"`
+}
+
+// Status is a data structure returning from Esplora API
+type Status struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight uint64 `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	BlockTime   uint64 `json:"block_time"`
+}
+
+// Transaction is a data structure returning from Esplora API
+type Transaction struct {
+	TxID    string   `json:"txid"`
+	Inputs  []Input  `json:"vin"`
+	Outputs []Output `json:"vout"`
+	Status  Status   `json:"status"`
+}
+
+// API implements CurrencyAPI for Esplora-compatible APIs such as
+// Blockstream's electrs, mempool.space or their self-hosted instances
+type API struct {
+	hostURL string
+	t       blockchain.Translator
+	c       *net.HTTPClient
+}
+
+// NewAPI creates a new instance of API. hostURL is the base URL of
+// the API, e.g. https://mempool.space/api or https://blockstream.info/api
+func NewAPI(hostURL string, t blockchain.Translator) *API {
+	return &API{
+		hostURL: hostURL,
+		t:       t,
+		c:       net.NewHTTPClient(),
+	}
+}
+
+// SetHTTPClient sets the client making the requests to the provider, e.g. to send an api key
+func (a *API) SetHTTPClient(c *net.HTTPClient) {
+	a.c = c
+}
+
+// GetAccountMovements fetches the confirmed txs of the given address since the given block height.
+// The unconfirmed txs in mempool are not included, since they cannot be applied until confirmed.
+// See GetPendingMovements for them
+func (a *API) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	txs := []Transaction{}
+	lastSeenTxID := ""
+
+	// Txs are sorted from the newest to the oldest, so paginate
+	// until a tx older than the given block height is reached
+	for {
+		page, err := a.fetchAddressTxs(address, lastSeenTxID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range page {
+			if tx.Status.BlockHeight < sinceBlockHeight {
+				return a.t.ToAccountMovements(address, txs)
+			}
+			txs = append(txs, tx)
+		}
+
+		if len(page) == 0 {
+			break
+		}
+		lastSeenTxID = page[len(page)-1].TxID
+	}
+
+	return a.t.ToAccountMovements(address, txs)
+}
+
+// GetPendingMovements fetches the unconfirmed txs of the given address in mempool, e.g. to report
+// the incoming or outgoing transfers before they are confirmed. They are not to be applied to
+// subscriptions, since they can be replaced or dropped from mempool
+func (a *API) GetPendingMovements(address string) (*domain.AccountMovements, error) {
+	txs, err := a.fetchAddressMempoolTxs(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return BitcoinTranslator{}.ToPendingMovements(address, txs), nil
+}
+
+// GetLatestBlockHeight fetches the latest block number
+func (a *API) GetLatestBlockHeight() (uint64, error) {
+	return a.fetchTipHeight()
+}
+
+// API call to Esplora's /address/:address/txs/chain[/:last_seen_txid] endpoint
+// For further info: https://github.com/Blockstream/esplora/blob/master/API.md#get-addressaddresstxschainlast_seen_txid
+func (a *API) fetchAddressTxs(address string, lastSeenTxID string) ([]Transaction, error) {
+	url := fmt.Sprintf("%s/address/%s/txs/chain", a.hostURL, address)
+	if lastSeenTxID != "" {
+		url += "/" + lastSeenTxID
+	}
+
+	txs := []Transaction{}
+	if err := a.c.GetJSON(context.Background(), url, &txs); err != nil {
+		return nil, err
+	}
+
+	return txs, nil
+}
+
+// API call to Esplora's /address/:address/txs/mempool endpoint, which returns up to 50 txs
+// For further info: https://github.com/Blockstream/esplora/blob/master/API.md#get-addressaddresstxsmempool
+func (a *API) fetchAddressMempoolTxs(address string) ([]Transaction, error) {
+	url := fmt.Sprintf("%s/address/%s/txs/mempool", a.hostURL, address)
+
+	txs := []Transaction{}
+	if err := a.c.GetJSON(context.Background(), url, &txs); err != nil {
+		return nil, err
+	}
+
+	return txs, nil
+}
+
+// API call to Esplora's /blocks/tip/height endpoint
+// For further info: https://github.com/Blockstream/esplora/blob/master/API.md#get-blockstipheight
+func (a *API) fetchTipHeight() (uint64, error) {
+	url := fmt.Sprintf("%s/blocks/tip/height", a.hostURL)
+	// The response is a plain number which is a valid JSON as well
+	var height uint64
+	if err := a.c.GetJSON(context.Background(), url, &height); err != nil {
+		return 0, err
+	}
+
+	return height, nil
+}
@@ -0,0 +1,224 @@
+package esplora_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/esplora"
+)
+
+const (
+	addr1 = "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+	addr2 = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+)
+
+// Address txs from the newest to the oldest
+var addressTxs = []esplora.Transaction{
+	{
+		TxID: "tx-105",
+		Inputs: []esplora.Input{
+			{TxID: "tx-103", Vout: 0, Prevout: &esplora.Output{Address: addr1, Value: 40000}},
+		},
+		Outputs: []esplora.Output{
+			{Address: addr2, Value: 30000},
+			{Type: "op_return"},
+			{Address: addr1, Value: 9000},
+		},
+		Status: esplora.Status{Confirmed: true, BlockHeight: 105, BlockTime: 1610504481},
+	},
+	{
+		TxID: "tx-103",
+		Inputs: []esplora.Input{
+			{TxID: "tx-prev", Vout: 1, Prevout: &esplora.Output{Address: addr2, Value: 50000}},
+		},
+		Outputs: []esplora.Output{
+			{Address: addr1, Value: 40000},
+		},
+		Status: esplora.Status{Confirmed: true, BlockHeight: 103, BlockTime: 1610503881},
+	},
+	{
+		TxID: "tx-100",
+		Inputs: []esplora.Input{
+			{IsCoinbase: true},
+		},
+		Outputs: []esplora.Output{
+			{Address: addr1, Value: 625000000},
+		},
+		Status: esplora.Status{Confirmed: true, BlockHeight: 100, BlockTime: 1610500000},
+	},
+	{
+		TxID: "tx-99",
+		Outputs: []esplora.Output{
+			{Address: addr1, Value: 1},
+		},
+		Status: esplora.Status{Confirmed: true, BlockHeight: 99, BlockTime: 1610499000},
+	},
+}
+
+// Unconfirmed address txs in mempool
+var mempoolTxs = []esplora.Transaction{
+	{
+		TxID: "tx-mempool",
+		Inputs: []esplora.Input{
+			{TxID: "tx-105", Vout: 2, Prevout: &esplora.Output{Address: addr1, Value: 9000}},
+		},
+		Outputs: []esplora.Output{
+			{Address: addr2, Value: 8000},
+		},
+	},
+}
+
+// Stub for Esplora API serving 2 txs per page
+func newStubServer(t *testing.T, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.Path)
+
+		if r.URL.Path == "/blocks/tip/height" {
+			w.Write([]byte("105"))
+			return
+		}
+
+		if r.URL.Path == fmt.Sprintf("/address/%s/txs/mempool", addr1) {
+			json.NewEncoder(w).Encode(mempoolTxs)
+			return
+		}
+
+		prefix := fmt.Sprintf("/address/%s/txs/chain", addr1)
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			http.NotFound(w, r)
+			return
+		}
+
+		start := 0
+		if lastSeen := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/"); lastSeen != "" {
+			for i, tx := range addressTxs {
+				if tx.TxID == lastSeen {
+					start = i + 1
+				}
+			}
+		}
+
+		end := start + 2
+		if end > len(addressTxs) {
+			end = len(addressTxs)
+		}
+
+		json.NewEncoder(w).Encode(addressTxs[start:end])
+	}))
+}
+
+func TestGetLatestBlockHeight(t *testing.T) {
+	requests := []string{}
+	s := newStubServer(t, &requests)
+	defer s.Close()
+
+	bh, err := esplora.NewAPI(s.URL, esplora.BitcoinTranslator{}).GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 105 {
+		t.Fatalf("expected latest block height is 105 but got %d", bh)
+	}
+}
+
+func TestGetAccountMovements(t *testing.T) {
+	requests := []string{}
+	s := newStubServer(t, &requests)
+	defer s.Close()
+
+	mv, err := esplora.NewAPI(s.URL, esplora.BitcoinTranslator{}).GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*domain.Transfer{
+		{Type: domain.Spent, Amount: big.NewInt(40000), BlockHeight: 105, Timestamp: 1610504481, TxHash: "tx-105", Index: 0},
+		{Type: domain.Received, Amount: big.NewInt(9000), BlockHeight: 105, Timestamp: 1610504481, TxHash: "tx-105", Index: 2},
+		{Type: domain.Received, Amount: big.NewInt(40000), BlockHeight: 103, Timestamp: 1610503881, TxHash: "tx-103", Index: 0},
+		{Type: domain.Received, Amount: big.NewInt(625000000), BlockHeight: 100, Timestamp: 1610500000, TxHash: "tx-100", Index: 0},
+	}
+
+	if len(mv.Transfers) != len(expected) {
+		t.Fatalf("expected transfer count is %d but got %d", len(expected), len(mv.Transfers))
+	}
+
+	for i, e := range expected {
+		tr := mv.Transfers[i]
+		if tr.Type != e.Type ||
+			tr.Amount.Cmp(e.Amount) != 0 ||
+			tr.BlockHeight != e.BlockHeight ||
+			tr.Timestamp != e.Timestamp ||
+			tr.TxHash != e.TxHash ||
+			tr.Index != e.Index {
+			t.Fatalf("expected transfer#%d is %+v but got %+v", i, e, tr)
+		}
+	}
+
+	expectedRequests := []string{
+		fmt.Sprintf("/address/%s/txs/chain", addr1),
+		fmt.Sprintf("/address/%s/txs/chain/tx-103", addr1),
+	}
+	if strings.Join(requests, ",") != strings.Join(expectedRequests, ",") {
+		t.Fatalf("expected requests are %v but got %v", expectedRequests, requests)
+	}
+}
+
+func TestGetAccountMovements_UnknownAddress(t *testing.T) {
+	requests := []string{}
+	s := newStubServer(t, &requests)
+	defer s.Close()
+
+	if _, err := esplora.NewAPI(s.URL, esplora.BitcoinTranslator{}).GetAccountMovements(addr2, 100); err == nil {
+		t.Fatal("expected an error for not found address but got nothing")
+	}
+}
+
+func TestGetPendingMovements(t *testing.T) {
+	requests := []string{}
+	s := newStubServer(t, &requests)
+	defer s.Close()
+
+	mv, err := esplora.NewAPI(s.URL, esplora.BitcoinTranslator{}).GetPendingMovements(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != 1 {
+		t.Fatalf("expected transfer count is %d but got %d", 1, len(mv.Transfers))
+	}
+
+	tr := mv.Transfers[0]
+	if tr.Type != domain.Spent || tr.Amount.Cmp(big.NewInt(9000)) != 0 || tr.BlockHeight != 0 || tr.TxHash != "tx-mempool" {
+		t.Fatalf("expected a pending spent of %d in tx-mempool but got %+v", 9000, tr)
+	}
+
+	expectedRequests := []string{fmt.Sprintf("/address/%s/txs/mempool", addr1)}
+	if strings.Join(requests, ",") != strings.Join(expectedRequests, ",") {
+		t.Fatalf("expected requests are %v but got %v", expectedRequests, requests)
+	}
+}
+
+func TestToAccountMovements_Unconfirmed(t *testing.T) {
+	txs := []esplora.Transaction{
+		{
+			TxID:    "tx-mempool",
+			Outputs: []esplora.Output{{Address: addr1, Value: 1000}},
+		},
+	}
+
+	mv, err := esplora.BitcoinTranslator{}.ToAccountMovements(addr1, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != 0 {
+		t.Fatalf("expected no transfers for unconfirmed txs but got %d", len(mv.Transfers))
+	}
+}
@@ -0,0 +1,64 @@
+package esplora
+
+import (
+	"math/big"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// BitcoinTranslator is a translator for Esplora API
+type BitcoinTranslator struct{}
+
+// ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
+func (tr BitcoinTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
+	txs, _ := v.([]Transaction)
+	am := domain.NewAccountMovements(address)
+
+	for _, tx := range txs {
+		if !tx.Status.Confirmed {
+			continue
+		}
+
+		appendMovements(am, address, tx)
+	}
+
+	return am, nil
+}
+
+// ToPendingMovements converts the unconfirmed txs in mempool to domain.AccountMovement value.
+// The transfers have no block height or timestamp, since they are not in a block yet
+func (tr BitcoinTranslator) ToPendingMovements(address string, txs []Transaction) *domain.AccountMovements {
+	am := domain.NewAccountMovements(address)
+
+	for _, tx := range txs {
+		if tx.Status.Confirmed {
+			continue
+		}
+
+		appendMovements(am, address, tx)
+	}
+
+	return am
+}
+
+func appendMovements(am *domain.AccountMovements, address string, tx Transaction) {
+	// Inputs will be reflected as a spent. Coinbase inputs have no previous output
+	for i, in := range tx.Inputs {
+		if in.IsCoinbase || in.Prevout == nil || in.Prevout.Address != address {
+			continue
+		}
+
+		val := new(big.Int).SetUint64(in.Prevout.Value)
+		am.Spend(tx.Status.BlockHeight, tx.Status.BlockTime, tx.TxID, uint(i), val, "")
+	}
+
+	// Outputs will be reflected as a receive
+	for i, out := range tx.Outputs {
+		if out.Address != address {
+			continue
+		}
+
+		val := new(big.Int).SetUint64(out.Value)
+		am.Receive(tx.Status.BlockHeight, tx.Status.BlockTime, tx.TxID, uint(i), val, "")
+	}
+}
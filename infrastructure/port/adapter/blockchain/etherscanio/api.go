@@ -1,28 +1,63 @@
 package etherscanio
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/concurrency"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
 )
 
+const (
+	transactionStatusSuccess = "1"
+	responseStatusOK         = "1"
+	messageNoTransactions    = "No transactions found"
+	defaultPagingLimit       = 1000
+	// Max. number of results which can be paged through for a query, i.e. page * offset
+	maxResultWindow = 10000
+	// End block of the queries, which is high enough to cover any chain
+	lastBlock = 999999999
+)
+
 var (
-	pageLimit = 50
+	// ErrNoTransactionsFound is returned when the address has no transactions in the queried range
+	ErrNoTransactionsFound = errors.New("no transactions found")
+	// ErrRateLimited is returned when the rate limit of the API key or of the free tier is reached
+	ErrRateLimited = errors.New("rate limit reached")
 )
 
-const transactionStatusSuccess = "1"
+// Retrial of the requests which are rate limited
+var rateLimitRetrial = concurrency.Retrial{
+	Limit:      3,
+	Delay:      time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// APIError is returned when the API responds with status 0 for any other reason,
+// e.g. an invalid API key or invalid parameters
+type APIError struct {
+	Message string
+	Result  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s, %s", e.Message, e.Result)
+}
 
 // Response is a data structure returning from Etherscan.io API
 type Response struct {
-	Status  string      `json:"status"`
-	Message string      `json:"message"`
-	Result  interface{} `json:"result"`
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
 }
 
 // Transaction is a data structure returning from Etherscan.io API
@@ -36,24 +71,56 @@ type Transaction struct {
 	Timestamp   string `json:"timeStamp"`
 }
 
-// API implements CurrencyAPI for Bitcoin
+// DefaultURL is the endpoint of Etherscan's multichain(v2) API
+const DefaultURL = "https://api.etherscan.io/v2/api"
+
+// API implements CurrencyAPI for EVM-compatible blockchains
+// supported by Etherscan or an Etherscan-compatible explorer
 type API struct {
-	t blockchain.Translator
+	url         string
+	chain       blockchain.EVMChain
+	t           blockchain.Translator
+	c           *net.HTTPClient
+	apiKey      string
+	pagingLimit int
 }
 
-// Delay between consecutive api requests, not to choking api provider
-const requestDelay = 200 * time.Millisecond
+// NewAPI creates a new instance of API for the given chain. If url is empty, DefaultURL is used
+func NewAPI(url string, chain blockchain.EVMChain, t blockchain.Translator, pagingLimit ...*int) *API {
+	if url == "" {
+		url = DefaultURL
+	}
+
+	api := &API{
+		url:         url,
+		chain:       chain,
+		t:           t,
+		c:           net.NewHTTPClient(),
+		pagingLimit: defaultPagingLimit,
+	}
 
-// NewAPI creates a new instance of API
-func NewAPI(t blockchain.Translator) *API {
-	return &API{
-		t: t,
+	for _, pl := range pagingLimit {
+		if pl != nil && *pl > 0 && *pl <= maxResultWindow {
+			api.pagingLimit = *pl
+		}
 	}
+
+	return api
+}
+
+// SetHTTPClient sets the client making the requests to the provider
+func (a *API) SetHTTPClient(c *net.HTTPClient) {
+	a.c = c
+}
+
+// SetAPIKey sets the API key sent with every request
+func (a *API) SetAPIKey(apiKey string) {
+	a.apiKey = apiKey
 }
 
 // GetAccountMovements fetches txs of the given address since the given block height
 func (a *API) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
-	txs, err := a.fetchAddressTxs(address, sinceBlockHeight)
+	txs, err := a.fetchAllAddressTxs(address, sinceBlockHeight)
 	if err != nil {
 		return nil, err
 	}
@@ -66,47 +133,150 @@ func (a *API) GetLatestBlockHeight() (uint64, error) {
 	return a.fetchBlockHeightByTimestamp(time.Now().Unix())
 }
 
-// API call to https://api.etherscan.io/api?module=account&action=txlist&address=
-// For further info: https://etherscan.io/apis#accounts
-func (a *API) fetchAddressTxs(address string, startBlock uint64) ([]Transaction, error) {
-	defer time.Sleep(requestDelay)
-	url := fmt.Sprintf("https://api.etherscan.io/api?module=account&action=txlist&address=%s&startblock=%d&sort=desc", address, startBlock)
-	r := &Response{}
-	if err := net.GetJSON(url, r); err != nil {
-		return nil, err
+// Pages through the txs of the given address in ascending order. Since only the first
+// maxResultWindow txs of a query can be paged through, the block range is split at the
+// block of the last tx once the window is exhausted, and the query starts over from there
+func (a *API) fetchAllAddressTxs(address string, startBlock uint64) ([]Transaction, error) {
+	txs := []Transaction{}
+	for page := 1; ; page++ {
+		pageTxs, err := a.fetchAddressTxs(address, startBlock, page)
+		if errors.Is(err, ErrNoTransactionsFound) {
+			return txs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, pageTxs...)
+		if len(pageTxs) < a.pagingLimit {
+			return txs, nil
+		}
+
+		if page*a.pagingLimit+a.pagingLimit <= maxResultWindow {
+			continue
+		}
+
+		// The window is exhausted, so the txs of the last block are dropped
+		// to be fetched again, since they might be split between windows
+		last := txs[len(txs)-1].BlockHeight
+		i := len(txs)
+		for i > 0 && txs[i-1].BlockHeight == last {
+			i--
+		}
+
+		lastBlockHeight, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s invalid block number(%s), %s", a.chain, last, err.Error())
+		}
+
+		if lastBlockHeight == startBlock {
+			return nil, fmt.Errorf("%s address(%s) has more than %d txs in block#%d", a.chain, address, maxResultWindow, startBlock)
+		}
+
+		txs = txs[:i]
+		startBlock = lastBlockHeight
+		page = 0
 	}
+}
+
+// API call to https://api.etherscan.io/v2/api?chainid=&module=account&action=txlist&address=
+// For further info: https://docs.etherscan.io/etherscan-v2/api-endpoints/accounts
+func (a *API) fetchAddressTxs(address string, startBlock uint64, page int) ([]Transaction, error) {
+	query := url.Values{}
+	query.Set("module", "account")
+	query.Set("action", "txlist")
+	query.Set("address", address)
+	query.Set("startblock", strconv.FormatUint(startBlock, 10))
+	query.Set("endblock", strconv.FormatUint(lastBlock, 10))
+	query.Set("page", strconv.Itoa(page))
+	query.Set("offset", strconv.Itoa(a.pagingLimit))
+	query.Set("sort", "asc")
 
-	d, err := json.Marshal(r.Result)
+	r, err := a.get(query)
 	if err != nil {
 		return nil, err
 	}
 
 	txs := []Transaction{}
-	if err := json.Unmarshal(d, &txs); err != nil {
-		return nil, err
-	}
-
-	if r.Status != "0" && len(txs) == 0 {
-		return nil, errors.New(r.Message)
+	if err := json.Unmarshal(r.Result, &txs); err != nil {
+		return nil, fmt.Errorf("%s unexpected txlist result, %s", a.chain, err.Error())
 	}
 
 	return txs, nil
 }
 
-// API call to https://api.etherscan.io/api?module=block&action=getblocknobytime
-// For further info: https://etherscan.io/apis#blocks
+// API call to https://api.etherscan.io/v2/api?chainid=&module=block&action=getblocknobytime
+// For further info: https://docs.etherscan.io/etherscan-v2/api-endpoints/blocks
 func (a *API) fetchBlockHeightByTimestamp(timestamp int64) (uint64, error) {
-	defer time.Sleep(requestDelay)
-	url := fmt.Sprintf("https://api.etherscan.io/api?module=block&action=getblocknobytime&timestamp=%d&closest=before", timestamp)
-	r := &Response{}
-	if err := net.GetJSON(url, r); err != nil {
+	query := url.Values{}
+	query.Set("module", "block")
+	query.Set("action", "getblocknobytime")
+	query.Set("timestamp", strconv.FormatInt(timestamp, 10))
+	query.Set("closest", "before")
+
+	r, err := a.get(query)
+	if err != nil {
 		return 0, err
 	}
 
-	blockHeight, err := strconv.ParseUint(r.Result.(string), 10, 64)
+	var result string
+	if err := json.Unmarshal(r.Result, &result); err != nil {
+		return 0, fmt.Errorf("%s unexpected getblocknobytime result, %s", a.chain, err.Error())
+	}
+
+	blockHeight, err := strconv.ParseUint(result, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("status: %s, %s", r.Message, err.Error())
+		return 0, fmt.Errorf("%s status: %s, %s", a.chain, r.Message, err.Error())
 	}
 
 	return blockHeight, nil
 }
+
+// Makes a request with the given query and returns the response if its status is OK.
+// Rate limited requests are retried with backoff
+func (a *API) get(query url.Values) (*Response, error) {
+	query.Set("chainid", strconv.FormatUint(a.chain.ID, 10))
+	if a.apiKey != "" {
+		query.Set("apikey", a.apiKey)
+	}
+	u := fmt.Sprintf("%s?%s", a.url, query.Encode())
+
+	r := &Response{}
+	err := concurrency.RetryContext(context.Background(), rateLimitRetrial, func() error {
+		if err := a.c.GetJSON(context.Background(), u, r); err != nil {
+			return concurrency.Permanent(err)
+		}
+
+		err := checkResponse(r)
+		if err != nil && !errors.Is(err, ErrRateLimited) {
+			return concurrency.Permanent(err)
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Converts the responses with status 0 into errors
+func checkResponse(r *Response) error {
+	if r.Status == responseStatusOK {
+		return nil
+	}
+
+	if r.Message == messageNoTransactions {
+		return ErrNoTransactionsFound
+	}
+
+	var result string
+	json.Unmarshal(r.Result, &result)
+
+	if strings.Contains(strings.ToLower(result), "rate limit") {
+		return fmt.Errorf("%w, %s", ErrRateLimited, result)
+	}
+
+	return &APIError{Message: r.Message, Result: result}
+}
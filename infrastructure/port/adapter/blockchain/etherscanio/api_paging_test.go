@@ -0,0 +1,146 @@
+package etherscanio_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/etherscanio"
+)
+
+const testAddress = "0xde0b295669a9fd93d5f28d9ec85e40f4cb697bae"
+
+// Serves txlist like Etherscan does, only the first 10,000 txs of a query can be paged through
+func helperTxListServer(t *testing.T, txs []etherscanio.Transaction) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("apikey") != "secret" {
+			json.NewEncoder(w).Encode(map[string]string{"status": "0", "message": "NOTOK", "result": "Invalid API Key"})
+			return
+		}
+
+		if q.Get("sort") != "asc" {
+			t.Errorf("expected ascending order but got %s", q.Get("sort"))
+		}
+
+		start, _ := strconv.ParseUint(q.Get("startblock"), 10, 64)
+		page, _ := strconv.Atoi(q.Get("page"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		if page*offset > 10000 {
+			json.NewEncoder(w).Encode(map[string]string{"status": "0", "message": "NOTOK", "result": "Result window is too large"})
+			return
+		}
+
+		matched := []etherscanio.Transaction{}
+		for _, tx := range txs {
+			if bh, _ := strconv.ParseUint(tx.BlockHeight, 10, 64); bh >= start {
+				matched = append(matched, tx)
+			}
+		}
+
+		from := (page - 1) * offset
+		if from >= len(matched) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "0", "message": "No transactions found", "result": []string{}})
+			return
+		}
+		to := from + offset
+		if to > len(matched) {
+			to = len(matched)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "1", "message": "OK", "result": matched[from:to]})
+	}))
+}
+
+func helperTxs(n int, txsPerBlock int) []etherscanio.Transaction {
+	txs := make([]etherscanio.Transaction, n)
+	for i := range txs {
+		txs[i] = etherscanio.Transaction{
+			BlockHeight: strconv.Itoa(100 + i/txsPerBlock),
+			Hash:        fmt.Sprintf("0x%064x", i),
+			From:        "0x0000000000000000000000000000000000000001",
+			To:          testAddress,
+			Value:       "1",
+			Status:      "1",
+			Timestamp:   "1613721092",
+		}
+	}
+	return txs
+}
+
+func TestGetAccountMovements_Paging(t *testing.T) {
+	n := 12345
+	s := helperTxListServer(t, helperTxs(n, 3))
+	defer s.Close()
+
+	pagingLimit := 5000
+	api := etherscanio.NewAPI(s.URL, blockchain.Ethereum, etherscanio.EthereumTranslator{Chain: blockchain.Ethereum}, &pagingLimit)
+	api.SetAPIKey("secret")
+
+	mv, err := api.GetAccountMovements(testAddress, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != n {
+		t.Fatalf("expected %d transfers but got %d", n, len(mv.Transfers))
+	}
+
+	seen := make(map[string]bool)
+	for _, tr := range mv.Transfers {
+		if seen[tr.TxHash] {
+			t.Fatalf("expected no duplicate but got %s twice", tr.TxHash)
+		}
+		seen[tr.TxHash] = true
+	}
+}
+
+func TestGetAccountMovements_PagingErrors(t *testing.T) {
+	s := helperTxListServer(t, helperTxs(10, 1))
+	defer s.Close()
+
+	api := etherscanio.NewAPI(s.URL, blockchain.Ethereum, etherscanio.EthereumTranslator{Chain: blockchain.Ethereum})
+
+	var apiErr *etherscanio.APIError
+	if _, err := api.GetAccountMovements(testAddress, 0); !errors.As(err, &apiErr) || apiErr.Result != "Invalid API Key" {
+		t.Fatalf("expected an invalid api key error but got %v", err)
+	}
+
+	api.SetAPIKey("secret")
+	mv, err := api.GetAccountMovements(testAddress, 1000)
+	if err != nil {
+		t.Fatalf("expected no error when no transactions are found but got %v", err)
+	}
+
+	if len(mv.Transfers) != 0 {
+		t.Fatalf("expected no transfers but got %d", len(mv.Transfers))
+	}
+}
+
+func TestGetLatestBlockHeight_RateLimited(t *testing.T) {
+	calls := int32(0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			fmt.Fprint(w, `{"status":"0","message":"NOTOK","result":"Max calls per sec rate limit reached (5/sec)"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"1","message":"OK","result":"12000000"}`)
+	}))
+	defer s.Close()
+
+	api := etherscanio.NewAPI(s.URL, blockchain.Ethereum, etherscanio.EthereumTranslator{Chain: blockchain.Ethereum})
+	bh, err := api.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 12000000 || calls != 2 {
+		t.Fatalf("expected block#12000000 after a retrial but got block#%d after %d calls", bh, calls)
+	}
+}
@@ -1,16 +1,22 @@
-// +integration
-
 package etherscanio_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/etherscanio"
 )
 
 func TestGetAccountMovements(t *testing.T) {
 	blockNum := uint64(11000000)
-	api := etherscanio.NewAPI(etherscanio.EthereumTranslator{})
+	api := etherscanio.NewAPI(etherscanio.DefaultURL, blockchain.Ethereum, etherscanio.EthereumTranslator{Chain: blockchain.Ethereum})
+	c, save := helperReplayClient(t)
+	defer save()
+	api.SetHTTPClient(c)
+	api.SetAPIKey(os.Getenv("ETHERSCAN_API_KEY"))
 
 	mv, err := api.GetAccountMovements("0xde0b295669a9fd93d5f28d9ec85e40f4cb697bae", blockNum)
 	if err != nil {
@@ -23,7 +29,12 @@ func TestGetAccountMovements(t *testing.T) {
 }
 
 func TestGetLatestBlockHeight(t *testing.T) {
-	api := etherscanio.NewAPI(etherscanio.EthereumTranslator{})
+	api := etherscanio.NewAPI(etherscanio.DefaultURL, blockchain.Ethereum, etherscanio.EthereumTranslator{Chain: blockchain.Ethereum})
+	c, save := helperReplayClient(t, "timestamp")
+	defer save()
+	api.SetHTTPClient(c)
+	api.SetAPIKey(os.Getenv("ETHERSCAN_API_KEY"))
+
 	bh, err := api.GetLatestBlockHeight()
 	if err != nil {
 		t.Fatal(err)
@@ -33,3 +44,19 @@ func TestGetLatestBlockHeight(t *testing.T) {
 		t.Fatal("expected anything but 0")
 	}
 }
+
+// Returns a client replaying the responses recorded in the fixture of the running test
+// and a function saving the fixture, which records the responses when RECORD_FIXTURES=1
+func helperReplayClient(t *testing.T, ignoredParams ...string) (*net.HTTPClient, func()) {
+	t.Helper()
+	rt, err := net.NewReplayTransport(filepath.Join("testdata", "fixtures", t.Name()+".json"), ignoredParams...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return net.NewHTTPClient(&net.ClientOptions{Transport: rt}), func() {
+		if err := rt.Save(); err != nil {
+			t.Error(err)
+		}
+	}
+}
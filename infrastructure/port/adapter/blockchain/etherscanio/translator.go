@@ -9,8 +9,11 @@ import (
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
 )
 
-// EthereumTranslator is a translator for Etherscan.io API
-type EthereumTranslator struct{}
+// EthereumTranslator is a translator for Etherscan.io API. It serves
+// any EVM-compatible blockchain which is given by Chain
+type EthereumTranslator struct {
+	Chain blockchain.EVMChain
+}
 
 // ToAccountMovements converts data returning from third-party service to AccountMovement domain object
 func (et EthereumTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
@@ -26,12 +29,12 @@ func (et EthereumTranslator) ToAccountMovements(address string, v interface{}) (
 
 		blockHeight, err := strconv.ParseUint(tx.BlockHeight, 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("etherscanio ethereum transalation error, %s", err.Error())
+			return nil, fmt.Errorf("etherscanio %s translation error, %s", et.Chain, err.Error())
 		}
 
 		val, ok := new(big.Int).SetString(tx.Value, 10)
 		if !ok {
-			return nil, fmt.Errorf("etherscanio ethereum transalation error, cannot convert tx.Value(%s) to bigint", tx.Value)
+			return nil, fmt.Errorf("etherscanio %s translation error, cannot convert tx.Value(%s) to bigint", et.Chain, tx.Value)
 		}
 
 		from := blockchain.NormalizeEthereumAddress(tx.From)
@@ -40,12 +43,12 @@ func (et EthereumTranslator) ToAccountMovements(address string, v interface{}) (
 
 		// Any value transfers from this address will be reflected as a spent
 		if from == address {
-			am.Spend(blockHeight, timestamp, tx.Hash, val, to)
+			am.Spend(blockHeight, timestamp, tx.Hash, 0, val, to)
 		}
 
 		// Any value transfers to this address will be reflected as a receive
 		if to == address {
-			am.Receive(blockHeight, timestamp, tx.Hash, val, from)
+			am.Receive(blockHeight, timestamp, tx.Hash, 0, val, from)
 		}
 	}
 
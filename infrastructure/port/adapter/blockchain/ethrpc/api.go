@@ -0,0 +1,455 @@
+package ethrpc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// TransferTopic is the topic of ERC-20 Transfer(address,address,uint256) event
+const TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// Max. number of blocks to be scanned in one call or filtered in one eth_getLogs query
+const maxScanRange = 1000
+
+const receiptStatusSuccess = "0x1"
+
+// Transaction is a data structure returning from Ethereum JSON-RPC.
+// Status and Timestamp are filled by API from the receipt and the containing block
+type Transaction struct {
+	Hash        string `json:"hash"`
+	BlockNumber string `json:"blockNumber"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Value       string `json:"value"`
+	Status      string `json:"-"`
+	Timestamp   uint64 `json:"-"`
+}
+
+// Block is a data structure returning from Ethereum JSON-RPC's eth_getBlockByNumber
+type Block struct {
+	Number       string        `json:"number"`
+	Hash         string        `json:"hash"`
+	Timestamp    string        `json:"timestamp"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Receipt is a data structure returning from Ethereum JSON-RPC's eth_getTransactionReceipt
+type Receipt struct {
+	TransactionHash string `json:"transactionHash"`
+	Status          string `json:"status"`
+}
+
+// Log is a data structure returning from Ethereum JSON-RPC's eth_getLogs.
+// Timestamp is filled by API from the containing block
+type Log struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     string   `json:"blockNumber"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+	Removed         bool     `json:"removed"`
+	Timestamp       uint64   `json:"-"`
+}
+
+type logFilter struct {
+	FromBlock string        `json:"fromBlock"`
+	ToBlock   string        `json:"toBlock"`
+	Address   string        `json:"address"`
+	Topics    []interface{} `json:"topics"`
+}
+
+// API implements CurrencyAPI for Ethereum JSON-RPC of an EVM-compatible node such as geth,
+// erigon or anvil. It observes the native asset by scanning the blocks, or an ERC-20 token
+// by filtering the Transfer logs if a contract is given. Note that the native asset
+// transferred by internal calls of contracts is not observable by scanning the blocks.
+// It is a BlockScanner as well, so that each block is scanned once for all the accounts
+// by the block observer rather than once for each account
+type API struct {
+	rpc      *net.JSONRPCClient
+	contract string
+	t        blockchain.Translator
+}
+
+// NewAPI creates a new instance of API for the native asset of the chain
+func NewAPI(url string, username string, password string, t blockchain.Translator) *API {
+	return &API{
+		rpc: net.NewJSONRPCClient(url, username, password),
+		t:   t,
+	}
+}
+
+// NewTokenAPI creates a new instance of API for the ERC-20 token of the given contract
+func NewTokenAPI(url string, username string, password string, contract string, t blockchain.Translator) *API {
+	return &API{
+		rpc:      net.NewJSONRPCClient(url, username, password),
+		contract: blockchain.NormalizeEthereumAddress(contract),
+		t:        t,
+	}
+}
+
+// GetAccountMovements fetches token transfers of the given address since the given block height
+// up to the latest block, filtering the logs of maxScanRange blocks at a time. For the native asset,
+// it fetches the txs of the given address in at most maxScanRange blocks since the given block height,
+// and the returned movements tell the scanned height which the next call goes on from
+func (a *API) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	latest, err := a.GetLatestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	if sinceBlockHeight > latest {
+		return domain.NewAccountMovements(address), nil
+	}
+
+	if a.contract != "" {
+		logs, err := a.fetchTransferLogs(address, sinceBlockHeight, latest)
+		if err != nil {
+			return nil, err
+		}
+		return a.toAccountMovements(address, logs, latest)
+	}
+
+	to := latest
+	if latest-sinceBlockHeight >= maxScanRange {
+		to = sinceBlockHeight + maxScanRange - 1
+	}
+
+	txs, err := a.fetchAddressTxs(address, sinceBlockHeight, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.toAccountMovements(address, txs, to)
+}
+
+// NormalizeAddress returns the form of the given address which the addresses in blocks are matched in
+func (a *API) NormalizeAddress(address string) (string, error) {
+	return blockchain.NormalizeEthereumAddress(address), nil
+}
+
+// GetBlockMovements fetches txs or token transfers in the block at the given height and
+// converts them to the account movements of the watched addresses involved in them
+func (a *API) GetBlockMovements(blockHeight uint64, watched func(address string) bool) ([]*domain.AccountMovements, error) {
+	addresses := []string{}
+	var movementsOf func(address string) interface{}
+	if a.contract != "" {
+		logsOf, err := a.fetchBlockTransferLogs(blockHeight, watched, &addresses)
+		if err != nil {
+			return nil, err
+		}
+		movementsOf = func(address string) interface{} { return logsOf[address] }
+	} else {
+		txsOf, err := a.fetchBlockTxs(blockHeight, watched, &addresses)
+		if err != nil {
+			return nil, err
+		}
+		movementsOf = func(address string) interface{} { return txsOf[address] }
+	}
+
+	acms := make([]*domain.AccountMovements, 0, len(addresses))
+	for _, address := range addresses {
+		am, err := a.t.ToAccountMovements(address, movementsOf(address))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(am.Transfers) > 0 {
+			acms = append(acms, am)
+		}
+	}
+
+	return acms, nil
+}
+
+// GetLatestBlockHeight fetches the latest block number
+// For further info: https://ethereum.org/en/developers/docs/apis/json-rpc/#eth_blocknumber
+func (a *API) GetLatestBlockHeight() (uint64, error) {
+	var number string
+	if err := a.rpc.Call(&number, "eth_blockNumber"); err != nil {
+		return 0, err
+	}
+
+	return hexToUint64(number)
+}
+
+// RPC calls to eth_getBlockByNumber with full txs for each block in the range,
+// and to eth_getTransactionReceipt for the txs from/to the given address
+// For further info: https://ethereum.org/en/developers/docs/apis/json-rpc/#eth_getblockbynumber
+func (a *API) fetchAddressTxs(address string, from uint64, to uint64) ([]Transaction, error) {
+	address = blockchain.NormalizeEthereumAddress(address)
+	txs := []Transaction{}
+
+	watched := func(a string) bool { return a == address }
+	for h := from; h <= to; h++ {
+		txsOf, err := a.fetchBlockTxs(h, watched, nil)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, txsOf[address]...)
+	}
+
+	return txs, nil
+}
+
+// Fetches the block at the given height and returns the txs of each watched address with the
+// status of their receipts. The addresses are appended to the given ones, if any, in the order
+// of their first appearance in the block
+func (a *API) fetchBlockTxs(height uint64, watched func(address string) bool, addresses *[]string) (map[string][]Transaction, error) {
+	b, err := a.fetchBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := hexToUint64(b.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	txsOf := make(map[string][]Transaction)
+	for _, tx := range b.Transactions {
+		var involved []string
+		from := blockchain.NormalizeEthereumAddress(tx.From)
+		if watched(from) {
+			involved = append(involved, from)
+		}
+		// Contract creation txs have no recipient
+		if tx.To != "" {
+			if to := blockchain.NormalizeEthereumAddress(tx.To); to != from && watched(to) {
+				involved = append(involved, to)
+			}
+		}
+
+		if len(involved) == 0 {
+			continue
+		}
+
+		r := &Receipt{}
+		if err := a.rpc.Call(r, "eth_getTransactionReceipt", tx.Hash); err != nil {
+			return nil, err
+		}
+
+		tx.Status = r.Status
+		tx.Timestamp = timestamp
+		for _, address := range involved {
+			if _, exist := txsOf[address]; !exist && addresses != nil {
+				*addresses = append(*addresses, address)
+			}
+			txsOf[address] = append(txsOf[address], tx)
+		}
+	}
+
+	return txsOf, nil
+}
+
+// RPC call to eth_getLogs for all the Transfer events of the token in the block at the given height.
+// Returns the logs of each watched address, appending the addresses to the given ones in the order
+// of their first appearance in the block
+func (a *API) fetchBlockTransferLogs(height uint64, watched func(address string) bool, addresses *[]string) (map[string][]Log, error) {
+	ls := []Log{}
+	if err := a.rpc.Call(&ls, "eth_getLogs", &logFilter{
+		FromBlock: uint64ToHex(height),
+		ToBlock:   uint64ToHex(height),
+		Address:   a.contract,
+		Topics:    []interface{}{TransferTopic},
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := a.fillTimestamps(ls); err != nil {
+		return nil, err
+	}
+
+	logsOf := make(map[string][]Log)
+	for _, l := range ls {
+		// Non-standard Transfer events are skipped by the translator as well
+		if len(l.Topics) != 3 {
+			continue
+		}
+
+		var involved []string
+		from := topicToAddress(l.Topics[1])
+		if watched(from) {
+			involved = append(involved, from)
+		}
+		if to := topicToAddress(l.Topics[2]); to != from && watched(to) {
+			involved = append(involved, to)
+		}
+
+		for _, address := range involved {
+			if _, exist := logsOf[address]; !exist {
+				*addresses = append(*addresses, address)
+			}
+			logsOf[address] = append(logsOf[address], l)
+		}
+	}
+
+	return logsOf, nil
+}
+
+// Converts the given txs or logs to the account movements of the given address up to the scanned height
+func (a *API) toAccountMovements(address string, v interface{}, scannedHeight uint64) (*domain.AccountMovements, error) {
+	am, err := a.t.ToAccountMovements(address, v)
+	if err != nil {
+		return nil, err
+	}
+
+	am.ScannedHeight = scannedHeight
+	return am, nil
+}
+
+// RPC calls to eth_getLogs for the Transfer events from and to the given address,
+// in maxScanRange blocks at a time since the nodes limit the range of a query
+// For further info: https://ethereum.org/en/developers/docs/apis/json-rpc/#eth_getlogs
+func (a *API) fetchTransferLogs(address string, from uint64, to uint64) ([]Log, error) {
+	topic := addressToTopic(address)
+	filters := [][]interface{}{
+		{TransferTopic, topic},
+		{TransferTopic, nil, topic},
+	}
+
+	logs := []Log{}
+	seen := make(map[string]bool)
+	for start := from; start <= to; start += maxScanRange {
+		end := to
+		if to-start >= maxScanRange {
+			end = start + maxScanRange - 1
+		}
+
+		for _, topics := range filters {
+			ls := []Log{}
+			if err := a.rpc.Call(&ls, "eth_getLogs", &logFilter{
+				FromBlock: uint64ToHex(start),
+				ToBlock:   uint64ToHex(end),
+				Address:   a.contract,
+				Topics:    topics,
+			}); err != nil {
+				return nil, err
+			}
+
+			// Transfers to self match with both of the filters
+			for _, l := range ls {
+				key := l.TransactionHash + l.LogIndex
+				if !seen[key] {
+					seen[key] = true
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+
+	if err := a.fillTimestamps(logs); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		bi, _ := hexToUint64(logs[i].BlockNumber)
+		bj, _ := hexToUint64(logs[j].BlockNumber)
+		if bi != bj {
+			return bi < bj
+		}
+		li, _ := hexToUint64(logs[i].LogIndex)
+		lj, _ := hexToUint64(logs[j].LogIndex)
+		return li < lj
+	})
+
+	return logs, nil
+}
+
+// Logs do not contain the block timestamp, so the headers of the blocks are fetched
+func (a *API) fillTimestamps(logs []Log) error {
+	timestamps := make(map[string]uint64)
+	for i := range logs {
+		number := logs[i].BlockNumber
+		if _, exist := timestamps[number]; !exist {
+			h, err := hexToUint64(number)
+			if err != nil {
+				return err
+			}
+
+			if timestamps[number], err = a.fetchBlockTimestamp(h); err != nil {
+				return err
+			}
+		}
+		logs[i].Timestamp = timestamps[number]
+	}
+
+	return nil
+}
+
+func (a *API) fetchBlock(height uint64) (*Block, error) {
+	b := &Block{}
+	if err := a.rpc.Call(b, "eth_getBlockByNumber", uint64ToHex(height), true); err != nil {
+		return nil, err
+	}
+
+	if b.Hash == "" {
+		return nil, fmt.Errorf("block#%d is not found", height)
+	}
+
+	return b, nil
+}
+
+// Only the header is decoded since the txs are returned as hashes
+func (a *API) fetchBlockTimestamp(height uint64) (uint64, error) {
+	header := &struct {
+		Hash      string `json:"hash"`
+		Timestamp string `json:"timestamp"`
+	}{}
+	if err := a.rpc.Call(header, "eth_getBlockByNumber", uint64ToHex(height), false); err != nil {
+		return 0, err
+	}
+
+	if header.Hash == "" {
+		return 0, fmt.Errorf("block#%d is not found", height)
+	}
+
+	return hexToUint64(header.Timestamp)
+}
+
+// Left-pads the given address to 32 bytes
+func addressToTopic(address string) string {
+	address = strings.TrimPrefix(blockchain.NormalizeEthereumAddress(address), "0x")
+	return "0x" + strings.Repeat("0", 64-len(address)) + address
+}
+
+// Extracts the address from the given 32-byte topic
+func topicToAddress(topic string) string {
+	topic = strings.TrimPrefix(topic, "0x")
+	if len(topic) > 40 {
+		topic = topic[len(topic)-40:]
+	}
+	return blockchain.NormalizeEthereumAddress(topic)
+}
+
+func uint64ToHex(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+func hexToUint64(s string) (uint64, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot convert hex(%s) to uint64", s)
+	}
+	return n, nil
+}
+
+func hexToBigInt(s string) (*big.Int, error) {
+	s = strings.TrimPrefix(s, "0x")
+	if s == "" {
+		return new(big.Int), nil
+	}
+
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert hex(0x%s) to bigint", s)
+	}
+	return n, nil
+}
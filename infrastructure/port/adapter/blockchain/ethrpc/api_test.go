@@ -0,0 +1,342 @@
+package ethrpc_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/ethrpc"
+)
+
+const (
+	addr1    = "0xde0b295669a9fd93d5f28d9ec85e40f4cb697bae"
+	addr2    = "0x8ba1f109551bd432803012645ac136ddd64dba72"
+	contract = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	topic1   = "0x000000000000000000000000de0b295669a9fd93d5f28d9ec85e40f4cb697bae"
+	topic2   = "0x0000000000000000000000008ba1f109551bd432803012645ac136ddd64dba72"
+)
+
+var blocks = map[string]string{
+	"0x64": `{
+		"number": "0x64", "hash": "0xb100", "timestamp": "0x5ffe1c49",
+		"transactions": [
+			{"hash": "0xt1", "blockNumber": "0x64", "from": "` + addr2 + `", "to": "0xDE0B295669A9FD93D5F28D9EC85E40F4CB697BAE", "value": "0xde0b6b3a7640000"},
+			{"hash": "0xt2", "blockNumber": "0x64", "from": "` + addr1 + `", "to": "` + addr2 + `", "value": "0x1"},
+			{"hash": "0xt3", "blockNumber": "0x64", "from": "` + addr2 + `", "to": null, "value": "0x0"}
+		]
+	}`,
+	"0x65": `{
+		"number": "0x65", "hash": "0xb101", "timestamp": "0x5ffe1e9d",
+		"transactions": [
+			{"hash": "0xt4", "blockNumber": "0x65", "from": "` + addr1 + `", "to": "` + addr2 + `", "value": "0x2386f26fc10000"}
+		]
+	}`,
+}
+
+var receipts = map[string]string{
+	"0xt1": `{"transactionHash": "0xt1", "status": "0x1"}`,
+	"0xt2": `{"transactionHash": "0xt2", "status": "0x0"}`,
+	"0xt4": `{"transactionHash": "0xt4", "status": "0x1"}`,
+}
+
+var logs = []string{
+	`{"address": "` + contract + `", "topics": ["` + ethrpc.TransferTopic + `", "` + topic2 + `", "` + topic1 + `"], "data": "0x00000000000000000000000000000000000000000000000000000000000f4240", "blockNumber": "0x65", "transactionHash": "0xt6", "logIndex": "0x3", "removed": false}`,
+	`{"address": "` + contract + `", "topics": ["` + ethrpc.TransferTopic + `", "` + topic1 + `", "` + topic2 + `"], "data": "0x00000000000000000000000000000000000000000000000000000000000186a0", "blockNumber": "0x64", "transactionHash": "0xt5", "logIndex": "0x7", "removed": false}`,
+	`{"address": "` + contract + `", "topics": ["` + ethrpc.TransferTopic + `", "` + topic2 + `", "` + topic1 + `"], "data": "0x01", "blockNumber": "0x65", "transactionHash": "0xt7", "logIndex": "0x0", "removed": true}`,
+}
+
+type rpcRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type logFilter struct {
+	FromBlock string    `json:"fromBlock"`
+	ToBlock   string    `json:"toBlock"`
+	Address   string    `json:"address"`
+	Topics    []*string `json:"topics"`
+}
+
+// Stub for Ethereum JSON-RPC server
+func newStubServer(t *testing.T) *httptest.Server {
+	return newStubServerAt(t, 0x65)
+}
+
+// Stub for Ethereum JSON-RPC server whose blocks after 0x65 up to the given latest one are empty
+func newStubServerAt(t *testing.T, latest uint64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &rpcRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result := "null"
+		switch req.Method {
+		case "eth_blockNumber":
+			result = `"0x` + strconv.FormatUint(latest, 16) + `"`
+		case "eth_getBlockByNumber":
+			var number string
+			json.Unmarshal(req.Params[0], &number)
+			if b, ok := blocks[number]; ok {
+				result = b
+			} else if h := hexToUint64(t, number); h > 0x65 && h <= latest {
+				result = `{"number": "` + number + `", "hash": "0xb` + number[2:] + `", "timestamp": "0x5ffe1e9d", "transactions": []}`
+			}
+		case "eth_getTransactionReceipt":
+			var hash string
+			json.Unmarshal(req.Params[0], &hash)
+			if r, ok := receipts[hash]; ok {
+				result = r
+			}
+		case "eth_getLogs":
+			f := &logFilter{}
+			json.Unmarshal(req.Params[0], f)
+			if hexToUint64(t, f.ToBlock)-hexToUint64(t, f.FromBlock) >= 1000 {
+				t.Errorf("expected to filter at most %d blocks but got %s-%s", 1000, f.FromBlock, f.ToBlock)
+			}
+			result = "[" + matchingLogs(t, f) + "]"
+		default:
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32601, "message": "method not found"}}`))
+			return
+		}
+
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": ` + result + `}`))
+	}))
+}
+
+func matchingLogs(t *testing.T, f *logFilter) string {
+	matching := ""
+	for _, s := range logs {
+		l := &ethrpc.Log{}
+		if err := json.Unmarshal([]byte(s), l); err != nil {
+			t.Error(err)
+		}
+
+		h := hexToUint64(t, l.BlockNumber)
+		if l.Address != f.Address || h < hexToUint64(t, f.FromBlock) || h > hexToUint64(t, f.ToBlock) {
+			continue
+		}
+
+		match := true
+		for i, topic := range f.Topics {
+			if topic != nil && *topic != l.Topics[i] {
+				match = false
+			}
+		}
+
+		if match {
+			if matching != "" {
+				matching += ","
+			}
+			matching += s
+		}
+	}
+	return matching
+}
+
+func hexToUint64(t *testing.T, s string) uint64 {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		t.Error(err)
+	}
+	return n
+}
+
+func checkTransfers(t *testing.T, mv *domain.AccountMovements, expected []*domain.Transfer) {
+	if len(mv.Transfers) != len(expected) {
+		t.Fatalf("expected transfer count is %d but got %d", len(expected), len(mv.Transfers))
+	}
+
+	for i, e := range expected {
+		tr := mv.Transfers[i]
+		if tr.Type != e.Type ||
+			tr.Amount.Cmp(e.Amount) != 0 ||
+			tr.BlockHeight != e.BlockHeight ||
+			tr.Timestamp != e.Timestamp ||
+			tr.TxHash != e.TxHash ||
+			tr.Index != e.Index ||
+			tr.Address != e.Address {
+			t.Fatalf("expected transfer#%d is %+v but got %+v", i, e, tr)
+		}
+	}
+}
+
+func TestGetLatestBlockHeight(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewAPI(s.URL, "", "", ethrpc.EthereumTranslator{Chain: blockchain.Ethereum})
+	bh, err := api.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 101 {
+		t.Fatalf("expected latest block height is 101 but got %d", bh)
+	}
+}
+
+func TestGetAccountMovements(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewAPI(s.URL, "", "", ethrpc.EthereumTranslator{Chain: blockchain.Ethereum})
+	mv, err := api.GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed tx(0xt2) should be skipped
+	checkTransfers(t, mv, []*domain.Transfer{
+		{Type: domain.Received, Amount: big.NewInt(1000000000000000000), BlockHeight: 100, Timestamp: 1610488905, TxHash: "0xt1", Address: addr2},
+		{Type: domain.Spent, Amount: big.NewInt(10000000000000000), BlockHeight: 101, Timestamp: 1610489501, TxHash: "0xt4", Address: addr2},
+	})
+}
+
+func TestGetAccountMovements_Token(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewTokenAPI(s.URL, "", "", contract, ethrpc.ERC20Translator{Chain: blockchain.Ethereum})
+	mv, err := api.GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The removed log(0xt7) should be skipped
+	checkTransfers(t, mv, []*domain.Transfer{
+		{Type: domain.Spent, Amount: big.NewInt(100000), BlockHeight: 100, Timestamp: 1610488905, TxHash: "0xt5", Index: 7, Address: addr2},
+		{Type: domain.Received, Amount: big.NewInt(1000000), BlockHeight: 101, Timestamp: 1610489501, TxHash: "0xt6", Index: 3, Address: addr2},
+	})
+}
+
+func TestGetAccountMovements_UpToDate(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewAPI(s.URL, "", "", ethrpc.EthereumTranslator{Chain: blockchain.Ethereum})
+	mv, err := api.GetAccountMovements(addr1, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mv.Transfers) != 0 {
+		t.Fatalf("expected no transfers but got %d", len(mv.Transfers))
+	}
+}
+
+func TestGetAccountMovements_MissingBlock(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewAPI(s.URL, "", "", ethrpc.EthereumTranslator{Chain: blockchain.Ethereum})
+	if _, err := api.GetAccountMovements(addr1, 99); err == nil {
+		t.Fatal("expected an error for missing block but got nothing")
+	}
+}
+
+func TestGetAccountMovements_ScanRange(t *testing.T) {
+	s := newStubServerAt(t, 2600)
+	defer s.Close()
+
+	api := ethrpc.NewAPI(s.URL, "", "", ethrpc.EthereumTranslator{Chain: blockchain.Ethereum})
+	mv, err := api.GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// At most 1000 blocks are scanned in one call
+	if mv.ScannedHeight != 1099 {
+		t.Fatalf("expected scanned height is %d but got %d", 1099, mv.ScannedHeight)
+	}
+
+	if len(mv.Transfers) != 2 {
+		t.Fatalf("expected transfer count is %d but got %d", 2, len(mv.Transfers))
+	}
+
+	// Goes on from the scanned height even though nothing is found
+	mv, err = api.GetAccountMovements(addr1, mv.ScannedHeight+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mv.ScannedHeight != 2099 || len(mv.Transfers) != 0 {
+		t.Fatalf("expected no transfers up to %d but got %d up to %d", 2099, len(mv.Transfers), mv.ScannedHeight)
+	}
+}
+
+func TestGetAccountMovements_TokenScanRange(t *testing.T) {
+	s := newStubServerAt(t, 2600)
+	defer s.Close()
+
+	// The logs are filtered in chunks up to the latest block
+	api := ethrpc.NewTokenAPI(s.URL, "", "", contract, ethrpc.ERC20Translator{Chain: blockchain.Ethereum})
+	mv, err := api.GetAccountMovements(addr1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mv.ScannedHeight != 2600 {
+		t.Fatalf("expected scanned height is %d but got %d", 2600, mv.ScannedHeight)
+	}
+
+	if len(mv.Transfers) != 2 {
+		t.Fatalf("expected transfer count is %d but got %d", 2, len(mv.Transfers))
+	}
+}
+
+func TestGetBlockMovements(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewAPI(s.URL, "", "", ethrpc.EthereumTranslator{Chain: blockchain.Ethereum})
+	watched, err := api.NormalizeAddress("0xDE0B295669A9FD93D5F28D9EC85E40F4CB697BAE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acms, err := api.GetBlockMovements(100, func(address string) bool { return address == watched || address == addr2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Accounts in the order of their first appearance. The failed tx(0xt2)
+	// and the contract creation tx(0xt3) without a receipt are skipped
+	if len(acms) != 2 || acms[0].Address != addr2 || acms[1].Address != addr1 {
+		t.Fatalf("expected the movements of %s and %s but got %d accounts", addr2, addr1, len(acms))
+	}
+
+	checkTransfers(t, acms[0], []*domain.Transfer{
+		{Type: domain.Spent, Amount: big.NewInt(1000000000000000000), BlockHeight: 100, Timestamp: 1610488905, TxHash: "0xt1", Address: addr1},
+	})
+	checkTransfers(t, acms[1], []*domain.Transfer{
+		{Type: domain.Received, Amount: big.NewInt(1000000000000000000), BlockHeight: 100, Timestamp: 1610488905, TxHash: "0xt1", Address: addr2},
+	})
+}
+
+func TestGetBlockMovements_Token(t *testing.T) {
+	s := newStubServer(t)
+	defer s.Close()
+
+	api := ethrpc.NewTokenAPI(s.URL, "", "", contract, ethrpc.ERC20Translator{Chain: blockchain.Ethereum})
+	acms, err := api.GetBlockMovements(101, func(address string) bool { return address == addr1 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The removed log(0xt7) should be skipped
+	if len(acms) != 1 || acms[0].Address != addr1 {
+		t.Fatalf("expected the movements of %s but got %d accounts", addr1, len(acms))
+	}
+
+	checkTransfers(t, acms[0], []*domain.Transfer{
+		{Type: domain.Received, Amount: big.NewInt(1000000), BlockHeight: 101, Timestamp: 1610489501, TxHash: "0xt6", Index: 3, Address: addr2},
+	})
+}
@@ -0,0 +1,106 @@
+package ethrpc
+
+import (
+	"fmt"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+// EthereumTranslator is a translator for the native asset over Ethereum JSON-RPC.
+// It serves any EVM-compatible blockchain which is given by Chain
+type EthereumTranslator struct {
+	Chain blockchain.EVMChain
+}
+
+// ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
+func (tr EthereumTranslator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
+	txs, _ := v.([]Transaction)
+	am := domain.NewAccountMovements(address)
+	address = blockchain.NormalizeEthereumAddress(address)
+
+	for _, tx := range txs {
+		// Do not include reverted/failed transactions
+		if tx.Status != receiptStatusSuccess {
+			continue
+		}
+
+		blockHeight, err := hexToUint64(tx.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("ethrpc %s translation error, %s", tr.Chain, err.Error())
+		}
+
+		val, err := hexToBigInt(tx.Value)
+		if err != nil {
+			return nil, fmt.Errorf("ethrpc %s translation error, %s", tr.Chain, err.Error())
+		}
+
+		from := blockchain.NormalizeEthereumAddress(tx.From)
+		to := ""
+		if tx.To != "" {
+			to = blockchain.NormalizeEthereumAddress(tx.To)
+		}
+
+		// Any value transfers from this address will be reflected as a spent
+		if from == address {
+			am.Spend(blockHeight, tx.Timestamp, tx.Hash, 0, val, to)
+		}
+
+		// Any value transfers to this address will be reflected as a receive
+		if to == address {
+			am.Receive(blockHeight, tx.Timestamp, tx.Hash, 0, val, from)
+		}
+	}
+
+	return am, nil
+}
+
+// ERC20Translator is a translator for ERC-20 Transfer logs over Ethereum JSON-RPC
+type ERC20Translator struct {
+	Chain blockchain.EVMChain
+}
+
+// ToAccountMovements converts data returning from third-party service to domain.AccountMovement value
+func (tr ERC20Translator) ToAccountMovements(address string, v interface{}) (*domain.AccountMovements, error) {
+	logs, _ := v.([]Log)
+	am := domain.NewAccountMovements(address)
+	address = blockchain.NormalizeEthereumAddress(address)
+
+	for _, l := range logs {
+		// Logs of the blocks removed by a reorg and
+		// the non-standard Transfer events are not included
+		if l.Removed || len(l.Topics) != 3 || l.Topics[0] != TransferTopic {
+			continue
+		}
+
+		blockHeight, err := hexToUint64(l.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("ethrpc %s erc20 translation error, %s", tr.Chain, err.Error())
+		}
+
+		index, err := hexToUint64(l.LogIndex)
+		if err != nil {
+			return nil, fmt.Errorf("ethrpc %s erc20 translation error, %s", tr.Chain, err.Error())
+		}
+
+		val, err := hexToBigInt(l.Data)
+		if err != nil {
+			return nil, fmt.Errorf("ethrpc %s erc20 translation error, %s", tr.Chain, err.Error())
+		}
+
+		from := topicToAddress(l.Topics[1])
+		to := topicToAddress(l.Topics[2])
+
+		// Any token transfers from this address will be reflected as a spent
+		if from == address {
+			am.Spend(blockHeight, l.Timestamp, l.TransactionHash, uint(index), val, to)
+		}
+
+		// Any token transfers to this address will be reflected as a receive
+		if to == address {
+			am.Receive(blockHeight, l.Timestamp, l.TransactionHash, uint(index), val, from)
+		}
+	}
+
+	return am, nil
+}
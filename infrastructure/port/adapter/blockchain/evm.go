@@ -0,0 +1,45 @@
+package blockchain
+
+import "fmt"
+
+// Chain IDs of the well-known EVM-compatible blockchains
+const (
+	EthereumChainID uint64 = 1
+	OptimismChainID uint64 = 10
+	BSCChainID      uint64 = 56
+	PolygonChainID  uint64 = 137
+	ArbitrumChainID uint64 = 42161
+)
+
+var knownEVMChains = map[uint64]EVMChain{
+	EthereumChainID: {ID: EthereumChainID, Name: "Ethereum", NativeSymbol: "eth"},
+	OptimismChainID: {ID: OptimismChainID, Name: "Optimism", NativeSymbol: "eth"},
+	BSCChainID:      {ID: BSCChainID, Name: "BNB Smart Chain", NativeSymbol: "bnb"},
+	PolygonChainID:  {ID: PolygonChainID, Name: "Polygon", NativeSymbol: "pol"},
+	ArbitrumChainID: {ID: ArbitrumChainID, Name: "Arbitrum One", NativeSymbol: "eth"},
+}
+
+// EVMChain identifies an EVM-compatible blockchain
+type EVMChain struct {
+	ID           uint64
+	Name         string
+	NativeSymbol string
+}
+
+// Ethereum is the Ethereum mainnet
+var Ethereum = knownEVMChains[EthereumChainID]
+
+// KnownEVMChain returns the well-known EVM chain with the given chain ID
+func KnownEVMChain(id uint64) (EVMChain, bool) {
+	c, ok := knownEVMChains[id]
+	return c, ok
+}
+
+// String returns a human-readable identifier of the chain such as "Polygon(137)"
+func (c EVMChain) String() string {
+	name := c.Name
+	if name == "" {
+		name = c.NativeSymbol
+	}
+	return fmt.Sprintf("%s(%d)", name, c.ID)
+}
@@ -0,0 +1,42 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+)
+
+func TestKnownEVMChain(t *testing.T) {
+	cases := map[uint64]string{
+		blockchain.EthereumChainID: "eth",
+		blockchain.OptimismChainID: "eth",
+		blockchain.BSCChainID:      "bnb",
+		blockchain.PolygonChainID:  "pol",
+		blockchain.ArbitrumChainID: "eth",
+	}
+
+	for id, symbol := range cases {
+		c, ok := blockchain.KnownEVMChain(id)
+		if !ok {
+			t.Fatalf("expected chain#%d to be known but it is not", id)
+		}
+
+		if c.ID != id || c.NativeSymbol != symbol {
+			t.Fatalf("expected chain#%d with native symbol %s but got %+v", id, symbol, c)
+		}
+	}
+
+	if _, ok := blockchain.KnownEVMChain(31337); ok {
+		t.Fatal("expected chain#31337 not to be known but it is")
+	}
+}
+
+func TestEVMChain_String(t *testing.T) {
+	if s := blockchain.Ethereum.String(); s != "Ethereum(1)" {
+		t.Fatalf("expected Ethereum(1) but got %s", s)
+	}
+
+	if s := (blockchain.EVMChain{ID: 31337, NativeSymbol: "dev"}).String(); s != "dev(31337)" {
+		t.Fatalf("expected dev(31337) but got %s", s)
+	}
+}
@@ -0,0 +1,360 @@
+package simulated
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// Nominal time in-between blocks when they are mined on demand
+const defaultBlockTime = time.Minute
+
+// Tx is a value transfer on the simulated blockchain
+type Tx struct {
+	Hash   string
+	From   string
+	To     string
+	Amount *big.Int
+	// Set for the txs generated by a repeating transfer, which are
+	// generated again rather than returned to pending upon reorgs
+	repeated bool
+}
+
+// Transfers sent every given number of blocks
+type repeatingTransfer struct {
+	every    uint64
+	from     string
+	to       string
+	amount   *big.Int
+	sequence int
+}
+
+// ChainOptions represents configurables for Chain
+type ChainOptions struct {
+	// BlockInterval is the time in-between blocks. If set, the blocks are mined on a timer
+	// since GenesisTime, otherwise they are only mined on demand by Mine
+	BlockInterval time.Duration
+	// GenesisTime is the timestamp of the genesis block. Defaults to the creation time of the chain.
+	// The chains with the same options and scripted transfers are identical at the same time
+	GenesisTime time.Time
+}
+
+// Chain is a deterministic in-memory blockchain implementing CurrencyService and BlockScanner,
+// so that the observers run without a live blockchain. Transfers are scripted for any addresses.
+// They are pending until they are mined, and mined blocks can be orphaned by reorgs
+type Chain struct {
+	mu            sync.Mutex
+	height        uint64
+	blockInterval time.Duration
+	genesisTime   time.Time
+	blocks        map[uint64][]*Tx
+	pending       []*Tx
+	scheduled     map[uint64][]*Tx
+	repeating     []*repeatingTransfer
+	sequence      int
+}
+
+// NewChain creates a new instance of Chain with the genesis block only
+func NewChain(opts ...*ChainOptions) *Chain {
+	c := &Chain{
+		genesisTime: time.Now(),
+		blocks:      make(map[uint64][]*Tx),
+		scheduled:   make(map[uint64][]*Tx),
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+
+		c.blockInterval = opt.BlockInterval
+		if !opt.GenesisTime.IsZero() {
+			c.genesisTime = opt.GenesisTime
+		}
+	}
+
+	return c
+}
+
+// Send sends the given amount from an address to another. The tx is pending until the next block is mined
+func (c *Chain) Send(from, to string, amount *big.Int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx := c.newTx(from, to, amount)
+	c.pending = append(c.pending, tx)
+
+	return tx.Hash
+}
+
+// SendAt sends the given amount from an address to another in the block at the given height.
+// Returns an error if the block is already mined. On a timer, the due blocks are only mined
+// when the chain is read, so the transfers scripted beforehand are sent at any height
+func (c *Chain) SendAt(blockHeight uint64, from, to string, amount *big.Int) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if blockHeight <= c.height {
+		return "", fmt.Errorf("block#%d is already mined", blockHeight)
+	}
+
+	tx := c.newTx(from, to, amount)
+	c.scheduled[blockHeight] = append(c.scheduled[blockHeight], tx)
+
+	return tx.Hash, nil
+}
+
+// SendEvery sends the given amount from an address to another
+// in every block whose height is a multiple of the given one
+func (c *Chain) SendEvery(every uint64, from, to string, amount *big.Int) error {
+	if every == 0 {
+		return fmt.Errorf("transfer cannot be sent every 0 blocks")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.repeating = append(c.repeating, &repeatingTransfer{
+		every:    every,
+		from:     strings.TrimSpace(from),
+		to:       strings.TrimSpace(to),
+		amount:   new(big.Int).Set(amount),
+		sequence: len(c.repeating),
+	})
+
+	return nil
+}
+
+// Pending returns the txs waiting for the next block
+func (c *Chain) Pending() []*Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	return append([]*Tx{}, c.pending...)
+}
+
+// Drop drops the pending tx with the given hash as if it was evicted from the mempool
+// or replaced by a conflicting tx. Returns false if there is no such pending tx
+func (c *Chain) Drop(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	for i, tx := range c.pending {
+		if tx.Hash == hash {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Mine mines the given number of blocks and returns the latest block height. The pending
+// txs are included in the first one of them along with the txs scripted for each block
+func (c *Chain) Mine(n int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	for i := 0; i < n; i++ {
+		c.mine()
+	}
+
+	return c.height
+}
+
+// Reorg orphans the given number of the latest blocks. Their txs return to pending,
+// except the ones of the repeating transfers, which are sent again in the new blocks.
+// Returns the latest block height after the reorg. On a timer, the orphaned
+// blocks are replaced by new ones as soon as the chain is read again
+func (c *Chain) Reorg(depth uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	if depth > c.height {
+		return 0, fmt.Errorf("reorg depth(%d) is more than the latest block height(%d)", depth, c.height)
+	}
+
+	orphaned := make([]*Tx, 0)
+	for h := c.height - depth + 1; h <= c.height; h++ {
+		for _, tx := range c.blocks[h] {
+			if !tx.repeated {
+				orphaned = append(orphaned, tx)
+			}
+		}
+		delete(c.blocks, h)
+	}
+
+	c.pending = append(orphaned, c.pending...)
+	c.height -= depth
+
+	return c.height, nil
+}
+
+// GetAccountMovements returns the movements of the given address since the given block height
+func (c *Chain) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	normalized, _ := c.NormalizeAddress(address)
+	am := domain.NewAccountMovements(address)
+	for _, h := range c.heights(sinceBlockHeight) {
+		c.appendMovements(am, normalized, h)
+	}
+
+	return am, nil
+}
+
+// GetLatestBlockHeight returns the height of the latest mined block
+func (c *Chain) GetLatestBlockHeight() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	return c.height, nil
+}
+
+// NormalizeAddress returns the given address without the surrounding spaces.
+// Addresses are matched as they are scripted regardless of the chain family
+func (c *Chain) NormalizeAddress(address string) (string, error) {
+	return strings.TrimSpace(address), nil
+}
+
+// GetBlockMovements returns the movements of the watched addresses in the block at the given height
+func (c *Chain) GetBlockMovements(blockHeight uint64, watched func(address string) bool) ([]*domain.AccountMovements, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sync()
+
+	if blockHeight > c.height {
+		return nil, fmt.Errorf("block#%d is not mined yet", blockHeight)
+	}
+
+	addresses := make([]string, 0)
+	found := make(map[string]bool)
+	for _, tx := range c.blocks[blockHeight] {
+		for _, address := range []string{tx.From, tx.To} {
+			if !found[address] && watched(address) {
+				found[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	acms := make([]*domain.AccountMovements, 0, len(addresses))
+	for _, address := range addresses {
+		am := domain.NewAccountMovements(address)
+		c.appendMovements(am, address, blockHeight)
+		acms = append(acms, am)
+	}
+
+	return acms, nil
+}
+
+// Mines the blocks which are due on the timer
+func (c *Chain) sync() {
+	if c.blockInterval <= 0 {
+		return
+	}
+
+	elapsed := time.Since(c.genesisTime)
+	if elapsed < 0 {
+		return
+	}
+
+	for target := uint64(elapsed / c.blockInterval); c.height < target; {
+		c.mine()
+	}
+}
+
+func (c *Chain) mine() {
+	c.height++
+
+	txs := append(c.pending, c.scheduled[c.height]...)
+	for _, r := range c.repeating {
+		if c.height%r.every == 0 {
+			txs = append(txs, &Tx{
+				Hash:     hash("repeating", r.sequence, c.height),
+				From:     r.from,
+				To:       r.to,
+				Amount:   r.amount,
+				repeated: true,
+			})
+		}
+	}
+
+	if len(txs) > 0 {
+		c.blocks[c.height] = txs
+	}
+	c.pending = nil
+	delete(c.scheduled, c.height)
+}
+
+// Returns the heights of the mined blocks with txs at or after the given height in ascending order
+func (c *Chain) heights(since uint64) []uint64 {
+	heights := make([]uint64, 0)
+	for h := range c.blocks {
+		if h >= since {
+			heights = append(heights, h)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	return heights
+}
+
+func (c *Chain) appendMovements(am *domain.AccountMovements, address string, blockHeight uint64) {
+	timestamp := c.timestamp(blockHeight)
+	for _, tx := range c.blocks[blockHeight] {
+		if tx.From == address {
+			am.Spend(blockHeight, timestamp, tx.Hash, 0, tx.Amount, tx.To)
+		}
+		if tx.To == address {
+			am.Receive(blockHeight, timestamp, tx.Hash, 0, tx.Amount, tx.From)
+		}
+	}
+}
+
+func (c *Chain) timestamp(blockHeight uint64) uint64 {
+	interval := c.blockInterval
+	if interval <= 0 {
+		interval = defaultBlockTime
+	}
+
+	return uint64(c.genesisTime.Add(time.Duration(blockHeight) * interval).Unix())
+}
+
+func (c *Chain) newTx(from, to string, amount *big.Int) *Tx {
+	c.sequence++
+
+	return &Tx{
+		Hash:   hash("tx", c.sequence),
+		From:   strings.TrimSpace(from),
+		To:     strings.TrimSpace(to),
+		Amount: new(big.Int).Set(amount),
+	}
+}
+
+// Derives a tx hash from the given values, so that the same script results in the same hashes
+func hash(values ...interface{}) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%v", values)))
+	return hex.EncodeToString(h[:])
+}
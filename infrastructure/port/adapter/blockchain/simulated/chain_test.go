@@ -0,0 +1,211 @@
+package simulated_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/simulated"
+)
+
+const (
+	addr1 = "sim-address-1"
+	addr2 = "sim-address-2"
+)
+
+func helperBalance(t *testing.T, c *simulated.Chain, address string, since uint64) *big.Int {
+	t.Helper()
+	am, err := c.GetAccountMovements(address, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance := big.NewInt(0)
+	for _, tr := range am.Transfers {
+		balance.Add(balance, tr.Value())
+	}
+
+	return balance
+}
+
+func TestChain_Send(t *testing.T) {
+	c := simulated.NewChain()
+	c.Send(addr1, addr2, big.NewInt(100))
+
+	if b := helperBalance(t, c, addr2, 0); b.Sign() != 0 {
+		t.Fatalf("expected pending tx not to be included but got balance %s", b)
+	}
+
+	if bh := c.Mine(2); bh != 2 {
+		t.Fatalf("expected block height is 2 but got %d", bh)
+	}
+
+	am, err := c.GetAccountMovements(addr1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(am.Transfers) != 1 {
+		t.Fatalf("expected to have 1 transfer but got %d", len(am.Transfers))
+	}
+
+	tr := am.Transfers[0]
+	if tr.Type != domain.Spent || tr.BlockHeight != 1 || tr.Address != addr2 || tr.Amount.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("expected a spent of 100 to %s at block#1 but got %+v", addr2, tr)
+	}
+
+	if b := helperBalance(t, c, addr2, 2); b.Sign() != 0 {
+		t.Fatalf("expected no movements since block#2 but got balance %s", b)
+	}
+}
+
+func TestChain_SendAtAndEvery(t *testing.T) {
+	c := simulated.NewChain()
+	if _, err := c.SendAt(3, addr1, addr2, big.NewInt(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SendEvery(2, addr1, addr2, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Mine(6)
+
+	// 10 at block#3 and 1 at blocks #2, #4 and #6
+	if b := helperBalance(t, c, addr2, 0); b.Cmp(big.NewInt(13)) != 0 {
+		t.Fatalf("expected balance is 13 but got %s", b)
+	}
+
+	if _, err := c.SendAt(6, addr1, addr2, big.NewInt(10)); err == nil {
+		t.Fatal("expected an error for a mined block but got nothing")
+	}
+
+	if err := c.SendEvery(0, addr1, addr2, big.NewInt(1)); err == nil {
+		t.Fatal("expected an error for sending every 0 blocks but got nothing")
+	}
+}
+
+func TestChain_Reorg(t *testing.T) {
+	c := simulated.NewChain()
+	c.SendEvery(3, addr2, addr1, big.NewInt(1))
+	c.Mine(1)
+	first := c.Send(addr1, addr2, big.NewInt(100))
+	c.Mine(1)
+	second := c.Send(addr1, addr2, big.NewInt(50))
+	c.Mine(1)
+
+	if b := helperBalance(t, c, addr2, 0); b.Cmp(big.NewInt(149)) != 0 {
+		t.Fatalf("expected balance is 149 but got %s", b)
+	}
+
+	bh, err := c.Reorg(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 1 {
+		t.Fatalf("expected block height is 1 after the reorg but got %d", bh)
+	}
+
+	pending := c.Pending()
+	if len(pending) != 2 || pending[0].Hash != first || pending[1].Hash != second {
+		t.Fatalf("expected the orphaned txs to return to pending but got %+v", pending)
+	}
+
+	// The first tx is replaced, so only the second one makes it into the new chain
+	if !c.Drop(first) {
+		t.Fatal("expected to drop the pending tx")
+	}
+
+	if c.Drop(first) {
+		t.Fatal("expected not to drop the tx twice")
+	}
+
+	c.Mine(2)
+
+	am, err := c.GetAccountMovements(addr2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(am.Transfers) != 2 {
+		t.Fatalf("expected to have 2 transfers but got %d", len(am.Transfers))
+	}
+
+	if am.Transfers[0].TxHash != second || am.Transfers[0].BlockHeight != 2 {
+		t.Fatalf("expected the second tx to be mined at block#2 but got %+v", am.Transfers[0])
+	}
+
+	if am.Transfers[1].Type != domain.Spent || am.Transfers[1].BlockHeight != 3 {
+		t.Fatalf("expected the repeating tx at block#3 but got %+v", am.Transfers[1])
+	}
+
+	if _, err := c.Reorg(4); err == nil {
+		t.Fatal("expected an error for a reorg deeper than the chain but got nothing")
+	}
+}
+
+func TestChain_BlockInterval(t *testing.T) {
+	opts := &simulated.ChainOptions{
+		BlockInterval: time.Hour,
+		GenesisTime:   time.Now().Add(-10*time.Hour - time.Minute),
+	}
+
+	c := simulated.NewChain(opts)
+	if err := c.SendEvery(5, addr1, addr2, big.NewInt(7)); err != nil {
+		t.Fatal(err)
+	}
+
+	bh, err := c.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 10 {
+		t.Fatalf("expected block height is 10 but got %d", bh)
+	}
+
+	// Another chain with the same options is identical
+	other := simulated.NewChain(opts)
+	other.SendEvery(5, addr1, addr2, big.NewInt(7))
+
+	am1, _ := c.GetAccountMovements(addr2, 0)
+	am2, _ := other.GetAccountMovements(addr2, 0)
+	if len(am1.Transfers) != 2 || len(am2.Transfers) != 2 {
+		t.Fatalf("expected to have 2 transfers on both chains but got %d and %d", len(am1.Transfers), len(am2.Transfers))
+	}
+
+	for i := range am1.Transfers {
+		if am1.Transfers[i].ID() != am2.Transfers[i].ID() || am1.Transfers[i].Timestamp != am2.Transfers[i].Timestamp {
+			t.Fatalf("expected identical transfers but got %+v and %+v", am1.Transfers[i], am2.Transfers[i])
+		}
+	}
+
+	// Orphaned blocks are mined again on the timer
+	c.Reorg(3)
+	if bh, _ := c.GetLatestBlockHeight(); bh != 10 {
+		t.Fatalf("expected block height is 10 after the reorg but got %d", bh)
+	}
+}
+
+func TestChain_GetBlockMovements(t *testing.T) {
+	c := simulated.NewChain()
+	c.Send(addr1, addr2, big.NewInt(100))
+	c.Send(addr2, "sim-address-3", big.NewInt(30))
+	c.Mine(1)
+
+	watched := func(address string) bool { return address == addr2 }
+	acms, err := c.GetBlockMovements(1, watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acms) != 1 || acms[0].Address != addr2 || len(acms[0].Transfers) != 2 {
+		t.Fatalf("expected to have 2 transfers of %s but got %+v", addr2, acms)
+	}
+
+	if _, err := c.GetBlockMovements(2, watched); err == nil {
+		t.Fatal("expected an error for a block not mined yet but got nothing")
+	}
+}
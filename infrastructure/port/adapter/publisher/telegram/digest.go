@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// DigestFormatter formats the given digest summary to a string representation for telegram publisher
+func DigestFormatter(v interface{}) string {
+	event, _ := v.(*domain.AccountDigestReadyEvent)
+	summary := event.Summary()
+	account := event.Account()
+	currency := event.Currency()
+
+	// Same as movement messages, do not create empty digest messages
+	if summary.TransferCount() == 0 {
+		return ""
+	}
+
+	// Format the message as follows:
+	// ```
+	// <address> Digest
+	// {
+	//   <from time> - <to time>
+	//   Received <count> transfers, <amount> <symbol>
+	//   Spent <count> transfers, <amount> <symbol>
+	//   Largest Transfers
+	//   {
+	//     <Received|Spent> <amount> <symbol> block#<block#>
+	//   }
+	// }
+	// ```
+	msg := fmt.Sprintf("%s Digest\n{\n", account)
+	msg += fmt.Sprintf("\t%s - %s\n", summary.From.Format(time.RFC3339), summary.To.Format(time.RFC3339))
+	msg += fmt.Sprintf("\tReceived %d transfers, %s %s\n",
+		summary.ReceivedCount, formatAmount(summary.TotalReceived, currency), currency.Symbol)
+	msg += fmt.Sprintf("\tSpent %d transfers, %s %s\n",
+		summary.SpentCount, formatAmount(summary.TotalSpent, currency), currency.Symbol)
+	msg += "\tLargest Transfers\n\t{\n"
+	for _, t := range summary.LargestTransfers {
+		direction := "Received"
+		if t.Type == domain.Spent {
+			direction = "Spent"
+		}
+		msg += fmt.Sprintf("\t\t%s %s %s block#%d\n", direction, formatAmount(t.Amount, currency), currency.Symbol, t.BlockHeight)
+	}
+	msg += "\t}\n}\n"
+	msg = fmt.Sprintf("```\n%s```", msg)
+
+	return msg
+}
+
+// amount / currency.Decimal with 6 floating precision
+func formatAmount(amount *big.Int, c domain.Currency) string {
+	return new(big.Float).Quo(new(big.Float).SetInt(amount),
+		new(big.Float).SetInt(c.Decimal)).Text('f', 6)
+}
@@ -0,0 +1,46 @@
+package telegram_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/publisher/telegram"
+)
+
+func TestDigestFormatter(t *testing.T) {
+	expectedString := "```\ntest1 Digest\n{\n\t2021-02-18T00:00:00Z - 2021-02-19T00:00:00Z\n\tReceived 2 transfers, 0.014000 eth\n\tSpent 1 transfers, 0.002000 eth\n\tLargest Transfers\n\t{\n\t\tReceived 0.009000 eth block#23\n\t\tReceived 0.005000 eth block#12\n\t}\n}\n```"
+
+	summary := &domain.DigestSummary{
+		From:          time.Date(2021, 2, 18, 0, 0, 0, 0, time.UTC),
+		To:            time.Date(2021, 2, 19, 0, 0, 0, 0, time.UTC),
+		ReceivedCount: 2,
+		SpentCount:    1,
+		TotalReceived: big.NewInt(14000000000000000),
+		TotalSpent:    big.NewInt(2000000000000000),
+		LargestTransfers: []*domain.Transfer{
+			{Type: domain.Received, Amount: big.NewInt(9000000000000000), BlockHeight: 23},
+			{Type: domain.Received, Amount: big.NewInt(5000000000000000), BlockHeight: 12},
+		},
+	}
+	event := domain.NewAccountDigestReadyEvent("test-subsID-1", "test1", eth, summary)
+
+	s := telegram.DigestFormatter(event)
+
+	if s != expectedString {
+		t.Fatalf("expected string is\n%s\nbut got\n%s", expectedString, s)
+	}
+}
+
+func TestDigestFormatter_Empty(t *testing.T) {
+	summary := &domain.DigestSummary{
+		TotalReceived: new(big.Int),
+		TotalSpent:    new(big.Int),
+	}
+	event := domain.NewAccountDigestReadyEvent("test-subsID-1", "test1", eth, summary)
+
+	if s := telegram.DigestFormatter(event); s != "" {
+		t.Fatalf("expected empty string but got\n%s", s)
+	}
+}
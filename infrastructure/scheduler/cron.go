@@ -0,0 +1,237 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit for searching the next activation time, any
+// valid expression must be activated within this period
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	min, max uint
+}
+
+var (
+	minuteBounds = bounds{0, 59}
+	hourBounds   = bounds{0, 23}
+	domBounds    = bounds{1, 31}
+	monthBounds  = bounds{1, 12}
+	dowBounds    = bounds{0, 7}
+)
+
+// Cron is a parsed cron-style schedule expression. It has five fields
+// separated by white space: minute, hour, day of month, month and day of week.
+// Each field accepts '*', a value, a range 'a-b', a step '*/n' or 'a-b/n' and
+// a comma separated list of them. Day of week is 0-7 where both 0 and 7 are Sunday.
+// Predefined macros such as @daily, @weekly or @hourly are accepted as well.
+type Cron struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// ParseCron parses the given cron-style expression
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := macros[expr]; ok {
+		expr = m
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression(%s) must have 5 fields but has %d", expr, len(fields))
+	}
+
+	c := &Cron{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+
+	var err error
+	if c.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+
+	// Sunday can be represented with both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// Next returns the first activation time after the given time in the time zone
+// of the given time. Returns zero time if there is no activation time in 5 years.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.Add(searchLimit)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Before(limit) {
+		if !has(c.month, uint(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !has(c.hour, uint(t.Hour())) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+
+		if !has(c.minute, uint(t.Minute())) {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// IsDue checks whether or not the given schedule has been activated in
+// between the given last time and now, evaluating it in the given time zone
+func IsDue(expr string, timeZone string, last time.Time, now time.Time) (bool, error) {
+	c, err := ParseCron(expr)
+	if err != nil {
+		return false, err
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return false, err
+	}
+
+	next := c.Next(last.In(loc))
+
+	return !next.IsZero() && !next.After(now), nil
+}
+
+// The day matches if either of day of month or day of week matches
+// when both are restricted, otherwise the restricted one must match
+func (c *Cron) dayMatches(t time.Time) bool {
+	domMatch := has(c.dom, uint(t.Day()))
+	dowMatch := has(c.dow, uint(t.Weekday()))
+
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+func has(set uint64, v uint) bool {
+	return set&(1<<v) != 0
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		bits, err := parseRange(part, b)
+		if err != nil {
+			return 0, err
+		}
+		set |= bits
+	}
+
+	return set, nil
+}
+
+func parseRange(expr string, b bounds) (uint64, error) {
+	step := uint(1)
+	rangeAndStep := strings.Split(expr, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, fmt.Errorf("invalid cron field(%s)", expr)
+	}
+
+	if len(rangeAndStep) == 2 {
+		s, err := strconv.ParseUint(rangeAndStep[1], 10, 8)
+		if err != nil || s == 0 {
+			return 0, fmt.Errorf("invalid step in cron field(%s)", expr)
+		}
+		step = uint(s)
+	}
+
+	start, end := b.min, b.max
+	if rangeAndStep[0] != "*" {
+		lowAndHigh := strings.Split(rangeAndStep[0], "-")
+		if len(lowAndHigh) > 2 {
+			return 0, fmt.Errorf("invalid range in cron field(%s)", expr)
+		}
+
+		var err error
+		if start, err = parseValue(lowAndHigh[0], b); err != nil {
+			return 0, err
+		}
+
+		end = start
+		if len(lowAndHigh) == 2 {
+			if end, err = parseValue(lowAndHigh[1], b); err != nil {
+				return 0, err
+			}
+		} else if len(rangeAndStep) == 2 {
+			// 'a/n' means starting from a to the end of the bounds
+			end = b.max
+		}
+
+		if start > end {
+			return 0, fmt.Errorf("invalid range in cron field(%s)", expr)
+		}
+	}
+
+	var bits uint64
+	for v := start; v <= end; v += step {
+		bits |= 1 << v
+	}
+
+	return bits, nil
+}
+
+func parseValue(v string, b bounds) (uint, error) {
+	n, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value(%s) in cron field", v)
+	}
+
+	if uint(n) < b.min || uint(n) > b.max {
+		return 0, fmt.Errorf("value(%d) is out of bounds [%d, %d] in cron field", n, b.min, b.max)
+	}
+
+	return uint(n), nil
+}
@@ -0,0 +1,101 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/scheduler"
+)
+
+func TestParseCron_Invalid(t *testing.T) {
+	exprs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+
+	for _, expr := range exprs {
+		if _, err := scheduler.ParseCron(expr); err == nil {
+			t.Fatalf("expected an error for expression \"%s\" but got nothing", expr)
+		}
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	from := time.Date(2021, 2, 18, 16, 51, 32, 0, time.UTC) // Thursday
+	cases := map[string]time.Time{
+		"* * * * *":      time.Date(2021, 2, 18, 16, 52, 0, 0, time.UTC),
+		"@hourly":        time.Date(2021, 2, 18, 17, 0, 0, 0, time.UTC),
+		"@daily":         time.Date(2021, 2, 19, 0, 0, 0, 0, time.UTC),
+		"30 9 * * *":     time.Date(2021, 2, 19, 9, 30, 0, 0, time.UTC),
+		"0 9 * * 1":      time.Date(2021, 2, 22, 9, 0, 0, 0, time.UTC),
+		"0 9 * * 7":      time.Date(2021, 2, 21, 9, 0, 0, 0, time.UTC),
+		"*/15 * * * *":   time.Date(2021, 2, 18, 17, 0, 0, 0, time.UTC),
+		"0 8-18/2 * * *": time.Date(2021, 2, 18, 18, 0, 0, 0, time.UTC),
+		"0 0 1 * *":      time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":     time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":     time.Date(2021, 2, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	for expr, expected := range cases {
+		c, err := scheduler.ParseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if next := c.Next(from); !next.Equal(expected) {
+			t.Fatalf("expected next activation of \"%s\" is %s but got %s", expr, expected, next)
+		}
+	}
+}
+
+func TestCron_Next_InTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	c, err := scheduler.ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2021-02-18T16:51:32Z is 2021-02-19T01:51:32+09:00
+	from := time.Date(2021, 2, 18, 16, 51, 32, 0, time.UTC)
+	expected := time.Date(2021, 2, 19, 0, 0, 0, 0, time.UTC)
+
+	if next := c.Next(from.In(loc)); !next.Equal(expected) {
+		t.Fatalf("expected next activation is %s but got %s", expected, next)
+	}
+}
+
+func TestIsDue(t *testing.T) {
+	last := time.Date(2021, 2, 18, 16, 51, 32, 0, time.UTC)
+
+	due, err := scheduler.IsDue("@daily", "UTC", last, last.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due {
+		t.Fatal("expected not to be due but it is")
+	}
+
+	due, err = scheduler.IsDue("@daily", "UTC", last, last.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !due {
+		t.Fatal("expected to be due but it is not")
+	}
+
+	if _, err := scheduler.IsDue("@daily", "Nowhere/Unknown", last, last); err == nil {
+		t.Fatal("expected an error for unknown time zone but got nothing")
+	}
+}
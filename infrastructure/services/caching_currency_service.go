@@ -0,0 +1,273 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// Default max. age of a cached response in case the latest block height is not polled
+const defaultCacheMaxAge = time.Minute
+
+// CacheOptions represents configurables for CachingCurrencyService
+type CacheOptions struct {
+	// MaxAge is the max. time a response is cached for. Cached responses
+	// are also dropped as soon as a new block is seen. Defaults to 1 minute
+	MaxAge time.Duration
+}
+
+// CacheStats is the number of account movements requests served by CachingCurrencyService
+type CacheStats struct {
+	// Hits are served from the cache
+	Hits uint64
+	// Coalesced are served by a concurrent request to the same address
+	Coalesced uint64
+	// Misses are requested from the currency service
+	Misses uint64
+}
+
+// HitRate returns the ratio of the requests which are not requested from the currency service
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Coalesced + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.Coalesced) / float64(total)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d coalesced, %d misses, %.1f%% hit rate",
+		s.Hits, s.Coalesced, s.Misses, s.HitRate()*100)
+}
+
+// CachingCurrencyService implements CurrencyService in front of another one. The account
+// movements of an address are fetched once for the concurrent and the subsequent requests
+// whose block ranges are covered by the same request, until a new block is seen
+type CachingCurrencyService struct {
+	cs     domain.CurrencyService
+	maxAge time.Duration
+
+	mu       sync.Mutex
+	height   uint64
+	entries  map[string]*cacheEntry
+	inflight map[string][]*call
+	stats    CacheStats
+}
+
+type cacheEntry struct {
+	since     uint64
+	height    uint64
+	fetchedAt time.Time
+	acm       *domain.AccountMovements
+}
+
+type call struct {
+	since uint64
+	done  chan struct{}
+	acm   *domain.AccountMovements
+	err   error
+}
+
+// NewCachingCurrencyService creates a new instance of CachingCurrencyService in front of the given currency service
+func NewCachingCurrencyService(cs domain.CurrencyService, opts ...*CacheOptions) *CachingCurrencyService {
+	s := &CachingCurrencyService{
+		cs:       cs,
+		maxAge:   defaultCacheMaxAge,
+		entries:  make(map[string]*cacheEntry),
+		inflight: make(map[string][]*call),
+	}
+
+	for _, opt := range opts {
+		if opt.MaxAge != 0 {
+			s.maxAge = opt.MaxAge
+		}
+	}
+
+	return s
+}
+
+// GetAccountMovements returns the cached account movements of the given address if the cached
+// block range covers the requested one, or waits for a concurrent request which covers it.
+// Otherwise requests them from the currency service
+func (s *CachingCurrencyService) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	s.mu.Lock()
+	if e, ok := s.entries[address]; ok && s.isFresh(e) && e.since <= sinceBlockHeight && covers(e.acm, sinceBlockHeight) {
+		s.stats.Hits++
+		s.mu.Unlock()
+		return since(e.acm, sinceBlockHeight), nil
+	}
+
+	for _, c := range s.inflight[address] {
+		if c.since <= sinceBlockHeight {
+			s.stats.Coalesced++
+			s.mu.Unlock()
+
+			<-c.done
+			if c.err != nil {
+				return nil, c.err
+			}
+			if covers(c.acm, sinceBlockHeight) {
+				return since(c.acm, sinceBlockHeight), nil
+			}
+			// The blocks scanned by the concurrent request end before the requested ones
+			return s.cs.GetAccountMovements(address, sinceBlockHeight)
+		}
+	}
+
+	c := &call{since: sinceBlockHeight, done: make(chan struct{})}
+	s.inflight[address] = append(s.inflight[address], c)
+	s.stats.Misses++
+	height := s.height
+	s.mu.Unlock()
+
+	c.acm, c.err = s.cs.GetAccountMovements(address, sinceBlockHeight)
+	close(c.done)
+
+	s.mu.Lock()
+	s.removeCall(address, c)
+	if c.err == nil && height == s.height {
+		// Keep the widest block range unless it's stale
+		if e, ok := s.entries[address]; !ok || !s.isFresh(e) || sinceBlockHeight <= e.since {
+			s.entries[address] = &cacheEntry{
+				since:     sinceBlockHeight,
+				height:    height,
+				fetchedAt: time.Now(),
+				acm:       c.acm,
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return since(c.acm, sinceBlockHeight), nil
+}
+
+// GetLatestBlockHeight fetches the latest block height and drops the cached responses if it's a new block
+func (s *CachingCurrencyService) GetLatestBlockHeight() (uint64, error) {
+	height, err := s.cs.GetLatestBlockHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height != s.height {
+		s.height = height
+		s.entries = make(map[string]*cacheEntry)
+	}
+
+	return height, nil
+}
+
+// Invalidate drops the cached response of the given address, e.g. when it has new movements
+func (s *CachingCurrencyService) Invalidate(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, address)
+}
+
+// Stats returns the number of requests served so far
+func (s *CachingCurrencyService) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *CachingCurrencyService) isFresh(e *cacheEntry) bool {
+	return e.height == s.height && time.Since(e.fetchedAt) < s.maxAge
+}
+
+func (s *CachingCurrencyService) removeCall(address string, c *call) {
+	calls := s.inflight[address]
+	for i := range calls {
+		if calls[i] == c {
+			calls = append(calls[:i], calls[i+1:]...)
+			break
+		}
+	}
+
+	if len(calls) == 0 {
+		delete(s.inflight, address)
+		return
+	}
+	s.inflight[address] = calls
+}
+
+// Tells whether the given movements are scanned beyond the given block height,
+// which is true if the currency service does not scan a bounded range of blocks
+func covers(acm *domain.AccountMovements, blockHeight uint64) bool {
+	return acm == nil || acm.ScannedHeight == 0 || blockHeight <= acm.ScannedHeight
+}
+
+func since(acm *domain.AccountMovements, blockHeight uint64) *domain.AccountMovements {
+	if acm == nil {
+		return nil
+	}
+	// Callers get their own copy to sort without affecting each other
+	return acm.Since(blockHeight)
+}
+
+// cachingNotifier is CachingCurrencyService in front of a currency service which
+// pushes notifications. The cached response of a notified address is dropped
+// before the notification is passed on, so that the new movements are fetched
+type cachingNotifier struct {
+	*CachingCurrencyService
+	domain.MovementNotifier
+
+	once sync.Once
+	ch   chan string
+}
+
+// Notifications returns the channel of the addresses with new movements
+func (n *cachingNotifier) Notifications() <-chan string {
+	n.once.Do(func() {
+		n.ch = make(chan string, cap(n.MovementNotifier.Notifications()))
+		go func() {
+			defer close(n.ch)
+			for address := range n.MovementNotifier.Notifications() {
+				n.Invalidate(address)
+				n.ch <- address
+			}
+		}()
+	})
+
+	return n.ch
+}
+
+// cachingScanner is CachingCurrencyService in front of a currency service which scans blocks.
+// Block movements are not cached since every block is fetched once
+type cachingScanner struct {
+	*CachingCurrencyService
+	domain.BlockScanner
+}
+
+// cachingScanningNotifier is cachingNotifier in front of a currency service which also scans blocks
+type cachingScanningNotifier struct {
+	*cachingNotifier
+	domain.BlockScanner
+}
+
+// withCache puts a CachingCurrencyService in front of the given currency service
+// and keeps it as a MovementNotifier and a BlockScanner if the given one is
+func withCache(cs domain.CurrencyService) domain.CurrencyService {
+	c := NewCachingCurrencyService(cs)
+	n, notifies := cs.(domain.MovementNotifier)
+	bs, scans := cs.(domain.BlockScanner)
+
+	switch {
+	case notifies && scans:
+		return &cachingScanningNotifier{cachingNotifier: &cachingNotifier{CachingCurrencyService: c, MovementNotifier: n}, BlockScanner: bs}
+	case notifies:
+		return &cachingNotifier{CachingCurrencyService: c, MovementNotifier: n}
+	case scans:
+		return &cachingScanner{CachingCurrencyService: c, BlockScanner: bs}
+	}
+
+	return c
+}
@@ -0,0 +1,121 @@
+package services_test
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
+)
+
+func TestCachingCurrencyService_Coalescing(t *testing.T) {
+	p := &stubCurrencyService{blockHeight: 100, delay: 50 * time.Millisecond}
+	s := services.NewCachingCurrencyService(p)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.GetAccountMovements("address", 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p.callCount() != 1 {
+		t.Fatalf("expected concurrent requests to share a single call but got %d calls", p.callCount())
+	}
+
+	if stats := s.Stats(); stats.Misses != 1 || stats.Hits+stats.Coalesced != 9 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+}
+
+func TestCachingCurrencyService_BlockRange(t *testing.T) {
+	acm := domain.NewAccountMovements("address")
+	acm.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	acm.Spend(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-receiver")
+	p := &stubCurrencyService{blockHeight: 100, movements: acm}
+	s := services.NewCachingCurrencyService(p)
+
+	if _, err := s.GetLatestBlockHeight(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetAccountMovements("address", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Covered by the cached block range
+	am, err := s.GetAccountMovements("address", 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(am.Transfers) != 1 || am.Transfers[0].TxHash != "txhash-test2" {
+		t.Fatalf("expected only the movements since block 15 but got %+v", am.Transfers)
+	}
+
+	// Not covered by the cached block range
+	if _, err := s.GetAccountMovements("address", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// GetLatestBlockHeight and 2 GetAccountMovements calls
+	if p.callCount() != 3 {
+		t.Fatalf("expected 3 calls but got %d", p.callCount())
+	}
+
+	// A new block drops the cached responses
+	p.blockHeight = 101
+	if _, err := s.GetLatestBlockHeight(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetAccountMovements("address", 15); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.callCount() != 5 {
+		t.Fatalf("expected the cached response to be dropped but got %d calls", p.callCount())
+	}
+
+	if stats := s.Stats(); stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+}
+
+func TestCachingCurrencyService_ScannedHeight(t *testing.T) {
+	acm := domain.NewAccountMovements("address")
+	acm.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	acm.ScannedHeight = 50
+	p := &stubCurrencyService{blockHeight: 100, movements: acm}
+	s := services.NewCachingCurrencyService(p)
+
+	if _, err := s.GetLatestBlockHeight(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetAccountMovements("address", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Covered by the scanned blocks
+	if _, err := s.GetAccountMovements("address", 40); err != nil {
+		t.Fatal(err)
+	}
+
+	// Beyond the scanned blocks of the cached response
+	if _, err := s.GetAccountMovements("address", 51); err != nil {
+		t.Fatal(err)
+	}
+
+	// GetLatestBlockHeight and 2 GetAccountMovements calls
+	if p.callCount() != 3 {
+		t.Fatalf("expected 3 calls but got %d", p.callCount())
+	}
+}
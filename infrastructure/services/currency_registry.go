@@ -0,0 +1,577 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/net"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/bitcoind"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockbook"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/blockchaindotcom"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/electrum"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/esplora"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/etherscanio"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/ethrpc"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/port/adapter/blockchain/simulated"
+)
+
+// Supported chain families
+const (
+	BitcoinFamily     = "bitcoin"
+	BitcoinCashFamily = "bitcoincash"
+	EthereumFamily    = "ethereum"
+)
+
+// Supported provider types
+const (
+	BlockbookProvider        = "blockbook"
+	EtherscanProvider        = "etherscan"
+	BlockchainDotComProvider = "blockchain.com"
+	BitcoindProvider         = "bitcoind"
+	EthereumRPCProvider      = "ethereum-rpc"
+	EsploraProvider          = "esplora"
+	ElectrumProvider         = "electrum"
+	SimulatedProvider        = "simulated"
+)
+
+// Time in-between the blocks of a simulated blockchain unless configured otherwise
+const defaultSimulatedBlockInterval = 10 * time.Second
+
+// Max. number of decimals for a currency
+const maxDecimals = 36
+
+// Request header carrying the api key of a provider unless configured otherwise
+const defaultAPIKeyHeader = "X-API-Key"
+
+// ProviderConfig represents configuration options for a blockchain data provider
+type ProviderConfig struct {
+	Type        string `yaml:"type"`
+	URL         string `yaml:"url"`
+	APIKey      string `yaml:"api-key"`
+	PagingLimit int    `yaml:"paging-limit"`
+	// APIKeyHeader is the request header carrying the api key. Defaults to X-API-Key
+	APIKeyHeader string `yaml:"api-key-header"`
+	// Username and Password are the credentials for the basic authentication
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Streaming enables the push notifications through
+	// the provider's WebSocket API. Only used by blockbook
+	Streaming bool `yaml:"streaming"`
+	// Simulation scripts the in-memory blockchain. Only used by simulated
+	Simulation *SimulationConfig `yaml:"simulation"`
+}
+
+// SimulationConfig represents configuration options for a simulated blockchain
+type SimulationConfig struct {
+	// BlockInterval is the time in seconds in-between blocks. Defaults to 10 seconds
+	BlockInterval int `yaml:"block-interval"`
+	// Genesis is the time of the genesis block in RFC3339. Defaults to the start time.
+	// The binaries sharing the same genesis observe the same blockchain
+	Genesis   string                    `yaml:"genesis"`
+	Transfers []SimulatedTransferConfig `yaml:"transfers"`
+}
+
+// SimulatedTransferConfig represents a scripted transfer on a simulated blockchain
+type SimulatedTransferConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Amount is in the smallest unit of the currency
+	Amount string `yaml:"amount"`
+	// Either Block or Every must be set. The transfer is sent in the block at the
+	// height of Block or in every block whose height is a multiple of Every
+	Block uint64 `yaml:"block"`
+	Every uint64 `yaml:"every"`
+}
+
+// CurrencyConfig represents configuration options for a currency
+type CurrencyConfig struct {
+	Symbol   string `yaml:"symbol"`
+	Decimals int    `yaml:"decimals"`
+	Family   string `yaml:"family"`
+	// ChainID and NativeSymbol identify the blockchain of ethereum family.
+	// ChainID defaults to Ethereum mainnet and NativeSymbol defaults to
+	// the well-known chain's native symbol or the currency symbol
+	ChainID      uint64 `yaml:"chain-id"`
+	NativeSymbol string `yaml:"native-symbol"`
+	// Contract is the address of the ERC-20 token contract if the currency is
+	// a token rather than the native asset of the chain. Only used by ethereum-rpc
+	Contract string         `yaml:"contract"`
+	Provider ProviderConfig `yaml:"provider"`
+	// Providers are several providers of the currency to fail over between
+	// them. Either Provider or Providers must be configured
+	Providers []ProviderConfig `yaml:"providers"`
+	// BlockHeightQuorum is the number of providers which must agree
+	// on the latest block height. Only used with Providers
+	BlockHeightQuorum int `yaml:"block-height-quorum"`
+	// VerificationProvider is the secondary provider which the
+	// applied movements are cross-checked against. Optional
+	VerificationProvider *ProviderConfig `yaml:"verification-provider"`
+	// Cache shares the account movements of an address between the subscriptions
+	// to it and the concurrent requests for it until a new block is seen
+	Cache bool `yaml:"cache"`
+}
+
+// Blockchain and asset which a currency service is built for
+type target struct {
+	family   string
+	chain    blockchain.EVMChain
+	contract string
+}
+
+type serviceBuilder func(t target, c ProviderConfig) (domain.CurrencyService, error)
+
+var serviceBuilders = map[string]serviceBuilder{
+	BlockbookProvider:        newBlockbookService,
+	EtherscanProvider:        newEtherscanService,
+	BlockchainDotComProvider: newBlockchainDotComService,
+	BitcoindProvider:         newBitcoindService,
+	EthereumRPCProvider:      newEthereumRPCService,
+	EsploraProvider:          newEsploraService,
+	ElectrumProvider:         newElectrumService,
+	SimulatedProvider:        newSimulatedService,
+}
+
+// CurrencyRegistry keeps the configured currencies and their currency services
+type CurrencyRegistry struct {
+	symbols    []string
+	currencies map[string]domain.Currency
+	services   map[string]domain.CurrencyService
+	verifiers  map[string]NamedCurrencyService
+}
+
+// NewCurrencyRegistry validates the given configurations and creates a new
+// instance of CurrencyRegistry with the corresponding currency services
+func NewCurrencyRegistry(configs []CurrencyConfig) (*CurrencyRegistry, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no currency is configured")
+	}
+
+	r := &CurrencyRegistry{
+		symbols:    make([]string, 0, len(configs)),
+		currencies: make(map[string]domain.Currency),
+		services:   make(map[string]domain.CurrencyService),
+		verifiers:  make(map[string]NamedCurrencyService),
+	}
+
+	for _, c := range configs {
+		if err := r.register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Currency returns the currency for the given symbol
+func (r *CurrencyRegistry) Currency(symbol string) (domain.Currency, bool) {
+	c, ok := r.currencies[symbol]
+	return c, ok
+}
+
+// CurrencyService returns the currency service for the given symbol
+func (r *CurrencyRegistry) CurrencyService(symbol string) (domain.CurrencyService, bool) {
+	cs, ok := r.services[symbol]
+	return cs, ok
+}
+
+// VerificationService returns the currency service of the verification provider for the given symbol
+func (r *CurrencyRegistry) VerificationService(symbol string) (NamedCurrencyService, bool) {
+	cs, ok := r.verifiers[symbol]
+	return cs, ok
+}
+
+// Currencies returns all the registered currencies in the configured order
+func (r *CurrencyRegistry) Currencies() []domain.Currency {
+	currencies := make([]domain.Currency, 0, len(r.symbols))
+	for _, s := range r.symbols {
+		currencies = append(currencies, r.currencies[s])
+	}
+	return currencies
+}
+
+// Register adds the given currency with a currency service built by the caller,
+// e.g. for a provider which is not configurable or a stub in the tests
+func (r *CurrencyRegistry) Register(c domain.Currency, cs domain.CurrencyService) error {
+	if c.Symbol == "" {
+		return fmt.Errorf("currency symbol cannot be empty")
+	}
+
+	if _, exist := r.currencies[c.Symbol]; exist {
+		return fmt.Errorf("currency(%s) is configured more than once", c.Symbol)
+	}
+
+	r.symbols = append(r.symbols, c.Symbol)
+	r.currencies[c.Symbol] = c
+	r.services[c.Symbol] = cs
+
+	return nil
+}
+
+func (r *CurrencyRegistry) register(c CurrencyConfig) error {
+	if c.Symbol == "" {
+		return fmt.Errorf("currency symbol cannot be empty")
+	}
+
+	if _, exist := r.currencies[c.Symbol]; exist {
+		return fmt.Errorf("currency(%s) is configured more than once", c.Symbol)
+	}
+
+	if c.Decimals < 0 || c.Decimals > maxDecimals {
+		return fmt.Errorf("currency(%s) has invalid decimals(%d)", c.Symbol, c.Decimals)
+	}
+
+	if c.Family != BitcoinFamily && c.Family != BitcoinCashFamily && c.Family != EthereumFamily {
+		return fmt.Errorf("currency(%s) has unsupported chain family(%s)", c.Symbol, c.Family)
+	}
+
+	chain, err := evmChainOf(c)
+	if err != nil {
+		return fmt.Errorf("currency(%s) has invalid chain configuration, %s", c.Symbol, err.Error())
+	}
+
+	t := target{family: c.Family, chain: chain, contract: c.Contract}
+
+	if c.VerificationProvider != nil {
+		cs, err := newCurrencyService(t, *c.VerificationProvider)
+		if err != nil {
+			return fmt.Errorf("currency(%s) verification provider %s", c.Symbol, err.Error())
+		}
+
+		r.verifiers[c.Symbol] = NamedCurrencyService{Name: providerName(*c.VerificationProvider), CurrencyService: cs}
+	}
+
+	if len(c.Providers) == 0 {
+		if c.BlockHeightQuorum != 0 {
+			return fmt.Errorf("currency(%s) has a block height quorum which is only supported with multiple providers", c.Symbol)
+		}
+
+		cs, err := newCurrencyService(t, c.Provider)
+		if err != nil {
+			return fmt.Errorf("currency(%s) %s", c.Symbol, err.Error())
+		}
+
+		r.add(c, cs)
+		return nil
+	}
+
+	if c.Provider != (ProviderConfig{}) {
+		return fmt.Errorf("currency(%s) has both provider and providers configured", c.Symbol)
+	}
+
+	providers := make([]NamedCurrencyService, 0, len(c.Providers))
+	for i, pc := range c.Providers {
+		cs, err := newCurrencyService(t, pc)
+		if err != nil {
+			return fmt.Errorf("currency(%s) provider#%d %s", c.Symbol, i, err.Error())
+		}
+
+		providers = append(providers, NamedCurrencyService{Name: providerName(pc), CurrencyService: cs})
+	}
+
+	cs, err := NewFailoverCurrencyService(providers, &FailoverOptions{BlockHeightQuorum: c.BlockHeightQuorum})
+	if err != nil {
+		return fmt.Errorf("currency(%s) has invalid providers configuration, %s", c.Symbol, err.Error())
+	}
+
+	r.add(c, withProviderExtensions(cs))
+
+	return nil
+}
+
+func (r *CurrencyRegistry) add(c CurrencyConfig, cs domain.CurrencyService) {
+	r.symbols = append(r.symbols, c.Symbol)
+	r.currencies[c.Symbol] = domain.Currency{
+		Symbol:  c.Symbol,
+		Decimal: new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.Decimals)), nil),
+	}
+
+	if c.Cache {
+		cs = withCache(cs)
+	}
+	r.services[c.Symbol] = cs
+}
+
+// Identifies the provider in the health and verification reports
+func providerName(c ProviderConfig) string {
+	if c.URL == "" {
+		return c.Type
+	}
+	return fmt.Sprintf("%s(%s)", c.Type, c.URL)
+}
+
+// Validates the given provider configuration and creates the currency service of the provider for the given target
+func newCurrencyService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	build, exist := serviceBuilders[c.Type]
+	if !exist {
+		return nil, fmt.Errorf("has unsupported provider type(%s)", c.Type)
+	}
+
+	if c.PagingLimit < 0 {
+		return nil, fmt.Errorf("has invalid paging limit(%d)", c.PagingLimit)
+	}
+
+	if c.APIKeyHeader != "" && c.APIKey == "" {
+		return nil, fmt.Errorf("has an api key header without an api key")
+	}
+
+	if c.Simulation != nil && c.Type != SimulatedProvider {
+		return nil, fmt.Errorf("has a simulation which is only supported by %s", SimulatedProvider)
+	}
+
+	if c.Streaming && c.Type != BlockbookProvider {
+		return nil, fmt.Errorf("has streaming enabled which is only supported by %s", BlockbookProvider)
+	}
+
+	if t.contract != "" && (t.family != EthereumFamily || c.Type != EthereumRPCProvider) {
+		return nil, fmt.Errorf("has a contract which is only supported by %s family and %s provider", EthereumFamily, EthereumRPCProvider)
+	}
+
+	cs, err := build(t, c)
+	if err != nil {
+		return nil, fmt.Errorf("has invalid provider configuration, %s", err.Error())
+	}
+
+	return cs, nil
+}
+
+// Resolves the EVM chain of the given currency. Chain options
+// are only allowed for ethereum family
+func evmChainOf(c CurrencyConfig) (blockchain.EVMChain, error) {
+	if c.Family != EthereumFamily {
+		if c.ChainID != 0 || c.NativeSymbol != "" {
+			return blockchain.EVMChain{}, fmt.Errorf("chain id and native symbol are not supported by %s family", c.Family)
+		}
+		return blockchain.EVMChain{}, nil
+	}
+
+	id := c.ChainID
+	if id == 0 {
+		id = blockchain.EthereumChainID
+	}
+
+	chain, known := blockchain.KnownEVMChain(id)
+	if !known {
+		chain = blockchain.EVMChain{ID: id, NativeSymbol: c.Symbol}
+	}
+
+	if c.NativeSymbol != "" {
+		chain.NativeSymbol = c.NativeSymbol
+	}
+
+	return chain, nil
+}
+
+func newBlockbookService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required for %s", c.Type)
+	}
+
+	if c.Username != "" || c.Password != "" {
+		return nil, fmt.Errorf("credentials are not supported by %s", c.Type)
+	}
+
+	var pagingLimit *int
+	if c.PagingLimit > 0 {
+		pagingLimit = &c.PagingLimit
+	}
+
+	var tr blockchain.Translator
+	switch t.family {
+	case BitcoinFamily:
+		tr = blockbook.BitcoinTranslator{}
+	case BitcoinCashFamily:
+		tr = blockbook.BitcoinCashTranslator{}
+	case EthereumFamily:
+		tr = blockbook.EthereumTranslator{Chain: t.chain}
+	default:
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.Streaming {
+		api := blockbook.NewStreamingAPI(c.URL, tr, pagingLimit)
+		api.SetHTTPClient(newHTTPClient(c))
+		return api, nil
+	}
+
+	api := blockbook.NewAPI(c.URL, tr, pagingLimit)
+	api.SetHTTPClient(newHTTPClient(c))
+	return api, nil
+}
+
+func newEtherscanService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if t.family != EthereumFamily {
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.APIKeyHeader != "" || c.Username != "" || c.Password != "" {
+		return nil, fmt.Errorf("api key header and credentials are not configurable for %s", c.Type)
+	}
+
+	var pagingLimit *int
+	if c.PagingLimit > 0 {
+		pagingLimit = &c.PagingLimit
+	}
+
+	api := etherscanio.NewAPI(c.URL, t.chain, etherscanio.EthereumTranslator{Chain: t.chain}, pagingLimit)
+	api.SetAPIKey(c.APIKey)
+	return api, nil
+}
+
+func newBlockchainDotComService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if t.family != BitcoinFamily {
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.URL != "" || c.APIKey != "" || c.PagingLimit != 0 || c.Username != "" || c.Password != "" {
+		return nil, fmt.Errorf("url, api key, paging limit and credentials are not configurable for %s", c.Type)
+	}
+
+	return blockchaindotcom.NewAPI(blockchaindotcom.BitcoinTranslator{}), nil
+}
+
+func newBitcoindService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if t.family != BitcoinFamily {
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required for %s", c.Type)
+	}
+
+	if c.APIKey != "" || c.PagingLimit != 0 {
+		return nil, fmt.Errorf("api key and paging limit are not configurable for %s", c.Type)
+	}
+
+	return bitcoind.NewAPI(c.URL, c.Username, c.Password, bitcoind.BitcoinTranslator{}), nil
+}
+
+func newEthereumRPCService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if t.family != EthereumFamily {
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required for %s", c.Type)
+	}
+
+	if c.APIKey != "" || c.PagingLimit != 0 {
+		return nil, fmt.Errorf("api key and paging limit are not configurable for %s", c.Type)
+	}
+
+	if t.contract != "" {
+		return ethrpc.NewTokenAPI(c.URL, c.Username, c.Password, t.contract, ethrpc.ERC20Translator{Chain: t.chain}), nil
+	}
+
+	return ethrpc.NewAPI(c.URL, c.Username, c.Password, ethrpc.EthereumTranslator{Chain: t.chain}), nil
+}
+
+func newEsploraService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if t.family != BitcoinFamily {
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required for %s", c.Type)
+	}
+
+	if c.PagingLimit != 0 || c.Username != "" || c.Password != "" {
+		return nil, fmt.Errorf("paging limit and credentials are not configurable for %s", c.Type)
+	}
+
+	api := esplora.NewAPI(c.URL, esplora.BitcoinTranslator{})
+	api.SetHTTPClient(newHTTPClient(c))
+	return api, nil
+}
+
+// newHTTPClient creates a client sending the provider's api key in the configured header
+func newHTTPClient(c ProviderConfig) *net.HTTPClient {
+	if c.APIKey == "" {
+		return net.NewHTTPClient()
+	}
+
+	header := c.APIKeyHeader
+	if header == "" {
+		header = defaultAPIKeyHeader
+	}
+
+	return net.NewHTTPClient(&net.ClientOptions{Headers: map[string]string{header: c.APIKey}})
+}
+
+func newElectrumService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if t.family != BitcoinFamily {
+		return nil, fmt.Errorf("%s family is not supported by %s", t.family, c.Type)
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required for %s", c.Type)
+	}
+
+	if c.APIKey != "" || c.PagingLimit != 0 || c.Username != "" || c.Password != "" {
+		return nil, fmt.Errorf("api key, paging limit and credentials are not configurable for %s", c.Type)
+	}
+
+	api, err := electrum.NewAPI(c.URL, electrum.BitcoinTranslator{})
+	if err != nil {
+		return nil, err
+	}
+
+	return api, nil
+}
+
+func newSimulatedService(t target, c ProviderConfig) (domain.CurrencyService, error) {
+	if c.URL != "" || c.APIKey != "" || c.PagingLimit != 0 || c.Username != "" || c.Password != "" {
+		return nil, fmt.Errorf("url, api key, paging limit and credentials are not configurable for %s", c.Type)
+	}
+
+	sc := SimulationConfig{}
+	if c.Simulation != nil {
+		sc = *c.Simulation
+	}
+
+	if sc.BlockInterval < 0 {
+		return nil, fmt.Errorf("simulation has invalid block interval(%d)", sc.BlockInterval)
+	}
+
+	opts := &simulated.ChainOptions{BlockInterval: defaultSimulatedBlockInterval}
+	if sc.BlockInterval > 0 {
+		opts.BlockInterval = time.Duration(sc.BlockInterval) * time.Second
+	}
+
+	if sc.Genesis != "" {
+		genesis, err := time.Parse(time.RFC3339, sc.Genesis)
+		if err != nil {
+			return nil, fmt.Errorf("simulation has invalid genesis(%s), %s", sc.Genesis, err.Error())
+		}
+		opts.GenesisTime = genesis
+	}
+
+	chain := simulated.NewChain(opts)
+	for i, tc := range sc.Transfers {
+		amount, ok := new(big.Int).SetString(tc.Amount, 10)
+		if !ok || amount.Sign() < 0 {
+			return nil, fmt.Errorf("simulation transfer#%d has invalid amount(%s)", i, tc.Amount)
+		}
+
+		if tc.From == "" && tc.To == "" {
+			return nil, fmt.Errorf("simulation transfer#%d has neither from nor to address", i)
+		}
+
+		switch {
+		case tc.Block != 0 && tc.Every == 0:
+			if _, err := chain.SendAt(tc.Block, tc.From, tc.To, amount); err != nil {
+				return nil, fmt.Errorf("simulation transfer#%d %s", i, err.Error())
+			}
+		case tc.Every != 0 && tc.Block == 0:
+			if err := chain.SendEvery(tc.Every, tc.From, tc.To, amount); err != nil {
+				return nil, fmt.Errorf("simulation transfer#%d %s", i, err.Error())
+			}
+		default:
+			return nil, fmt.Errorf("simulation transfer#%d must have either block or every", i)
+		}
+	}
+
+	return chain, nil
+}
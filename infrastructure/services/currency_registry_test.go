@@ -0,0 +1,590 @@
+package services_test
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
+)
+
+func testConfigs() []services.CurrencyConfig {
+	return []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type:        services.BlockbookProvider,
+				URL:         "https://btc1.trezor.io",
+				PagingLimit: 50,
+			},
+		},
+		{
+			Symbol:   "eth",
+			Decimals: 18,
+			Family:   services.EthereumFamily,
+			Provider: services.ProviderConfig{
+				Type:        services.EtherscanProvider,
+				APIKey:      "etherscan api key",
+				PagingLimit: 1000,
+			},
+		},
+	}
+}
+
+func TestNewCurrencyRegistry(t *testing.T) {
+	r, err := services.NewCurrencyRegistry(testConfigs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok := r.Currency("eth")
+	if !ok {
+		t.Fatal("expected to have eth currency but got nothing")
+	}
+
+	if c.Decimal.Cmp(new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)) != 0 {
+		t.Fatalf("expected decimal is 1e18 but got %s", c.Decimal)
+	}
+
+	if _, ok := r.CurrencyService("btc"); !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+
+	if _, ok := r.Currency("ltc"); ok {
+		t.Fatal("expected not to have ltc currency but got one")
+	}
+
+	currencies := r.Currencies()
+	if len(currencies) != 2 || currencies[0].Symbol != "btc" || currencies[1].Symbol != "eth" {
+		t.Fatalf("expected currencies in the configured order but got %+v", currencies)
+	}
+}
+
+func TestCurrencyRegistry_Register(t *testing.T) {
+	r, err := services.NewCurrencyRegistry(testConfigs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := &stubCurrencyService{blockHeight: 100}
+	if err := r.Register(domain.Currency{Symbol: "tst", Decimal: big.NewInt(1)}, cs); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, ok := r.CurrencyService("tst"); !ok || s != cs {
+		t.Fatal("expected to have the registered currency service for tst but got nothing")
+	}
+
+	if err := r.Register(domain.Currency{Symbol: "btc"}, cs); err == nil {
+		t.Fatal("expected an error for an already configured currency but got nothing")
+	}
+}
+
+func TestNewCurrencyRegistry_Bitcoind(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type:     services.BitcoindProvider,
+				URL:      "http://localhost:8332",
+				Username: "user",
+				Password: "password",
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.CurrencyService("btc"); !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+}
+
+func TestNewCurrencyRegistry_Esplora(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type: services.EsploraProvider,
+				URL:  "https://mempool.space/api",
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.CurrencyService("btc"); !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+}
+
+func TestNewCurrencyRegistry_Electrum(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type: services.ElectrumProvider,
+				URL:  "ssl://electrum.blockstream.info:50002",
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ok := r.CurrencyService("btc")
+	if !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+
+	if _, ok := cs.(domain.MovementNotifier); !ok {
+		t.Fatal("expected electrum service to be a movement notifier")
+	}
+}
+
+func TestNewCurrencyRegistry_Simulated(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type: services.SimulatedProvider,
+				Simulation: &services.SimulationConfig{
+					BlockInterval: 3600,
+					Genesis:       time.Now().Add(-2*time.Hour - time.Minute).Format(time.RFC3339),
+					Transfers: []services.SimulatedTransferConfig{
+						{From: "addr1", To: "addr2", Amount: "100", Block: 1},
+						{From: "addr2", To: "addr3", Amount: "10", Every: 2},
+					},
+				},
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ok := r.CurrencyService("btc")
+	if !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+
+	if _, ok := cs.(domain.BlockScanner); !ok {
+		t.Fatal("expected simulated service to be a block scanner")
+	}
+
+	if bh, err := cs.GetLatestBlockHeight(); err != nil || bh != 2 {
+		t.Fatalf("expected block height is 2 but got %d, %v", bh, err)
+	}
+
+	am, err := cs.GetAccountMovements("addr2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(am.Transfers) != 2 {
+		t.Fatalf("expected to have 2 transfers but got %d", len(am.Transfers))
+	}
+}
+
+func TestNewCurrencyRegistry_APIKey(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "secret" {
+			http.Error(w, "missing api key", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("680000"))
+	}))
+	defer s.Close()
+
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type:         services.EsploraProvider,
+				URL:          s.URL,
+				APIKey:       "secret",
+				APIKeyHeader: "Authorization",
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, _ := r.CurrencyService("btc")
+	height, err := cs.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if height != 680000 {
+		t.Fatalf("expected block height is 680000 but got %d", height)
+	}
+}
+
+func TestNewCurrencyRegistry_Cache(t *testing.T) {
+	configs := testConfigs()
+	configs[0].Cache = true
+	configs[0].Provider = services.ProviderConfig{
+		Type: services.ElectrumProvider,
+		URL:  "ssl://electrum.blockstream.info:50002",
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, _ := r.CurrencyService("btc")
+	if _, ok := cs.(interface{ Stats() services.CacheStats }); !ok {
+		t.Fatal("expected btc service to be cached")
+	}
+
+	if _, ok := cs.(domain.MovementNotifier); !ok {
+		t.Fatal("expected cached electrum service to be a movement notifier")
+	}
+}
+
+func TestNewCurrencyRegistry_BlockbookStreaming(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Provider: services.ProviderConfig{
+				Type:      services.BlockbookProvider,
+				URL:       "https://btc1.trezor.io",
+				Streaming: true,
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ok := r.CurrencyService("btc")
+	if !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+
+	if _, ok := cs.(domain.MovementNotifier); !ok {
+		t.Fatal("expected streaming blockbook service to be a movement notifier")
+	}
+}
+
+func TestNewCurrencyRegistry_Failover(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "btc",
+			Decimals: 8,
+			Family:   services.BitcoinFamily,
+			Providers: []services.ProviderConfig{
+				{Type: services.BlockbookProvider, URL: "https://btc1.trezor.io"},
+				{Type: services.BlockbookProvider, URL: "https://btc2.trezor.io"},
+				{Type: services.EsploraProvider, URL: "https://mempool.space/api"},
+			},
+			BlockHeightQuorum: 2,
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ok := r.CurrencyService("btc")
+	if !ok {
+		t.Fatal("expected to have a currency service for btc but got nothing")
+	}
+
+	fs, ok := cs.(*services.FailoverCurrencyService)
+	if !ok {
+		t.Fatalf("expected a failover currency service but got %T", cs)
+	}
+
+	if h := fs.Health(); len(h) != 3 || h[2].Name != "esplora(https://mempool.space/api)" {
+		t.Fatalf("unexpected providers %+v", h)
+	}
+}
+
+func TestNewCurrencyRegistry_BlockScanner(t *testing.T) {
+	bitcoind := services.ProviderConfig{
+		Type:     services.BitcoindProvider,
+		URL:      "http://localhost:8332",
+		Username: "user",
+		Password: "password",
+	}
+	streaming := services.ProviderConfig{
+		Type:      services.BlockbookProvider,
+		URL:       "https://btc1.trezor.io",
+		Streaming: true,
+	}
+
+	configs := []services.CurrencyConfig{
+		{Symbol: "btc", Decimals: 8, Family: services.BitcoinFamily, Provider: bitcoind, Cache: true},
+		{Symbol: "bch", Decimals: 8, Family: services.BitcoinFamily, Providers: []services.ProviderConfig{bitcoind, streaming}, Cache: true},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, _ := r.CurrencyService("btc")
+	if _, ok := cs.(domain.BlockScanner); !ok {
+		t.Fatal("expected cached bitcoind service to be a block scanner")
+	}
+
+	cs, _ = r.CurrencyService("bch")
+	if _, ok := cs.(domain.BlockScanner); !ok {
+		t.Fatal("expected cached failover service over block scanners to be a block scanner")
+	}
+	if _, ok := cs.(domain.MovementNotifier); !ok {
+		t.Fatal("expected cached failover service over a streaming provider to be a movement notifier")
+	}
+}
+
+func TestNewCurrencyRegistry_VerificationProvider(t *testing.T) {
+	configs := testConfigs()
+	configs[0].VerificationProvider = &services.ProviderConfig{
+		Type: services.EsploraProvider,
+		URL:  "https://mempool.space/api",
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, ok := r.VerificationService("btc")
+	if !ok {
+		t.Fatal("expected to have a verification service for btc but got nothing")
+	}
+
+	if v.Name != "esplora(https://mempool.space/api)" {
+		t.Fatalf("unexpected verification provider name %s", v.Name)
+	}
+
+	if _, ok := r.VerificationService("eth"); ok {
+		t.Fatal("expected to have no verification service for eth")
+	}
+}
+
+func TestNewCurrencyRegistry_EVMChains(t *testing.T) {
+	configs := []services.CurrencyConfig{
+		{
+			Symbol:   "eth",
+			Decimals: 18,
+			Family:   services.EthereumFamily,
+			Provider: services.ProviderConfig{Type: services.EtherscanProvider},
+		},
+		{
+			Symbol:   "pol",
+			Decimals: 18,
+			Family:   services.EthereumFamily,
+			ChainID:  137,
+			Provider: services.ProviderConfig{Type: services.EtherscanProvider},
+		},
+		{
+			Symbol:   "arb-eth",
+			Decimals: 18,
+			Family:   services.EthereumFamily,
+			ChainID:  42161,
+			Provider: services.ProviderConfig{Type: services.EtherscanProvider},
+		},
+		{
+			Symbol:       "bnb",
+			Decimals:     18,
+			Family:       services.EthereumFamily,
+			ChainID:      56,
+			NativeSymbol: "bnb",
+			Provider: services.ProviderConfig{
+				Type: services.BlockbookProvider,
+				URL:  "https://bsc1.trezor.io",
+			},
+		},
+		{
+			Symbol:   "usdt",
+			Decimals: 6,
+			Family:   services.EthereumFamily,
+			Contract: "0xdac17f958d2ee523a2206206994597c13d831ec7",
+			Provider: services.ProviderConfig{
+				Type: services.EthereumRPCProvider,
+				URL:  "http://localhost:8545",
+			},
+		},
+		{
+			Symbol:   "custom",
+			Decimals: 18,
+			Family:   services.EthereumFamily,
+			ChainID:  31337,
+			Provider: services.ProviderConfig{
+				Type: services.EtherscanProvider,
+				URL:  "http://localhost:4000/api",
+			},
+		},
+	}
+
+	r, err := services.NewCurrencyRegistry(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range configs {
+		if _, ok := r.CurrencyService(c.Symbol); !ok {
+			t.Fatalf("expected to have a currency service for %s but got nothing", c.Symbol)
+		}
+	}
+}
+
+func TestNewCurrencyRegistry_Invalid(t *testing.T) {
+	cases := map[string]func(cs []services.CurrencyConfig) []services.CurrencyConfig{
+		"no currencies": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			return nil
+		},
+		"empty symbol": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Symbol = ""
+			return cs
+		},
+		"duplicate symbol": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[1].Symbol = cs[0].Symbol
+			return cs
+		},
+		"negative decimals": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Decimals = -1
+			return cs
+		},
+		"unknown family": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Family = "unknown"
+			return cs
+		},
+		"unknown provider": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider.Type = "unknown"
+			return cs
+		},
+		"missing url": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider.URL = ""
+			return cs
+		},
+		"chain id for non-evm family": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].ChainID = 1
+			return cs
+		},
+		"bitcoind without url": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider = services.ProviderConfig{Type: services.BitcoindProvider, Username: "user"}
+			return cs
+		},
+		"electrum with http url": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider = services.ProviderConfig{Type: services.ElectrumProvider, URL: "http://localhost:50001"}
+			return cs
+		},
+		"api key header for etherscan": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[1].Provider.APIKeyHeader = "X-API-Key"
+			return cs
+		},
+		"streaming for etherscan": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[1].Provider.Streaming = true
+			return cs
+		},
+		"both provider and providers": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Providers = []services.ProviderConfig{cs[0].Provider}
+			return cs
+		},
+		"quorum without providers": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].BlockHeightQuorum = 1
+			return cs
+		},
+		"quorum more than providers": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Providers = []services.ProviderConfig{cs[0].Provider}
+			cs[0].Provider = services.ProviderConfig{}
+			cs[0].BlockHeightQuorum = 2
+			return cs
+		},
+		"invalid fallback provider": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Providers = []services.ProviderConfig{cs[0].Provider, {Type: services.EtherscanProvider}}
+			cs[0].Provider = services.ProviderConfig{}
+			return cs
+		},
+		"invalid verification provider": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].VerificationProvider = &services.ProviderConfig{Type: services.EtherscanProvider}
+			return cs
+		},
+		"api key header without api key": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider.APIKeyHeader = "Authorization"
+			return cs
+		},
+		"credentials for blockbook": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider.Username = "user"
+			return cs
+		},
+		"contract for etherscan": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[1].Contract = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+			return cs
+		},
+		"simulation for blockbook": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider.Simulation = &services.SimulationConfig{}
+			return cs
+		},
+		"url for simulated": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider.Type = services.SimulatedProvider
+			return cs
+		},
+		"invalid simulated transfer": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider = services.ProviderConfig{
+				Type: services.SimulatedProvider,
+				Simulation: &services.SimulationConfig{
+					Transfers: []services.SimulatedTransferConfig{{From: "addr1", To: "addr2", Amount: "1", Block: 1, Every: 1}},
+				},
+			}
+			return cs
+		},
+		"invalid simulated amount": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[0].Provider = services.ProviderConfig{
+				Type: services.SimulatedProvider,
+				Simulation: &services.SimulationConfig{
+					Transfers: []services.SimulatedTransferConfig{{From: "addr1", To: "addr2", Amount: "1.5", Block: 1}},
+				},
+			}
+			return cs
+		},
+		"mismatching family": func(cs []services.CurrencyConfig) []services.CurrencyConfig {
+			cs[1].Family = services.BitcoinFamily
+			return cs
+		},
+	}
+
+	for name, modify := range cases {
+		if _, err := services.NewCurrencyRegistry(modify(testConfigs())); err == nil {
+			t.Fatalf("expected an error for \"%s\" but got nothing", name)
+		}
+	}
+}
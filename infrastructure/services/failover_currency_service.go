@@ -0,0 +1,437 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+// Default options of FailoverCurrencyService
+const (
+	defaultBlockHeightQuorum = 1
+	defaultRetryAfter        = 30 * time.Second
+	maxRetryAfter            = 10 * time.Minute
+)
+
+// Weight of the latest call in the moving average of the latency
+const latencyWeight = 0.2
+
+// NamedCurrencyService is a currency service with a name to identify it in the health reports
+type NamedCurrencyService struct {
+	Name string
+	domain.CurrencyService
+}
+
+// FailoverOptions represents configurables for FailoverCurrencyService
+type FailoverOptions struct {
+	// Number of providers which must agree on the latest block height. The latest block
+	// height is the highest one reported by at least this many providers. Defaults to 1
+	BlockHeightQuorum int
+	// Time after which a failing provider is retried. It doubles with every consecutive failure
+	RetryAfter time.Duration
+}
+
+// ProviderHealth is the health report of a provider
+type ProviderHealth struct {
+	Name      string
+	Healthy   bool
+	Latency   time.Duration
+	Failures  int
+	LastError error
+}
+
+// FailoverCurrencyService implements CurrencyService over several providers of the same currency.
+// Calls are routed to the healthy providers in the order of their latency and fail over to
+// the next one on error. A failing provider is retried after a backoff
+type FailoverCurrencyService struct {
+	providers  []*provider
+	quorum     int
+	retryAfter time.Duration
+
+	mu           sync.Mutex
+	quorumHeight uint64
+}
+
+type provider struct {
+	NamedCurrencyService
+
+	mu         sync.Mutex
+	latency    time.Duration
+	failures   int
+	lastError  error
+	retryAfter time.Time
+}
+
+// NewFailoverCurrencyService creates a new instance of FailoverCurrencyService for the given providers
+func NewFailoverCurrencyService(providers []NamedCurrencyService, opts ...*FailoverOptions) (*FailoverCurrencyService, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no provider is given")
+	}
+
+	s := &FailoverCurrencyService{
+		quorum:     defaultBlockHeightQuorum,
+		retryAfter: defaultRetryAfter,
+	}
+
+	for _, opt := range opts {
+		if opt.BlockHeightQuorum != 0 {
+			s.quorum = opt.BlockHeightQuorum
+		}
+		if opt.RetryAfter != 0 {
+			s.retryAfter = opt.RetryAfter
+		}
+	}
+
+	if s.quorum < 1 || s.quorum > len(providers) {
+		return nil, fmt.Errorf("block height quorum(%d) must be between 1 and the number of providers(%d)", s.quorum, len(providers))
+	}
+
+	for _, p := range providers {
+		s.providers = append(s.providers, &provider{NamedCurrencyService: p})
+	}
+
+	return s, nil
+}
+
+// GetAccountMovements fetches the account movements from the first provider which succeeds.
+// If a quorum is required, the movements are limited to the last block height agreed on, since
+// the provider might be on a fork or ahead of the others. The rest is fetched in a later call
+func (s *FailoverCurrencyService) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	errs := []string{}
+	for _, p := range s.route() {
+		start := time.Now()
+		am, err := p.GetAccountMovements(address, sinceBlockHeight)
+		p.record(time.Since(start), err, s.retryAfter)
+		if err == nil {
+			return s.limitToQuorumHeight(am), nil
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err.Error()))
+	}
+
+	return nil, fmt.Errorf("all providers failed, %s", strings.Join(errs, "; "))
+}
+
+// GetLatestBlockHeight fetches the latest block height which the quorum of providers agree on
+func (s *FailoverCurrencyService) GetLatestBlockHeight() (uint64, error) {
+	if s.quorum == 1 {
+		return s.latestBlockHeight()
+	}
+
+	type result struct {
+		bh  uint64
+		err error
+		p   *provider
+	}
+
+	results := make(chan result, len(s.providers))
+	for _, p := range s.providers {
+		go func(p *provider) {
+			start := time.Now()
+			bh, err := p.GetLatestBlockHeight()
+			p.record(time.Since(start), err, s.retryAfter)
+			results <- result{bh: bh, err: err, p: p}
+		}(p)
+	}
+
+	heights := []uint64{}
+	errs := []string{}
+	for range s.providers {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", r.p.Name, r.err.Error()))
+			continue
+		}
+		heights = append(heights, r.bh)
+	}
+
+	if len(heights) < s.quorum {
+		return 0, fmt.Errorf("%d of %d providers are required to agree on the latest block height but only %d succeeded, %s",
+			s.quorum, len(s.providers), len(heights), strings.Join(errs, "; "))
+	}
+
+	// Block heights which are reported by at least quorum providers
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+
+	s.mu.Lock()
+	s.quorumHeight = heights[s.quorum-1]
+	s.mu.Unlock()
+
+	return heights[s.quorum-1], nil
+}
+
+// Health returns the health reports of the providers in the configured order
+func (s *FailoverCurrencyService) Health() []ProviderHealth {
+	now := time.Now()
+	hs := make([]ProviderHealth, 0, len(s.providers))
+	for _, p := range s.providers {
+		p.mu.Lock()
+		hs = append(hs, ProviderHealth{
+			Name:      p.Name,
+			Healthy:   p.failures == 0 || !now.Before(p.retryAfter),
+			Latency:   p.latency,
+			Failures:  p.failures,
+			LastError: p.lastError,
+		})
+		p.mu.Unlock()
+	}
+
+	return hs
+}
+
+// Drops the transfers above the last quorum height and caps the scanned height at it.
+// Nothing is dropped until the quorum height is known
+func (s *FailoverCurrencyService) limitToQuorumHeight(am *domain.AccountMovements) *domain.AccountMovements {
+	s.mu.Lock()
+	h := s.quorumHeight
+	s.mu.Unlock()
+
+	if h == 0 || am == nil {
+		return am
+	}
+
+	ts := make([]*domain.Transfer, 0, len(am.Transfers))
+	for _, t := range am.Transfers {
+		if t.BlockHeight <= h {
+			ts = append(ts, t)
+		}
+	}
+	am.Transfers = ts
+
+	if am.ScannedHeight > h {
+		am.ScannedHeight = h
+	}
+
+	return am
+}
+
+func (s *FailoverCurrencyService) latestBlockHeight() (uint64, error) {
+	errs := []string{}
+	for _, p := range s.route() {
+		start := time.Now()
+		bh, err := p.GetLatestBlockHeight()
+		p.record(time.Since(start), err, s.retryAfter)
+		if err == nil {
+			return bh, nil
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err.Error()))
+	}
+
+	return 0, fmt.Errorf("all providers failed, %s", strings.Join(errs, "; "))
+}
+
+// Orders the providers to try. Healthy providers come first in the order of their latency,
+// then the failing ones in the order of their retry time as a last resort
+func (s *FailoverCurrencyService) route() []*provider {
+	now := time.Now()
+	type candidate struct {
+		p          *provider
+		healthy    bool
+		latency    time.Duration
+		retryAfter time.Time
+	}
+
+	cs := make([]candidate, 0, len(s.providers))
+	for _, p := range s.providers {
+		p.mu.Lock()
+		cs = append(cs, candidate{
+			p:          p,
+			healthy:    p.failures == 0 || !now.Before(p.retryAfter),
+			latency:    p.latency,
+			retryAfter: p.retryAfter,
+		})
+		p.mu.Unlock()
+	}
+
+	sort.SliceStable(cs, func(i, j int) bool {
+		if cs[i].healthy != cs[j].healthy {
+			return cs[i].healthy
+		}
+		if !cs[i].healthy {
+			return cs[i].retryAfter.Before(cs[j].retryAfter)
+		}
+		return cs[i].latency < cs[j].latency
+	})
+
+	ps := make([]*provider, 0, len(cs))
+	for _, c := range cs {
+		ps = append(ps, c.p)
+	}
+
+	return ps
+}
+
+// Records the result of a call. Latency is a moving average of the successful calls
+// and the retry time of a failing provider backs off with every consecutive failure
+func (p *provider) record(latency time.Duration, err error, retryAfter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		if p.latency == 0 {
+			p.latency = latency
+		} else {
+			p.latency = time.Duration((1-latencyWeight)*float64(p.latency) + latencyWeight*float64(latency))
+		}
+		p.failures = 0
+		return
+	}
+
+	p.failures++
+	p.lastError = err
+
+	backoff := retryAfter
+	for i := 1; i < p.failures && backoff < maxRetryAfter; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryAfter {
+		backoff = maxRetryAfter
+	}
+	p.retryAfter = time.Now().Add(backoff)
+}
+
+// failoverNotifier is FailoverCurrencyService over the providers some of which push notifications.
+// Addresses are watched on all of them and their notifications are merged, so that the notifications
+// keep coming as long as one of them is connected
+type failoverNotifier struct {
+	*FailoverCurrencyService
+	notifiers []domain.MovementNotifier
+
+	once sync.Once
+	ch   chan string
+}
+
+// WatchAddresses subscribes for the notifications of the given addresses on all providers
+// which push notifications. It fails only if none of them succeeds
+func (n *failoverNotifier) WatchAddresses(addresses []string) error {
+	errs := []string{}
+	for _, m := range n.notifiers {
+		if err := m.WatchAddresses(addresses); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) == len(n.notifiers) {
+		return fmt.Errorf("all providers failed, %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Notifications returns the channel of the addresses with new movements notified by any of the providers
+func (n *failoverNotifier) Notifications() <-chan string {
+	n.once.Do(func() {
+		n.ch = make(chan string, cap(n.notifiers[0].Notifications()))
+		for _, m := range n.notifiers {
+			go func(ch <-chan string) {
+				for address := range ch {
+					n.ch <- address
+				}
+			}(m.Notifications())
+		}
+	})
+
+	return n.ch
+}
+
+// IsConnected reports whether or not any of the providers is receiving notifications
+func (n *failoverNotifier) IsConnected() bool {
+	for _, m := range n.notifiers {
+		if m.IsConnected() {
+			return true
+		}
+	}
+	return false
+}
+
+// failoverScanning scans blocks over the providers which all scan blocks. Addresses are
+// normalized by the first provider and the others are matched and reported in that form
+type failoverScanning struct {
+	s *FailoverCurrencyService
+}
+
+// NormalizeAddress returns the form of the given address which the addresses in blocks are matched in
+func (f failoverScanning) NormalizeAddress(address string) (string, error) {
+	return f.primary().NormalizeAddress(address)
+}
+
+// GetBlockMovements fetches the movements in the block at the given height from the first provider which succeeds
+func (f failoverScanning) GetBlockMovements(blockHeight uint64, watched func(address string) bool) ([]*domain.AccountMovements, error) {
+	normalize := func(address string) string {
+		a, err := f.primary().NormalizeAddress(address)
+		if err != nil {
+			return ""
+		}
+		return a
+	}
+
+	errs := []string{}
+	for _, p := range f.s.route() {
+		start := time.Now()
+		acms, err := p.CurrencyService.(domain.BlockScanner).GetBlockMovements(blockHeight, func(address string) bool {
+			a := normalize(address)
+			return a != "" && watched(a)
+		})
+		p.record(time.Since(start), err, f.s.retryAfter)
+		if err == nil {
+			for _, acm := range acms {
+				acm.Address = normalize(acm.Address)
+			}
+			return acms, nil
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err.Error()))
+	}
+
+	return nil, fmt.Errorf("all providers failed, %s", strings.Join(errs, "; "))
+}
+
+func (f failoverScanning) primary() domain.BlockScanner {
+	return f.s.providers[0].CurrencyService.(domain.BlockScanner)
+}
+
+// failoverScanner is FailoverCurrencyService over the providers which all scan blocks
+type failoverScanner struct {
+	*FailoverCurrencyService
+	failoverScanning
+}
+
+// failoverScanningNotifier is failoverNotifier over the providers which all scan blocks
+type failoverScanningNotifier struct {
+	*failoverNotifier
+	failoverScanning
+}
+
+// withProviderExtensions keeps the given failover service as a MovementNotifier if any of its
+// providers is, and as a BlockScanner if all of its providers are
+func withProviderExtensions(s *FailoverCurrencyService) domain.CurrencyService {
+	notifiers := []domain.MovementNotifier{}
+	scans := true
+	for _, p := range s.providers {
+		if n, ok := p.CurrencyService.(domain.MovementNotifier); ok {
+			notifiers = append(notifiers, n)
+		}
+		if _, ok := p.CurrencyService.(domain.BlockScanner); !ok {
+			scans = false
+		}
+	}
+
+	switch {
+	case len(notifiers) > 0 && scans:
+		return &failoverScanningNotifier{
+			failoverNotifier: &failoverNotifier{FailoverCurrencyService: s, notifiers: notifiers},
+			failoverScanning: failoverScanning{s: s},
+		}
+	case len(notifiers) > 0:
+		return &failoverNotifier{FailoverCurrencyService: s, notifiers: notifiers}
+	case scans:
+		return &failoverScanner{FailoverCurrencyService: s, failoverScanning: failoverScanning{s: s}}
+	}
+
+	return s
+}
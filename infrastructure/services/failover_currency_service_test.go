@@ -0,0 +1,199 @@
+package services_test
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
+)
+
+type stubCurrencyService struct {
+	mu          sync.Mutex
+	blockHeight uint64
+	delay       time.Duration
+	failing     bool
+	calls       int
+	movements   *domain.AccountMovements
+	// Heights of the blocks which the address received 1 unit in
+	receivedIn []uint64
+}
+
+func (s *stubCurrencyService) GetAccountMovements(address string, sinceBlockHeight uint64) (*domain.AccountMovements, error) {
+	if err := s.call(); err != nil {
+		return nil, err
+	}
+	if s.movements != nil {
+		return s.movements, nil
+	}
+
+	am := domain.NewAccountMovements(address)
+	for _, h := range s.receivedIn {
+		am.Receive(h, 0, fmt.Sprintf("tx-%d", h), 0, big.NewInt(1), "")
+	}
+	am.ScannedHeight = s.blockHeight
+	return am, nil
+}
+
+func (s *stubCurrencyService) GetLatestBlockHeight() (uint64, error) {
+	if err := s.call(); err != nil {
+		return 0, err
+	}
+	return s.blockHeight, nil
+}
+
+func (s *stubCurrencyService) call() error {
+	s.mu.Lock()
+	s.calls++
+	failing := s.failing
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+	if failing {
+		return fmt.Errorf("provider is down")
+	}
+	return nil
+}
+
+func (s *stubCurrencyService) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *stubCurrencyService) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestFailoverCurrencyService_Failover(t *testing.T) {
+	p1 := &stubCurrencyService{blockHeight: 100}
+	p2 := &stubCurrencyService{blockHeight: 100}
+
+	s, err := services.NewFailoverCurrencyService([]services.NamedCurrencyService{
+		{Name: "p1", CurrencyService: p1},
+		{Name: "p2", CurrencyService: p2},
+	}, &services.FailoverOptions{RetryAfter: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1.setFailing(true)
+	if _, err := s.GetAccountMovements("address", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if p1.callCount() != 1 || p2.callCount() != 1 {
+		t.Fatalf("expected to fail over to p2 but got p1 calls: %d, p2 calls: %d", p1.callCount(), p2.callCount())
+	}
+
+	// Failing provider should be skipped until it's retried
+	if _, err := s.GetLatestBlockHeight(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p1.callCount() != 1 || p2.callCount() != 2 {
+		t.Fatalf("expected to route around p1 but got p1 calls: %d, p2 calls: %d", p1.callCount(), p2.callCount())
+	}
+
+	h := s.Health()
+	if h[0].Healthy || h[0].Failures != 1 || h[0].LastError == nil || !h[1].Healthy {
+		t.Fatalf("unexpected health reports %+v", h)
+	}
+
+	p1.setFailing(false)
+	time.Sleep(60 * time.Millisecond)
+
+	if !s.Health()[0].Healthy {
+		t.Fatal("expected p1 to be retried after the backoff")
+	}
+
+	p2.setFailing(true)
+	if _, err := s.GetLatestBlockHeight(); err != nil {
+		t.Fatal(err)
+	}
+
+	p1.setFailing(true)
+	if _, err := s.GetAccountMovements("address", 0); err == nil {
+		t.Fatal("expected an error when all providers fail but got nothing")
+	}
+}
+
+func TestFailoverCurrencyService_Latency(t *testing.T) {
+	slow := &stubCurrencyService{blockHeight: 100, delay: 20 * time.Millisecond}
+	fast := &stubCurrencyService{blockHeight: 100}
+
+	s, err := services.NewFailoverCurrencyService([]services.NamedCurrencyService{
+		{Name: "slow", CurrencyService: slow},
+		{Name: "fast", CurrencyService: fast},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both providers are tried once before their latencies are known
+	for i := 0; i < 5; i++ {
+		if _, err := s.GetLatestBlockHeight(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if slow.callCount() != 1 || fast.callCount() != 4 {
+		t.Fatalf("expected to prefer the fast provider but got slow calls: %d, fast calls: %d", slow.callCount(), fast.callCount())
+	}
+}
+
+func TestFailoverCurrencyService_BlockHeightQuorum(t *testing.T) {
+	p1 := &stubCurrencyService{blockHeight: 102}
+	p2 := &stubCurrencyService{blockHeight: 100}
+	p3 := &stubCurrencyService{blockHeight: 101}
+
+	s, err := services.NewFailoverCurrencyService([]services.NamedCurrencyService{
+		{Name: "p1", CurrencyService: p1},
+		{Name: "p2", CurrencyService: p2},
+		{Name: "p3", CurrencyService: p3},
+	}, &services.FailoverOptions{BlockHeightQuorum: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The highest block height reported by at least 2 providers
+	bh, err := s.GetLatestBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh != 101 {
+		t.Fatalf("expected latest block height is 101 but got %d", bh)
+	}
+
+	// The movements are limited to the block height agreed on
+	p1.receivedIn = []uint64{100, 102}
+	p2.setFailing(true)
+	p3.setFailing(true)
+	am, err := s.GetAccountMovements("address", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2.setFailing(false)
+
+	if len(am.Transfers) != 1 || am.Transfers[0].BlockHeight != 100 || am.ScannedHeight != 101 {
+		t.Fatalf("expected the movements up to block#101 but got %d transfers up to %d", len(am.Transfers), am.ScannedHeight)
+	}
+
+	p1.setFailing(true)
+	p3.setFailing(true)
+	if _, err := s.GetLatestBlockHeight(); err == nil {
+		t.Fatal("expected an error when the quorum cannot be reached but got nothing")
+	}
+
+	if _, err := services.NewFailoverCurrencyService([]services.NamedCurrencyService{
+		{Name: "p1", CurrencyService: p1},
+	}, &services.FailoverOptions{BlockHeightQuorum: 2}); err == nil {
+		t.Fatal("expected an error for a quorum more than the number of providers but got nothing")
+	}
+}
@@ -3,10 +3,13 @@ package services
 import (
 	domain "github.com/psychoplasma/crypto-balance-bot"
 	"github.com/psychoplasma/crypto-balance-bot/infrastructure/persistence/mongodb"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/persistence/postgresql"
+	"github.com/psychoplasma/crypto-balance-bot/infrastructure/persistence/sqlite"
 )
 
 // RepositoryServiceFactory keeps implemented repository services
 var RepositoryServiceFactory = map[string]domain.SubscriptionRepository{
 	"mongodb":    mongodb.NewSubscriptionRepository(),
-	"postgresql": nil,
+	"postgresql": postgresql.NewSubscriptionRepository(),
+	"sqlite":     sqlite.NewSubscriptionRepository(),
 }
@@ -0,0 +1,11 @@
+package cryptobot
+
+// ScanCursorRepository is an optional extension of SubscriptionRepository
+// which keeps the height of the last scanned block for each currency
+type ScanCursorRepository interface {
+	// GetScanCursor returns the height of the last scanned block for the given
+	// currency and false if the currency has not been scanned yet
+	GetScanCursor(currencySymbol string) (uint64, bool, error)
+	// SaveScanCursor persists the height of the last scanned block for the given currency
+	SaveScanCursor(currencySymbol string, blockHeight uint64) error
+}
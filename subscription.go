@@ -6,13 +6,22 @@ import (
 	"log"
 	"math/big"
 	"strings"
+	"time"
 )
 
 // Represents errors related to subscription
 var (
 	ErrInvalidID = errors.New("invalid identity")
+	// ErrConcurrentModification is returned by SubscriptionRepository.Save when the
+	// subscription has been modified by someone else since it was loaded
+	ErrConcurrentModification = errors.New("subscription has been modified concurrently")
 )
 
+// AppliedTransfersRetention is the number of blocks, counting back from the last
+// updated block height, in which the identities of the applied transfers are kept.
+// Transfers older than this window are considered as already applied.
+const AppliedTransfersRetention = 100
+
 // SubscriptionRepository represents common API for subscriptions repository
 type SubscriptionRepository interface {
 	UnitOfWork
@@ -30,7 +39,13 @@ type SubscriptionRepository interface {
 	GetAllForUser(userID string) ([]*Subscription, error)
 	// GetAllForCurrency returns all subscriptions for the given currency that are updated before the given blocknumber
 	GetAllForCurrency(currencySymbol string, updatedBefore uint64) ([]*Subscription, error)
-	// Save persists/updates the given subscription
+	// GetAllForAccount returns all subscriptions for the given account of the given currency
+	GetAllForAccount(currencySymbol string, account string) ([]*Subscription, error)
+	// GetAllWithDigest returns all subscriptions for the given currency which are in digest mode
+	GetAllWithDigest(currencySymbol string) ([]*Subscription, error)
+	// Save persists/updates the given subscription if its version is still the persisted one.
+	// Otherwise returns ErrConcurrentModification. Its version is incremented once it is committed,
+	// i.e. when the unit of work succeeds, so a subscription is saved once in a unit of work
 	Save(s *Subscription) error
 	// Remove removes the given subscription from the persistance
 	Remove(s *Subscription) error
@@ -47,6 +62,10 @@ type Subscription struct {
 	totalReceived       *big.Int
 	totalSpent          *big.Int
 	filters             []*Filter
+	digest              *Digest
+	appliedTransfers    map[string]uint64 // Transfer ID => block height
+	version             uint64
+	events              []interface{} // Domain events to be published once saved
 }
 
 // UserIDFrom extracts UserID from SubscriptionID.
@@ -80,6 +99,7 @@ func NewSubscription(
 		c:                   c,
 		account:             account,
 		filters:             make([]*Filter, 0),
+		appliedTransfers:    make(map[string]uint64),
 		totalReceived:       new(big.Int),
 		totalSpent:          new(big.Int),
 		blockHeight:         startingBlockHeight,
@@ -100,6 +120,9 @@ func DeepCopySubscription(
 	totalSpent *big.Int,
 	blockHeight uint64,
 	staringBlockHeight uint64,
+	digest *Digest,
+	appliedTransfers map[string]uint64,
+	version uint64,
 ) (*Subscription, error) {
 	s, err := NewSubscription(id, userID, account, c, staringBlockHeight)
 	if err != nil {
@@ -110,6 +133,11 @@ func DeepCopySubscription(
 	s.filters = filters
 	s.totalReceived = totalReceived
 	s.totalSpent = totalSpent
+	s.digest = digest
+	s.version = version
+	for id, bh := range appliedTransfers {
+		s.appliedTransfers[id] = bh
+	}
 
 	return s, nil
 }
@@ -156,6 +184,40 @@ func (s *Subscription) Filters() []*Filter {
 	return s.filters
 }
 
+// Digest returns digest property. Returns nil if the subscription is not in digest mode
+func (s *Subscription) Digest() *Digest {
+	return s.digest
+}
+
+// AppliedTransfers returns the identities of the transfers applied
+// within the retention window, mapped to their block heights
+func (s *Subscription) AppliedTransfers() map[string]uint64 {
+	ats := make(map[string]uint64, len(s.appliedTransfers))
+	for id, bh := range s.appliedTransfers {
+		ats[id] = bh
+	}
+	return ats
+}
+
+// Version returns the number of times the subscription has been saved
+func (s *Subscription) Version() uint64 {
+	return s.version
+}
+
+// IncrementVersion is called by the repositories once the subscription is saved
+func (s *Subscription) IncrementVersion() {
+	s.version++
+}
+
+// PublishEvents publishes the domain events raised since they were last published.
+// They are kept until the changes raising them are saved, so that no event is
+// published for the changes which are rolled back or done again after a conflict
+func (s *Subscription) PublishEvents() {
+	events := s.events
+	s.events = nil
+	DomainEventPublisherInstance().PublishAll(events)
+}
+
 // UserID returns userID property
 func (s *Subscription) UserID() string {
 	return s.userID
@@ -185,7 +247,41 @@ func (s *Subscription) RemoveFilters() {
 	s.filters = make([]*Filter, 0)
 }
 
-// ApplyMovements applies a set of movements to the current state of this account
+// EnableDigest switches the subscription to digest mode. Filtered transfers will be
+// buffered and published as a summary on the given schedule instead of one by one
+func (s *Subscription) EnableDigest(schedule string, timeZone string, now time.Time) {
+	if s.digest != nil {
+		s.digest = DeepCopyDigest(schedule, timeZone, s.digest.LastSentAt(), s.digest.Transfers())
+		return
+	}
+
+	s.digest = NewDigest(schedule, timeZone, now)
+}
+
+// DisableDigest switches the subscription back to publishing every movement.
+// Any transfers buffered since the last summary are discarded
+func (s *Subscription) DisableDigest() {
+	s.digest = nil
+}
+
+// PublishDigest raises AccountDigestReadyEvent with the summary of the transfers
+// buffered since the last digest and starts a new digest period
+func (s *Subscription) PublishDigest(now time.Time) {
+	if s.digest == nil {
+		return
+	}
+
+	summary := s.digest.flush(now)
+	if summary.TransferCount() > 0 {
+		s.events = append(s.events,
+			NewAccountDigestReadyEvent(s.ID(), s.account, s.Currency(), summary))
+	}
+}
+
+// ApplyMovements applies a set of movements to the current state of this account and raises
+// AccountAssetsMovedEvent with the filtered transfers unless they are buffered for the digest.
+// Applying is idempotent, the transfers which have already been applied are skipped.
+// Therefore the same movements can be applied more than once without double counting.
 func (s *Subscription) ApplyMovements(acms *AccountMovements) {
 	if acms == nil {
 		return
@@ -197,10 +293,16 @@ func (s *Subscription) ApplyMovements(acms *AccountMovements) {
 	}
 
 	acms.Sort()
+	applied := make([]*Transfer, 0)
 	for _, t := range acms.Transfers {
-		if t.BlockHeight < s.BlockHeight() {
-			log.Printf("movement's blockheight(%d) is less than the last updated blockheight(%d), not applying", t.BlockHeight, s.BlockHeight())
-			return
+		if _, ok := s.appliedTransfers[t.ID()]; ok {
+			continue
+		}
+
+		// The starting block is exclusive, only the movements after it are applied
+		if t.BlockHeight <= s.StartingBlockHeight() || t.BlockHeight+AppliedTransfersRetention < s.BlockHeight() {
+			log.Printf("movement's blockheight(%d) is out of the applicable range of the subscription(%s), not applying", t.BlockHeight, s.ID())
+			continue
 		}
 
 		switch t.Type {
@@ -214,16 +316,38 @@ func (s *Subscription) ApplyMovements(acms *AccountMovements) {
 			continue
 		}
 
-		s.blockHeight = t.BlockHeight
+		s.appliedTransfers[t.ID()] = t.BlockHeight
+		if t.BlockHeight > s.blockHeight {
+			s.blockHeight = t.BlockHeight
+		}
+		applied = append(applied, t)
+	}
+	// Nothing is left to apply up to the scanned height
+	if acms.ScannedHeight > s.blockHeight {
+		s.blockHeight = acms.ScannedHeight
+	}
+	s.pruneAppliedTransfers()
+
+	filteredTransfers := s.applyFilters(applied)
+	if len(filteredTransfers) > 0 && s.digest != nil {
+		s.digest.buffer(filteredTransfers)
+		return
 	}
 
-	filteredTransfers := s.applyFilters(acms.Transfers)
 	if len(filteredTransfers) > 0 {
-		DomainEventPublisherInstance().Publish(
+		s.events = append(s.events,
 			NewAccountAssetsMovedEvent(s.ID(), s.account, s.Currency(), filteredTransfers))
 	}
 }
 
+func (s *Subscription) pruneAppliedTransfers() {
+	for id, bh := range s.appliedTransfers {
+		if bh+AppliedTransfersRetention < s.blockHeight {
+			delete(s.appliedTransfers, id)
+		}
+	}
+}
+
 func (s *Subscription) applyFilters(ts []*Transfer) []*Transfer {
 	if len(s.filters) == 0 {
 		return ts
@@ -4,17 +4,22 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	domain "github.com/psychoplasma/crypto-balance-bot"
-	"github.com/psychoplasma/crypto-balance-bot/infrastructure/services"
 )
 
+var eth = domain.Currency{
+	Decimal: big.NewInt(1000000000000000000),
+	Symbol:  "eth",
+}
+
 func TestApply(t *testing.T) {
 	addr := "test-addr-1"
 	mv1 := domain.NewAccountMovements(addr)
-	mv1.Receive(10, 1613721092, "txhash-test1", big.NewInt(5), "addr-sender")
+	mv1.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
 
-	s, err := domain.NewSubscription("sub-1", "user-1", addr, services.ETH, 0)
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -31,6 +36,12 @@ func TestApply(t *testing.T) {
 		t.Fatalf("expected balance diff is %d but got %d", 5, diff.Int64())
 	}
 
+	// Events are kept until the subscription is saved
+	if subscriber.IsEventHandled() {
+		t.Fatal("expected not to publish any event before saving but got an AccountAssetsMovedEvent")
+	}
+
+	s.PublishEvents()
 	if !subscriber.IsEventHandled() {
 		t.Fatal("expected to publish an AccountAssetsMovedEvent but got nothing")
 	}
@@ -39,9 +50,9 @@ func TestApply(t *testing.T) {
 func TestApply_WithAlreadyAppliedMovements(t *testing.T) {
 	addr := "test-addr-1"
 	mv1 := domain.NewAccountMovements(addr)
-	mv1.Receive(10, 1613721092, "txhash-test1", big.NewInt(5), "addr-sender")
+	mv1.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
 
-	s, err := domain.NewSubscription("sub-1", "user-1", addr, services.ETH, 0)
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -52,16 +63,18 @@ func TestApply_WithAlreadyAppliedMovements(t *testing.T) {
 	domain.DomainEventPublisherInstance().Subscribe(eventSubs)
 
 	s.ApplyMovements(mv1)
+	s.PublishEvents()
 	if !eventSubs.IsEventHandled() {
 		t.Fatal("expected to publish an AccountAssetsMovedEvent but got nothing")
 	}
 
 	mv2 := domain.NewAccountMovements(addr)
-	mv2.Receive(9, 1613721092, "txhash-test1", big.NewInt(9), "addr-sender")
+	mv2.Receive(9, 1613721092, "txhash-test1", 0, big.NewInt(9), "addr-sender")
 	eventSubs.Reset()
 	domain.DomainEventPublisherInstance().Reset()
 	domain.DomainEventPublisherInstance().Subscribe(eventSubs)
 	s.ApplyMovements(mv2)
+	s.PublishEvents()
 	if eventSubs.IsEventHandled() {
 		t.Fatal("expected not to publish any event but got an AccountAssetsMovedEvent")
 	}
@@ -76,16 +89,16 @@ func TestApply_WithFilters(t *testing.T) {
 	expectedTransferCount := 1
 	addr := "test-addr"
 	mv := domain.NewAccountMovements(addr)
-	mv.Receive(9, 1613720092, "txhash-test1", big.NewInt(4), "addr-tracked")
-	mv.Receive(10, 1613721092, "txhash-test2", big.NewInt(5), "addr-tracked")
-	mv.Spend(11, 1613722092, "txhash-test3", big.NewInt(10), "addr-untracked")
-	mv.Receive(12, 1613723092, "txhash-test4", big.NewInt(6), "addr-unknown")
+	mv.Receive(9, 1613720092, "txhash-test1", 0, big.NewInt(4), "addr-tracked")
+	mv.Receive(10, 1613721092, "txhash-test2", 0, big.NewInt(5), "addr-tracked")
+	mv.Spend(11, 1613722092, "txhash-test3", 0, big.NewInt(10), "addr-untracked")
+	mv.Receive(12, 1613723092, "txhash-test4", 0, big.NewInt(6), "addr-unknown")
 
 	amountFilter, _ := domain.NewAmountFilter("5", true)
 	addressOnFilter, _ := domain.NewAddressOnFilter("addr-tracked", true)
 	addressOffFilter, _ := domain.NewAddressOffFilter("addr-untracked", true)
 
-	s, err := domain.NewSubscription("sub-1", "user-1", addr, services.ETH, 0)
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -99,6 +112,7 @@ func TestApply_WithFilters(t *testing.T) {
 	domain.DomainEventPublisherInstance().Subscribe(subscriber)
 
 	s.ApplyMovements(mv)
+	s.PublishEvents()
 
 	if !subscriber.IsEventHandled() {
 		t.Fatal("expected to publish an AccountAssetsMovedEvent but got nothing")
@@ -109,3 +123,236 @@ func TestApply_WithFilters(t *testing.T) {
 		t.Fatalf("expected to have %d transfers but got %d", expectedTransferCount, len(evt.Transfers()))
 	}
 }
+
+func TestApply_WithDigest(t *testing.T) {
+	addr := "test-addr"
+	mv := domain.NewAccountMovements(addr)
+	mv.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	mv.Spend(11, 1613722092, "txhash-test2", 0, big.NewInt(2), "addr-receiver")
+
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableDigest("@daily", "UTC", time.Now())
+
+	movedSubscriber := NewMockEventSubscriber(
+		reflect.TypeOf(new(domain.AccountAssetsMovedEvent)))
+	digestSubscriber := NewMockEventSubscriber(
+		reflect.TypeOf(new(domain.AccountDigestReadyEvent)))
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(movedSubscriber)
+	domain.DomainEventPublisherInstance().Subscribe(digestSubscriber)
+
+	s.ApplyMovements(mv)
+	s.PublishEvents()
+
+	if movedSubscriber.IsEventHandled() {
+		t.Fatal("expected not to publish any AccountAssetsMovedEvent in digest mode but got one")
+	}
+
+	if len(s.Digest().Transfers()) != 2 {
+		t.Fatalf("expected to buffer %d transfers but got %d", 2, len(s.Digest().Transfers()))
+	}
+
+	s.PublishDigest(time.Now())
+	s.PublishEvents()
+
+	if !digestSubscriber.IsEventHandled() {
+		t.Fatal("expected to publish an AccountDigestReadyEvent but got nothing")
+	}
+
+	summary := digestSubscriber.lastEvent.(*domain.AccountDigestReadyEvent).Summary()
+	if summary.ReceivedCount != 1 || summary.SpentCount != 1 {
+		t.Fatalf("expected (received, spent) counts (%d, %d) but got (%d, %d)", 1, 1, summary.ReceivedCount, summary.SpentCount)
+	}
+
+	if summary.TotalReceived.Cmp(big.NewInt(5)) != 0 || summary.TotalSpent.Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("expected (received, spent) totals (%d, %d) but got (%s, %s)", 5, 2, summary.TotalReceived, summary.TotalSpent)
+	}
+
+	if len(summary.LargestTransfers) != 2 || summary.LargestTransfers[0].Amount.Cmp(big.NewInt(5)) != 0 {
+		t.Fatal("expected the largest transfers to be sorted by amount in descending order")
+	}
+
+	if len(s.Digest().Transfers()) != 0 {
+		t.Fatalf("expected to clear the buffered transfers but got %d", len(s.Digest().Transfers()))
+	}
+}
+
+func TestPublishDigest_WithoutTransfers(t *testing.T) {
+	s, err := domain.NewSubscription("sub-1", "user-1", "test-addr", eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableDigest("@daily", "UTC", time.Now())
+
+	subscriber := NewMockEventSubscriber(
+		reflect.TypeOf(new(domain.AccountDigestReadyEvent)))
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(subscriber)
+
+	now := time.Now()
+	s.PublishDigest(now)
+	s.PublishEvents()
+
+	if subscriber.IsEventHandled() {
+		t.Fatal("expected not to publish an empty digest but got one")
+	}
+
+	if !s.Digest().LastSentAt().Equal(now) {
+		t.Fatalf("expected to start a new digest period at %s but got %s", now, s.Digest().LastSentAt())
+	}
+}
+
+func TestApply_WithOverlappingMovements(t *testing.T) {
+	addr := "test-addr-1"
+	mv1 := domain.NewAccountMovements(addr)
+	mv1.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	mv1.Receive(10, 1613721092, "txhash-test1", 1, big.NewInt(3), "addr-sender")
+	// The same transfer returned twice, e.g. from overlapping pages
+	mv1.Receive(10, 1613721092, "txhash-test1", 1, big.NewInt(3), "addr-sender")
+
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriber := NewMockEventSubscriber(
+		reflect.TypeOf(new(domain.AccountAssetsMovedEvent)))
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(subscriber)
+
+	s.ApplyMovements(mv1)
+	s.PublishEvents()
+
+	if s.TotalReceived().Cmp(big.NewInt(8)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 8, s.TotalReceived())
+	}
+
+	// Re-fetched movements including the already applied ones and a new one in a lower block
+	mv2 := domain.NewAccountMovements(addr)
+	mv2.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	mv2.Receive(9, 1613721000, "txhash-test2", 0, big.NewInt(7), "addr-sender")
+	mv2.Spend(11, 1613722092, "txhash-test3", 0, big.NewInt(2), "addr-receiver")
+	subscriber.Reset()
+
+	s.ApplyMovements(mv2)
+	s.PublishEvents()
+
+	if s.TotalReceived().Cmp(big.NewInt(15)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 15, s.TotalReceived())
+	}
+
+	if s.TotalSpent().Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("expected total spent is %d but got %s", 2, s.TotalSpent())
+	}
+
+	if s.BlockHeight() != 11 {
+		t.Fatalf("expected block height is %d but got %d", 11, s.BlockHeight())
+	}
+
+	evt := subscriber.lastEvent.(*domain.AccountAssetsMovedEvent)
+	if len(evt.Transfers()) != 2 {
+		t.Fatalf("expected to publish %d new transfers but got %d", 2, len(evt.Transfers()))
+	}
+}
+
+func TestApply_WithStaleMovements(t *testing.T) {
+	addr := "test-addr-1"
+	startingBlockHeight := uint64(1000)
+	mv := domain.NewAccountMovements(addr)
+	mv.Receive(startingBlockHeight-1, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	mv.Receive(startingBlockHeight+1, 1613721092, "txhash-test2", 0, big.NewInt(3), "addr-sender")
+
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, startingBlockHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain.DomainEventPublisherInstance().Reset()
+
+	s.ApplyMovements(mv)
+
+	if s.TotalReceived().Cmp(big.NewInt(3)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 3, s.TotalReceived())
+	}
+
+	// Transfers beyond the retention window are not tracked anymore and must not be applied
+	mv = domain.NewAccountMovements(addr)
+	mv.Receive(startingBlockHeight+domain.AppliedTransfersRetention+2, 1613721092, "txhash-test3", 0, big.NewInt(1), "addr-sender")
+	mv.Receive(startingBlockHeight+1, 1613721092, "txhash-test2", 0, big.NewInt(3), "addr-sender")
+	s.ApplyMovements(mv)
+
+	if s.TotalReceived().Cmp(big.NewInt(4)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 4, s.TotalReceived())
+	}
+
+	if _, ok := s.AppliedTransfers()["txhash-test2:0:0"]; ok {
+		t.Fatal("expected to prune the transfers beyond the retention window")
+	}
+}
+
+func TestApply_WithScannedHeight(t *testing.T) {
+	addr := "test-addr-1"
+	mv := domain.NewAccountMovements(addr)
+	mv.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	mv.ScannedHeight = 1000
+
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain.DomainEventPublisherInstance().Reset()
+
+	s.ApplyMovements(mv)
+
+	if s.BlockHeight() != 1000 {
+		t.Fatalf("expected block height %d but got %d", 1000, s.BlockHeight())
+	}
+
+	// Nothing found in the scanned range still advances the block height
+	mv = domain.NewAccountMovements(addr)
+	mv.ScannedHeight = 2000
+	s.ApplyMovements(mv)
+
+	if s.BlockHeight() != 2000 {
+		t.Fatalf("expected block height %d but got %d", 2000, s.BlockHeight())
+	}
+
+	if s.TotalReceived().Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 5, s.TotalReceived())
+	}
+}
+
+func TestApply_WithSharedMovements(t *testing.T) {
+	addr := "test-addr-1"
+	mv := domain.NewAccountMovements(addr)
+	mv.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	mv.Receive(20, 1613721192, "txhash-test2", 0, big.NewInt(7), "addr-sender")
+
+	s1, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subscribed after the first transfer
+	s2, err := domain.NewSubscription("sub-2", "user-2", addr, eth, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain.DomainEventPublisherInstance().Reset()
+
+	// The movements fetched once for both subscriptions
+	s1.ApplyMovements(mv.Since(s1.BlockHeight() + 1).Sort())
+	s2.ApplyMovements(mv.Since(s2.BlockHeight() + 1).Sort())
+
+	if s1.TotalReceived().Cmp(big.NewInt(12)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 12, s1.TotalReceived())
+	}
+
+	if s2.TotalReceived().Cmp(big.NewInt(7)) != 0 {
+		t.Fatalf("expected total received is %d but got %s", 7, s2.TotalReceived())
+	}
+
+	if len(mv.Transfers) != 2 {
+		t.Fatalf("expected the shared movements not to be modified but got %d transfers", len(mv.Transfers))
+	}
+}
@@ -6,6 +6,15 @@ type UnitOfWork interface {
 	Begin() error
 	// Fail rollbacks repository to the state before this work
 	Fail()
-	// Success finalizes the work done on repository
-	Success()
+	// Success finalizes the work done on repository. Returns ErrConcurrentModification
+	// if the work conflicts with another one, in which case none of it is persisted
+	Success() error
+}
+
+// SessionRepository is an optional extension of SubscriptionRepository for the
+// repositories whose units of work can be done concurrently, each on a session of its own
+type SessionRepository interface {
+	// NewSession returns a repository on the same connection, whose units
+	// of work are independent of the units of work of the others
+	NewSession() SubscriptionRepository
 }
@@ -0,0 +1,143 @@
+package cryptobot
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// DivergenceReport is the result of cross-checking the movements applied
+// to a subscription against the ones reported by another provider
+type DivergenceReport struct {
+	SubscriptionID string
+	Account        string
+	Currency       Currency
+	Provider       string
+	// Movements are compared up to this block height, which is the last updated block height of the subscription
+	BlockHeight uint64
+	// MissingTransfers are reported by the provider but not applied to the subscription
+	MissingTransfers []*Transfer
+	// UnexpectedTransfers are the identities of the transfers applied
+	// to the subscription but not reported by the provider
+	UnexpectedTransfers []string
+	// Totals applied to the subscription and the ones reported by the provider
+	TotalReceived         *big.Int
+	TotalSpent            *big.Int
+	ProviderTotalReceived *big.Int
+	ProviderTotalSpent    *big.Int
+}
+
+// Diverged reports whether or not the provider disagrees with the applied movements
+func (r *DivergenceReport) Diverged() bool {
+	return len(r.MissingTransfers) > 0 ||
+		len(r.UnexpectedTransfers) > 0 ||
+		r.TotalReceived.Cmp(r.ProviderTotalReceived) != 0 ||
+		r.TotalSpent.Cmp(r.ProviderTotalSpent) != 0
+}
+
+// CrossCheck compares the movements applied to this subscription with the given movements
+// fetched from another provider after the starting block height of the subscription.
+// Totals are compared over the whole range, whereas the transfers are compared within
+// the retention window of the applied transfers. Publishes ProviderDivergenceDetectedEvent
+// if they diverge. Movements after the last updated block height are not yet applied, so ignored.
+// The given movements must be scanned up to the last updated block height at least
+func (s *Subscription) CrossCheck(provider string, acms *AccountMovements) (*DivergenceReport, error) {
+	if acms == nil || acms.Address != s.account {
+		return nil, fmt.Errorf("movements do not belong to the account(%s) of the subscription(%s)", s.account, s.id)
+	}
+
+	// Totals cannot be compared over a part of the applied range
+	if acms.ScannedHeight != 0 && acms.ScannedHeight < s.blockHeight {
+		return nil, fmt.Errorf("movements are scanned up to block#%d but the subscription(%s) is updated up to block#%d",
+			acms.ScannedHeight, s.id, s.blockHeight)
+	}
+
+	r := &DivergenceReport{
+		SubscriptionID:        s.id,
+		Account:               s.account,
+		Currency:              s.c,
+		Provider:              provider,
+		BlockHeight:           s.blockHeight,
+		MissingTransfers:      make([]*Transfer, 0),
+		UnexpectedTransfers:   make([]string, 0),
+		TotalReceived:         new(big.Int).Set(s.totalReceived),
+		TotalSpent:            new(big.Int).Set(s.totalSpent),
+		ProviderTotalReceived: new(big.Int),
+		ProviderTotalSpent:    new(big.Int),
+	}
+
+	reported := make(map[string]bool)
+	for _, t := range acms.Sort().Transfers {
+		// Movements at the starting block height are never applied as well
+		if t.BlockHeight <= s.startingBlockHeight || t.BlockHeight > s.blockHeight {
+			continue
+		}
+
+		switch t.Type {
+		case Received:
+			r.ProviderTotalReceived.Add(r.ProviderTotalReceived, t.Amount)
+		case Spent:
+			r.ProviderTotalSpent.Add(r.ProviderTotalSpent, t.Amount)
+		default:
+			continue
+		}
+
+		if t.BlockHeight+AppliedTransfersRetention < s.blockHeight {
+			continue
+		}
+
+		reported[t.ID()] = true
+		if _, applied := s.appliedTransfers[t.ID()]; !applied {
+			r.MissingTransfers = append(r.MissingTransfers, t)
+		}
+	}
+
+	for id := range s.appliedTransfers {
+		if !reported[id] {
+			r.UnexpectedTransfers = append(r.UnexpectedTransfers, id)
+		}
+	}
+
+	if r.Diverged() {
+		DomainEventPublisherInstance().Publish(NewProviderDivergenceDetectedEvent(r))
+	}
+
+	return r, nil
+}
+
+// ProviderDivergenceDetectedEvent represents a domain event upon a provider
+// disagreeing with the movements applied to a subscription
+type ProviderDivergenceDetectedEvent struct {
+	version    int
+	occurredOn time.Time
+	r          *DivergenceReport
+}
+
+// NewProviderDivergenceDetectedEvent creates a new instance from DivergenceReport
+func NewProviderDivergenceDetectedEvent(r *DivergenceReport) *ProviderDivergenceDetectedEvent {
+	return &ProviderDivergenceDetectedEvent{
+		version:    1,
+		occurredOn: time.Now(),
+		r:          r,
+	}
+}
+
+// Report returns the report property
+func (evt *ProviderDivergenceDetectedEvent) Report() *DivergenceReport {
+	return evt.r
+}
+
+// SubscriptionID returns the subscription id of the report
+func (evt *ProviderDivergenceDetectedEvent) SubscriptionID() string {
+	return evt.r.SubscriptionID
+}
+
+// OccurredOn returns event time
+func (evt *ProviderDivergenceDetectedEvent) OccurredOn() time.Time {
+	return evt.occurredOn
+}
+
+// EventVersion returns event version
+func (evt *ProviderDivergenceDetectedEvent) EventVersion() int {
+	return evt.version
+}
@@ -0,0 +1,153 @@
+package cryptobot_test
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	domain "github.com/psychoplasma/crypto-balance-bot"
+)
+
+func TestCrossCheck(t *testing.T) {
+	addr := "test-addr-1"
+	applied := domain.NewAccountMovements(addr)
+	applied.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	applied.Spend(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-receiver")
+
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ApplyMovements(applied)
+
+	subscriber := NewMockEventSubscriber(
+		reflect.TypeOf(new(domain.ProviderDivergenceDetectedEvent)))
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(subscriber)
+
+	// Movements after the last updated block height are not applied yet, so should be ignored
+	reported := domain.NewAccountMovements(addr)
+	reported.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	reported.Spend(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-receiver")
+	reported.Receive(30, 1613721292, "txhash-test3", 0, big.NewInt(7), "addr-sender")
+
+	r, err := s.CrossCheck("secondary", reported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Diverged() || subscriber.IsEventHandled() {
+		t.Fatalf("expected no divergence but got %+v", r)
+	}
+}
+
+func TestCrossCheck_Diverged(t *testing.T) {
+	addr := "test-addr-1"
+	applied := domain.NewAccountMovements(addr)
+	applied.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	applied.Spend(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-receiver")
+
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ApplyMovements(applied)
+
+	subscriber := NewMockEventSubscriber(
+		reflect.TypeOf(new(domain.ProviderDivergenceDetectedEvent)))
+	domain.DomainEventPublisherInstance().Reset()
+	domain.DomainEventPublisherInstance().Subscribe(subscriber)
+
+	// Provider misses the spent and reports a receive which is not applied
+	reported := domain.NewAccountMovements(addr)
+	reported.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	reported.Receive(15, 1613721142, "txhash-test4", 1, big.NewInt(2), "addr-sender")
+
+	r, err := s.CrossCheck("secondary", reported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Diverged() {
+		t.Fatal("expected divergence but got nothing")
+	}
+
+	if len(r.MissingTransfers) != 1 || r.MissingTransfers[0].TxHash != "txhash-test4" {
+		t.Fatalf("expected txhash-test4 to be missing but got %+v", r.MissingTransfers)
+	}
+
+	if len(r.UnexpectedTransfers) != 1 || r.UnexpectedTransfers[0] != "txhash-test2:0:1" {
+		t.Fatalf("expected txhash-test2 to be unexpected but got %+v", r.UnexpectedTransfers)
+	}
+
+	if r.ProviderTotalReceived.Cmp(big.NewInt(7)) != 0 || r.ProviderTotalSpent.Sign() != 0 {
+		t.Fatalf("expected provider totals are 7 received and 0 spent but got %s and %s", r.ProviderTotalReceived, r.ProviderTotalSpent)
+	}
+
+	if !subscriber.IsEventHandled() {
+		t.Fatal("expected to publish a ProviderDivergenceDetectedEvent but got nothing")
+	}
+
+	evt := subscriber.lastEvent.(*domain.ProviderDivergenceDetectedEvent)
+	if evt.SubscriptionID() != s.ID() || evt.Report() != r {
+		t.Fatalf("unexpected event %+v", evt)
+	}
+
+	if _, err := s.CrossCheck("secondary", domain.NewAccountMovements("another-addr")); err == nil {
+		t.Fatal("expected an error for the movements of another account but got nothing")
+	}
+}
+
+func TestCrossCheck_PartiallyScanned(t *testing.T) {
+	addr := "test-addr-1"
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := domain.NewAccountMovements(addr)
+	applied.Receive(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-sender")
+	s.ApplyMovements(applied)
+	domain.DomainEventPublisherInstance().Reset()
+
+	reported := domain.NewAccountMovements(addr)
+	reported.ScannedHeight = 15
+	if _, err := s.CrossCheck("secondary", reported); err == nil {
+		t.Fatal("expected an error for the movements scanned up to a lower block height but got nothing")
+	}
+
+	reported.ScannedHeight = 20
+	reported.Receive(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-sender")
+	r, err := s.CrossCheck("secondary", reported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Diverged() {
+		t.Fatalf("expected no divergence but got %+v", r)
+	}
+}
+
+func TestCrossCheck_StartingBlockHeight(t *testing.T) {
+	addr := "test-addr-1"
+	s, err := domain.NewSubscription("sub-1", "user-1", addr, eth, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := domain.NewAccountMovements(addr)
+	applied.Receive(10, 1613721092, "txhash-test1", 0, big.NewInt(5), "addr-sender")
+	applied.Receive(20, 1613721192, "txhash-test2", 0, big.NewInt(3), "addr-sender")
+	s.ApplyMovements(applied)
+	domain.DomainEventPublisherInstance().Reset()
+
+	// Movements at the starting block height are neither applied nor compared
+	r, err := s.CrossCheck("secondary", applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Diverged() || r.TotalReceived.Cmp(big.NewInt(3)) != 0 {
+		t.Fatalf("expected no divergence with %d received but got %+v", 3, r)
+	}
+}